@@ -0,0 +1,439 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/przebro/databazaar/collection"
+	"github.com/przebro/databazaar/selector"
+	"github.com/przebro/localstore/internal/document"
+)
+
+// AggregateStage - a single step of an aggregation pipeline, stages are created with StageMatch, StageGroup, StageSort, StageLimit, StageProject and StageDistinct
+type AggregateStage interface {
+	// processor - creates a processor of the stage with an empty state, so a stage can be used in many pipelines
+	processor() processor
+}
+
+type processor interface {
+	// push - processes a single document, returns false if the stage doesn't need more documents
+	push(doc map[string]interface{}) bool
+	// flush - called after the last document
+	flush()
+	// chain - sets the next processor
+	chain(next processor)
+}
+
+// Accumulator - computes a value over all documents of a group, accumulators are created with AccCount, AccSum, AccAvg, AccMin and AccMax
+type Accumulator struct {
+	name  string
+	field string
+	op    string
+}
+
+const (
+	accCount = "count"
+	accSum   = "sum"
+	accAvg   = "avg"
+	accMin   = "min"
+	accMax   = "max"
+)
+
+// AccCount - counts documents in a group
+func AccCount(name string) Accumulator {
+	return Accumulator{name: name, op: accCount}
+}
+
+// AccSum - sums numeric values of a field
+func AccSum(name, field string) Accumulator {
+	return Accumulator{name: name, field: field, op: accSum}
+}
+
+// AccAvg - computes an average of numeric values of a field
+func AccAvg(name, field string) Accumulator {
+	return Accumulator{name: name, field: field, op: accAvg}
+}
+
+// AccMin - finds the lowest value of a field
+func AccMin(name, field string) Accumulator {
+	return Accumulator{name: name, field: field, op: accMin}
+}
+
+// AccMax - finds the highest value of a field
+func AccMax(name, field string) Accumulator {
+	return Accumulator{name: name, field: field, op: accMax}
+}
+
+// SortField - a field used to order documents
+type SortField struct {
+	field string
+	desc  bool
+}
+
+// SortAsc - orders documents by a field in ascending order
+func SortAsc(field string) SortField {
+	return SortField{field: field}
+}
+
+// SortDesc - orders documents by a field in descending order
+func SortDesc(field string) SortField {
+	return SortField{field: field, desc: true}
+}
+
+// Aggregate - passes documents of the collection through the stages of a pipeline. Documents are streamed one by one,
+// only stages that need all documents (group, sort) hold them in memory. If the first stage is a StageMatch, indexes are used.
+func (col *LocalCollection) Aggregate(ctx context.Context, stages ...AggregateStage) (collection.BazaarCursor, error) {
+
+	out := &sink{data: []json.RawMessage{}}
+
+	var first processor = out
+	for i := len(stages) - 1; i >= 0; i-- {
+		p := stages[i].processor()
+		p.chain(first)
+		first = p
+	}
+
+	var keys []string
+	if len(stages) != 0 {
+		if m, ok := stages[0].(*matchStage); ok {
+			keys = col.candidates(col.rewrite(m.expr))
+		}
+	}
+
+	var err error
+	open := col.opener()
+
+	rerr := col.jsonData.Range(keys, func(key string, item json.RawMessage) bool {
+
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+
+		if open != nil {
+			if item, err = open(item); err != nil {
+				return false
+			}
+		}
+
+		doc := map[string]interface{}{}
+		if json.Unmarshal(item, &doc) != nil {
+			return true
+		}
+
+		return first.push(doc)
+	})
+
+	if err == nil {
+		err = rerr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	first.flush()
+
+	return NewCursor(out.data), out.err
+}
+
+type base struct {
+	next processor
+}
+
+func (b *base) chain(next processor) {
+	b.next = next
+}
+
+func (b *base) flush() {
+	b.next.flush()
+}
+
+type sink struct {
+	data []json.RawMessage
+	err  error
+}
+
+func (s *sink) push(doc map[string]interface{}) bool {
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		s.err = err
+		return false
+	}
+
+	s.data = append(s.data, data)
+
+	return true
+}
+
+func (s *sink) flush() {}
+
+func (s *sink) chain(next processor) {}
+
+type matchStage struct {
+	base
+	expr selector.Expr
+}
+
+// StageMatch - passes only documents that match the selector
+func StageMatch(s selector.Expr) AggregateStage {
+	return &matchStage{expr: s}
+}
+
+func (m *matchStage) processor() processor {
+	return &matchStage{expr: m.expr}
+}
+
+func (m *matchStage) push(doc map[string]interface{}) bool {
+
+	if apply(doc, m.expr) {
+		return m.next.push(doc)
+	}
+
+	return true
+}
+
+type group struct {
+	key    map[string]interface{}
+	values []interface{}
+	counts []int
+}
+
+type groupStage struct {
+	base
+	by     []string
+	acc    []Accumulator
+	groups map[string]*group
+	order  []string
+}
+
+// StageGroup - groups documents by values of given fields and computes accumulators for every group.
+// An output document holds grouping fields and results of accumulators.
+func StageGroup(by []string, acc ...Accumulator) AggregateStage {
+	return &groupStage{by: by, acc: acc}
+}
+
+func (g *groupStage) processor() processor {
+	return &groupStage{by: g.by, acc: g.acc, groups: map[string]*group{}, order: []string{}}
+}
+
+func (g *groupStage) push(doc map[string]interface{}) bool {
+
+	values := make([]interface{}, len(g.by))
+	for i, f := range g.by {
+		values[i], _ = document.Field(doc, f)
+	}
+
+	data, _ := json.Marshal(values)
+	gkey := string(data)
+
+	grp, exists := g.groups[gkey]
+	if !exists {
+		grp = &group{key: map[string]interface{}{}, values: make([]interface{}, len(g.acc)), counts: make([]int, len(g.acc))}
+		for i, f := range g.by {
+			document.SetField(grp.key, f, values[i])
+		}
+		g.groups[gkey] = grp
+		g.order = append(g.order, gkey)
+	}
+
+	for i, a := range g.acc {
+
+		if a.op == accCount {
+			grp.counts[i]++
+			continue
+		}
+
+		v, exists := document.Field(doc, a.field)
+		if !exists || v == nil {
+			continue
+		}
+
+		switch a.op {
+		case accSum, accAvg:
+			if n, ok := document.Number(v); ok {
+				sum, _ := grp.values[i].(float64)
+				grp.values[i] = sum + n
+				grp.counts[i]++
+			}
+		case accMin, accMax:
+			r, ok := document.Compare(v, grp.values[i])
+			if grp.values[i] == nil || (ok && ((a.op == accMin && r < 0) || (a.op == accMax && r > 0))) {
+				grp.values[i] = v
+			}
+		}
+	}
+
+	return true
+}
+
+func (g *groupStage) flush() {
+
+	for _, gkey := range g.order {
+
+		grp := g.groups[gkey]
+		doc := grp.key
+
+		for i, a := range g.acc {
+			switch a.op {
+			case accCount:
+				doc[a.name] = float64(grp.counts[i])
+			case accSum:
+				sum, _ := grp.values[i].(float64)
+				doc[a.name] = sum
+			case accAvg:
+				if grp.counts[i] == 0 {
+					doc[a.name] = nil
+					continue
+				}
+				doc[a.name] = grp.values[i].(float64) / float64(grp.counts[i])
+			default:
+				doc[a.name] = grp.values[i]
+			}
+		}
+
+		if !g.next.push(doc) {
+			break
+		}
+	}
+
+	g.next.flush()
+}
+
+type sortStage struct {
+	base
+	fields []SortField
+	docs   []map[string]interface{}
+}
+
+// StageSort - orders documents by given fields, missing values are lower than any other value
+func StageSort(fields ...SortField) AggregateStage {
+	return &sortStage{fields: fields}
+}
+
+func (s *sortStage) processor() processor {
+	return &sortStage{fields: s.fields, docs: []map[string]interface{}{}}
+}
+
+func (s *sortStage) push(doc map[string]interface{}) bool {
+
+	s.docs = append(s.docs, doc)
+
+	return true
+}
+
+func (s *sortStage) flush() {
+
+	sort.SliceStable(s.docs, func(i, j int) bool {
+
+		for _, f := range s.fields {
+
+			a, _ := document.Field(s.docs[i], f.field)
+			b, _ := document.Field(s.docs[j], f.field)
+
+			r, ok := document.Compare(a, b)
+			if !ok || r == 0 {
+				continue
+			}
+
+			return (r < 0) != f.desc
+		}
+
+		return false
+	})
+
+	for _, doc := range s.docs {
+		if !s.next.push(doc) {
+			break
+		}
+	}
+
+	s.next.flush()
+}
+
+type limitStage struct {
+	base
+	limit int
+	num   int
+}
+
+// StageLimit - passes at most n documents
+func StageLimit(n int) AggregateStage {
+	return &limitStage{limit: n}
+}
+
+func (l *limitStage) processor() processor {
+	return &limitStage{limit: l.limit}
+}
+
+func (l *limitStage) push(doc map[string]interface{}) bool {
+
+	if l.num >= l.limit {
+		return false
+	}
+
+	l.num++
+
+	return l.next.push(doc) && l.num < l.limit
+}
+
+type projectStage struct {
+	base
+	fields []string
+}
+
+// StageProject - keeps only given fields of documents
+func StageProject(fields ...string) AggregateStage {
+	return &projectStage{fields: fields}
+}
+
+func (p *projectStage) processor() processor {
+	return &projectStage{fields: p.fields}
+}
+
+func (p *projectStage) push(doc map[string]interface{}) bool {
+
+	result := map[string]interface{}{}
+	for _, f := range p.fields {
+		if v, exists := document.Field(doc, f); exists {
+			document.SetField(result, f, v)
+		}
+	}
+
+	return p.next.push(result)
+}
+
+type distinctStage struct {
+	base
+	field string
+	seen  map[string]struct{}
+}
+
+// StageDistinct - passes a document with a single field for every distinct value of the field
+func StageDistinct(field string) AggregateStage {
+	return &distinctStage{field: field}
+}
+
+func (d *distinctStage) processor() processor {
+	return &distinctStage{field: d.field, seen: map[string]struct{}{}}
+}
+
+func (d *distinctStage) push(doc map[string]interface{}) bool {
+
+	v, exists := document.Field(doc, d.field)
+	if !exists {
+		return true
+	}
+
+	data, _ := json.Marshal(v)
+	if _, seen := d.seen[string(data)]; seen {
+		return true
+	}
+	d.seen[string(data)] = struct{}{}
+
+	result := map[string]interface{}{}
+	document.SetField(result, d.field, v)
+
+	return d.next.push(result)
+}
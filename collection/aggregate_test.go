@@ -0,0 +1,88 @@
+package collection
+
+import (
+	"context"
+	"testing"
+
+	"github.com/przebro/databazaar/selector"
+)
+
+func TestAggregate(t *testing.T) {
+
+	c := newTestCollection(t, "aggregate")
+	for _, doc := range testCollection {
+		c.Create(context.Background(), doc)
+	}
+
+	type yearStats struct {
+		Year  int     `json:"year"`
+		Count int     `json:"count"`
+		Avg   float64 `json:"avg"`
+		Min   float64 `json:"min"`
+		Max   float64 `json:"max"`
+		Sum   float64 `json:"sum"`
+	}
+
+	byYear := StageGroup([]string{"year"}, AccCount("count"), AccAvg("avg", "score"), AccMin("min", "score"), AccMax("max", "score"), AccSum("sum", "score"))
+
+	crsr, err := c.Aggregate(context.Background(), byYear, StageSort(SortDesc("count"), SortAsc("year")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := []yearStats{}
+	crsr.All(context.Background(), &stats)
+
+	if len(stats) != 7 {
+		t.Fatal("unexpected result:", len(stats))
+	}
+
+	if stats[0].Year != 1980 || stats[0].Count != 2 || stats[0].Min != 8.4 || stats[0].Max != 8.7 {
+		t.Error("unexpected result:", stats[0])
+	}
+
+	if stats[1].Year != 1986 || stats[1].Count != 2 || stats[1].Avg < 7.89 || stats[1].Avg > 7.91 || stats[1].Sum < 15.79 {
+		t.Error("unexpected result:", stats[1])
+	}
+
+	if stats[2].Year != 1972 || stats[2].Count != 1 {
+		t.Error("unexpected result:", stats[2])
+	}
+
+	crsr, _ = c.Aggregate(context.Background(), StageMatch(selector.Eq("oscars", selector.Bool(true))), byYear, StageSort(SortAsc("year")), StageLimit(2), StageProject("year", "count"))
+
+	result := []map[string]interface{}{}
+	crsr.All(context.Background(), &result)
+
+	if len(result) != 2 || result[0]["year"] != float64(1972) || result[1]["year"] != float64(1980) || len(result[0]) != 2 {
+		t.Error("unexpected result:", result)
+	}
+
+	crsr, _ = c.Aggregate(context.Background(), StageDistinct("genre"), StageSort(SortAsc("genre")))
+
+	genres := []struct {
+		Genre string `json:"genre"`
+	}{}
+	crsr.All(context.Background(), &genres)
+
+	if len(genres) != 4 || genres[0].Genre != "comedy" || genres[3].Genre != "thriller" {
+		t.Error("unexpected result:", genres)
+	}
+
+	c.CreateIndex(context.Background(), "genre")
+	crsr, _ = c.Aggregate(context.Background(), StageMatch(selector.Eq("genre", selector.String("scifi"))), StageGroup([]string{"genre", "oscars"}, AccCount("count")), StageSort(SortAsc("oscars")))
+
+	result = []map[string]interface{}{}
+	crsr.All(context.Background(), &result)
+
+	if len(result) != 2 || result[0]["oscars"] != false || result[0]["count"] != float64(1) {
+		t.Error("unexpected result:", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.Aggregate(ctx, byYear); err == nil {
+		t.Error("unexpected result")
+	}
+}
@@ -0,0 +1,100 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/przebro/databazaar/collection"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// SetCap - limits the collection by a number of documents and/or total size of documents in bytes, zero means no limit.
+// When the limit is exceeded, the oldest inserted documents are removed.
+func (col *LocalCollection) SetCap(ctx context.Context, maxDocs int, maxBytes int64) error {
+	return col.jsonData.SetCap(maxDocs, maxBytes)
+}
+
+type tailCursor struct {
+	data    *local.JsonFileData
+	seq     uint64
+	pending []local.Entry
+	current json.RawMessage
+	err     error
+	open    func(item json.RawMessage) (json.RawMessage, error)
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Tail - returns a tailable cursor that iterates over documents in the order of insertion. When all documents are read,
+// Next waits for new documents until the context is done or the cursor is closed.
+func (col *LocalCollection) Tail(ctx context.Context) (collection.BazaarCursor, error) {
+	return &tailCursor{data: col.jsonData, pending: []local.Entry{}, open: col.opener(), done: make(chan struct{})}, nil
+}
+
+// All - appends all documents that are available without waiting to v
+func (c *tailCursor) All(ctx context.Context, v interface{}) error {
+
+	entries, _ := c.data.After(c.seq)
+	entries = append(c.pending, entries...)
+	c.pending = []local.Entry{}
+
+	data := make([]json.RawMessage, len(entries))
+	for i, e := range entries {
+		if e.Err != nil {
+			return e.Err
+		}
+		data[i] = e.Item
+		c.seq = e.Seq
+	}
+
+	return (&cursor{data: data, pos: -1, open: c.open}).All(ctx, v)
+}
+
+// Next - moves to the next document, waits for a new document if there are no more documents
+func (c *tailCursor) Next(ctx context.Context) bool {
+
+	for {
+		if len(c.pending) != 0 {
+			c.current, c.err = c.pending[0].Item, c.pending[0].Err
+			c.seq = c.pending[0].Seq
+			c.pending = c.pending[1:]
+
+			return true
+		}
+
+		entries, wait := c.data.After(c.seq)
+		if len(entries) != 0 {
+			c.pending = entries
+			continue
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return false
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+// Decode - decodes current document
+func (c *tailCursor) Decode(v interface{}) error {
+
+	if c.err != nil {
+		return c.err
+	}
+
+	crsr := &cursor{data: []json.RawMessage{c.current}, pos: 0, open: c.open}
+
+	return crsr.Decode(v)
+}
+
+// Close - closes the cursor, a pending Next returns false
+func (c *tailCursor) Close() error {
+
+	c.once.Do(func() { close(c.done) })
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+func TestCappedByCount(t *testing.T) {
+
+	c := newTestCollection(t, "cappedcount")
+	for i := 0; i < 5; i++ {
+		c.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("event_%02d", i)})
+	}
+
+	c.SetCap(context.Background(), 3, 0)
+
+	if n, _ := c.Count(context.Background()); n != 3 {
+		t.Error("unexpected result:", n)
+	}
+
+	c.Update(context.Background(), tst.TestDocument{ID: "event_02", Title: "updated"})
+	c.Create(context.Background(), tst.TestDocument{ID: "event_05"})
+
+	doc := tst.TestDocument{}
+	for id, exists := range map[string]bool{"event_01": false, "event_02": false, "event_03": true, "event_05": true} {
+		if err := c.Get(context.Background(), id, &doc); (err == nil) != exists {
+			t.Error("unexpected result:", id, err)
+		}
+	}
+
+	if err := c.SetCap(context.Background(), -1, 0); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestCappedBySize(t *testing.T) {
+
+	c := newTestCollection(t, "cappedsize")
+	c.SetCap(context.Background(), 0, 200)
+
+	for i := 0; i < 10; i++ {
+		c.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("event_%02d", i)})
+	}
+
+	n, _ := c.Count(context.Background())
+	if n == 0 || n == 10 {
+		t.Error("unexpected result:", n)
+	}
+
+	doc := tst.TestDocument{}
+	if err := c.Get(context.Background(), "event_09", &doc); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestCappedBySizeUpdate(t *testing.T) {
+
+	c := newTestCollection(t, "cappedsizeupdate")
+	for i := 0; i < 3; i++ {
+		c.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("event_%02d", i)})
+	}
+
+	c.SetCap(context.Background(), 0, 300)
+
+	if err := c.Update(context.Background(), tst.TestDocument{ID: "event_00", Title: strings.Repeat("x", 150)}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	doc := tst.TestDocument{}
+	for id, exists := range map[string]bool{"event_00": true, "event_01": false, "event_02": true} {
+		if err := c.Get(context.Background(), id, &doc); (err == nil) != exists {
+			t.Error("unexpected result:", id, err)
+		}
+	}
+
+	c.UpdateOne(context.Background(), "event_02", Update{"$set": {"title": strings.Repeat("y", 150)}}, nil)
+
+	if n, _ := c.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	if err := c.Get(context.Background(), "event_02", &doc); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestInsertionOrder(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	data, _ := manager.NewData("ordered", 0, false)
+	for _, k := range []string{"c", "a", "b"} {
+		data.Insert(k, []byte(`{"_id":"`+k+`"}`))
+	}
+	data.Sync()
+	manager.Close()
+
+	content, _ := os.ReadFile(dir + "/ordered.json")
+	if string(content) != `{"_localstore":{"version":2},"items":{"c":{"_id":"c"},"a":{"_id":"a"},"b":{"_id":"b"}}}` {
+		t.Error("unexpected result:", string(content))
+	}
+
+	data, _ = manager.GetData("ordered", 0, false)
+	entries, _ := data.After(0)
+	if len(entries) != 3 || entries[0].Key != "c" || entries[2].Key != "b" {
+		t.Error("unexpected result:", entries)
+	}
+}
+
+func TestTail(t *testing.T) {
+
+	c := newTestCollection(t, "tailcursor")
+	c.Create(context.Background(), tst.TestDocument{ID: "event_01"})
+
+	crsr, _ := c.Tail(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	doc := tst.TestDocument{}
+	if !crsr.Next(ctx) {
+		t.Fatal("unexpected result")
+	}
+	crsr.Decode(&doc)
+	if doc.ID != "event_01" {
+		t.Error("unexpected result:", doc.ID)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Create(context.Background(), tst.TestDocument{ID: "event_02"})
+	}()
+
+	if !crsr.Next(ctx) {
+		t.Fatal("unexpected result")
+	}
+	crsr.Decode(&doc)
+	if doc.ID != "event_02" {
+		t.Error("unexpected result:", doc.ID)
+	}
+
+	c.Create(context.Background(), tst.TestDocument{ID: "event_03"})
+	docs := []tst.TestDocument{}
+	crsr.All(context.Background(), &docs)
+	if len(docs) != 1 || docs[0].ID != "event_03" {
+		t.Error("unexpected result:", docs)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		crsr.Close()
+	}()
+
+	if crsr.Next(ctx) {
+		t.Error("unexpected result")
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+
+	crsr, _ = c.Tail(context.Background())
+	crsr.All(context.Background(), &docs)
+	if crsr.Next(short) {
+		t.Error("unexpected result")
+	}
+}
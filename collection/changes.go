@@ -0,0 +1,90 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// Kinds of changes
+const (
+	ChangeCreate = local.ChangeCreate
+	ChangeUpdate = local.ChangeUpdate
+	ChangeDelete = local.ChangeDelete
+)
+
+// Change - a change of a document. The sequence number of the latest change of a document serves as its revision
+type Change struct {
+	Seq  uint64
+	Op   string
+	ID   string
+	Time time.Time
+	// Document - the content of the document, set only if the change is still the latest change of the document
+	Document json.RawMessage
+	// Err - set if encrypted fields of the document can't be decrypted, Document is nil then
+	Err error
+}
+
+// LastChange - returns the sequence number of the latest change in the collection, it can be passed to Changes
+// to receive only changes made from now on
+func (col *LocalCollection) LastChange(ctx context.Context) uint64 {
+	return col.jsonData.LastChange()
+}
+
+// Changes - returns changes made after a change with a given sequence number, since equal zero means all changes.
+// Changes are kept in a log next to the collection file, so a consumer can resume from the last seen sequence number
+// after a restart. The channel is closed when the context is done, the collection is closed or requested
+// changes are no longer available.
+func (col *LocalCollection) Changes(ctx context.Context, since uint64) (<-chan Change, error) {
+
+	changes, wait, err := col.jsonData.ChangesAfter(since)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Change)
+	open := col.opener()
+
+	go func() {
+
+		defer close(ch)
+
+		for {
+			for _, c := range changes {
+
+				change := Change{Seq: c.Seq, Op: c.Op, ID: c.Key, Time: c.Time, Document: c.Item}
+				if open != nil && change.Document != nil {
+					if change.Document, change.Err = open(change.Document); change.Err != nil {
+						change.Document = nil
+					}
+				}
+
+				select {
+				case ch <- change:
+				case <-ctx.Done():
+					return
+				case <-col.jsonData.Done():
+					return
+				}
+
+				since = c.Seq
+			}
+
+			select {
+			case <-wait:
+			case <-ctx.Done():
+				return
+			case <-col.jsonData.Done():
+				return
+			}
+
+			if changes, wait, err = col.jsonData.ChangesAfter(since); err != nil {
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
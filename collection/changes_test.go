@@ -0,0 +1,192 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+func receive(t *testing.T, ch <-chan Change, num int) []Change {
+
+	t.Helper()
+
+	result := []Change{}
+	for len(result) < num {
+		select {
+		case c, ok := <-ch:
+			if !ok {
+				t.Fatal("unexpected result: channel closed")
+			}
+			result = append(result, c)
+		case <-time.After(2 * time.Second):
+			t.Fatal("unexpected result: timeout")
+		}
+	}
+
+	return result
+}
+
+func TestChanges(t *testing.T) {
+
+	c := newTestCollection(t, "changefeed")
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_01", Title: "first"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := c.Changes(ctx, 0)
+	if err != nil {
+		t.Fatal("unexpected result:", err)
+	}
+
+	changes := receive(t, ch, 1)
+	if changes[0].Seq != 1 || changes[0].Op != ChangeCreate || changes[0].ID != "movie_01" || changes[0].Document == nil {
+		t.Error("unexpected result:", changes[0])
+	}
+
+	c.Update(context.Background(), tst.TestDocument{ID: "movie_01", Title: "second"})
+	c.Delete(context.Background(), "movie_01")
+
+	changes = receive(t, ch, 2)
+	if changes[0].Seq != 2 || changes[0].Op != ChangeUpdate || changes[0].Document != nil {
+		t.Error("unexpected result:", changes[0])
+	}
+
+	if changes[1].Seq != 3 || changes[1].Op != ChangeDelete || changes[1].Document != nil {
+		t.Error("unexpected result:", changes[1])
+	}
+
+	if n := c.LastChange(context.Background()); n != 3 {
+		t.Error("unexpected result:", n)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestChangesResume(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	data, _ := manager.NewData("resume", 0, false)
+	c := &LocalCollection{jsonData: data}
+
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_01"})
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_02"})
+	c.Update(context.Background(), tst.TestDocument{ID: "movie_01", Title: "updated"})
+	manager.Close()
+
+	data, _ = manager.GetData("resume", 0, false)
+	c = &LocalCollection{jsonData: data}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := c.Changes(ctx, 1)
+	if err != nil {
+		t.Fatal("unexpected result:", err)
+	}
+
+	changes := receive(t, ch, 2)
+	if changes[0].ID != "movie_02" || changes[1].ID != "movie_01" || changes[1].Seq != 3 {
+		t.Error("unexpected result:", changes)
+	}
+
+	if changes[0].Document == nil || changes[1].Document == nil {
+		t.Error("unexpected result:", changes)
+	}
+
+	c.Delete(context.Background(), "movie_02")
+	changes = receive(t, ch, 1)
+	if changes[0].Seq != 4 || changes[0].Op != ChangeDelete {
+		t.Error("unexpected result:", changes[0])
+	}
+
+	manager.Close()
+
+	if _, ok := <-ch; ok {
+		t.Error("unexpected result")
+	}
+}
+
+func TestChangesPendingLog(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	defer manager.Close()
+
+	data, _ := manager.NewData("pending", 0, false)
+	c := &LocalCollection{jsonData: data}
+
+	for i := 0; i < 1100; i++ {
+		c.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("movie_%04d", i)})
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "pending.changes")); err == nil && info.Size() != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("unexpected result: changes were not written to the log")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "pending.json")); !os.IsNotExist(err) {
+		t.Error("unexpected result:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := c.Changes(ctx, 0)
+	if err != nil {
+		t.Fatal("unexpected result:", err)
+	}
+
+	if changes := receive(t, ch, 1100); changes[1099].Seq != 1100 {
+		t.Error("unexpected result:", changes[1099])
+	}
+}
+
+func TestChangesAfterSync(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	defer manager.Close()
+
+	data, _ := manager.NewData("changesync", 0, false)
+	c := &LocalCollection{jsonData: data}
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_01"})
+	c.jsonData.Sync()
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_02"})
+
+	done := make(chan []local.Change)
+	go func() {
+		changes, _, _ := c.jsonData.ChangesAfter(0)
+		done <- changes
+	}()
+
+	select {
+	case changes := <-done:
+		if len(changes) != 2 || changes[0].Key != "movie_01" || changes[1].Key != "movie_02" {
+			t.Error("unexpected result:", changes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("unexpected result: timeout")
+	}
+}
@@ -0,0 +1,131 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/przebro/databazaar/selector"
+)
+
+// CreateIndex - creates an index on a field, indexes are used by queries that test fields for equality
+func (col *LocalCollection) CreateIndex(ctx context.Context, field string) error {
+	return col.jsonData.CreateIndex(field)
+}
+
+// DropIndex - removes an index from a field
+func (col *LocalCollection) DropIndex(ctx context.Context, field string) error {
+	return col.jsonData.DropIndex(field)
+}
+
+// CountWhere - returns a number of documents that match the selector. If the selector is an equality test
+// of an indexed field, the result is taken directly from the index.
+func (col *LocalCollection) CountWhere(ctx context.Context, s selector.Expr) (int64, error) {
+
+	s = col.rewrite(s)
+	keys := col.candidates(s)
+
+	if sel, ok := s.(*selector.CmpExpr); ok && keys != nil && sel.Op == selector.EqOperator {
+		return int64(len(keys)), nil
+	}
+
+	var num int64
+	match := matcher(s)
+
+	err := col.jsonData.Range(keys, func(key string, item json.RawMessage) bool {
+		if match(item) {
+			num++
+		}
+		return true
+	})
+
+	return num, err
+}
+
+// Exists - checks if at least one document matches the selector, stops at the first matching document
+func (col *LocalCollection) Exists(ctx context.Context, s selector.Expr) (bool, error) {
+
+	s = col.rewrite(s)
+	found := false
+	match := matcher(s)
+
+	err := col.jsonData.Range(col.candidates(s), func(key string, item json.RawMessage) bool {
+		found = match(item)
+		return !found
+	})
+
+	return found, err
+}
+
+// candidates - uses indexes to narrow keys of documents that may match the selector,
+// returns nil if the selector can't be resolved with indexes
+func (col *LocalCollection) candidates(s selector.Expr) []string {
+
+	if sel, ok := s.(*selector.CmpExpr); ok {
+
+		if sel.Op != selector.EqOperator {
+			return nil
+		}
+
+		v, ok := literal(sel.Ex)
+		if !ok {
+			return nil
+		}
+
+		keys, _ := col.jsonData.Lookup(sel.Field, v)
+
+		return keys
+	}
+
+	if sel, ok := s.(*selector.LogExpr); ok {
+
+		var result []string
+
+		if sel.Op == selector.AndOperator {
+			for _, ex := range sel.Ex {
+				if keys := col.candidates(ex); keys != nil && (result == nil || len(keys) < len(result)) {
+					result = keys
+				}
+			}
+		}
+
+		if sel.Op == selector.OrOperator {
+			unique := map[string]struct{}{}
+			for _, ex := range sel.Ex {
+				keys := col.candidates(ex)
+				if keys == nil {
+					return nil
+				}
+				for _, k := range keys {
+					unique[k] = struct{}{}
+				}
+			}
+
+			result = make([]string, 0, len(unique))
+			for k := range unique {
+				result = append(result, k)
+			}
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+// literal - returns a go value of a selector value expression, an Int is returned as int and a Float as float64
+// so an index is searched the same way as compare tests numbers
+func literal(expr selector.Expr) (interface{}, bool) {
+
+	switch v := expr.(type) {
+	case selector.Bool:
+		return bool(v), true
+	case selector.Int:
+		return int(v), true
+	case selector.Float:
+		return float64(v), true
+	case selector.String:
+		return string(v), true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,117 @@
+package collection
+
+import (
+	"context"
+	"testing"
+
+	"github.com/przebro/databazaar/selector"
+)
+
+func TestCountWhere(t *testing.T) {
+
+	c := newTestCollection(t, "countwhere")
+	for _, doc := range testCollection {
+		c.Create(context.Background(), doc)
+	}
+
+	type testTable struct {
+		ex       selector.Expr
+		expected int64
+		exists   bool
+	}
+
+	table := []testTable{
+		{selector.Eq("oscars", selector.Bool(true)), 4, true},
+		{selector.Eq("year", selector.Int(1986)), 2, true},
+		{selector.Eq("year", selector.Int(2001)), 0, false},
+		{selector.Gt("year", selector.Int(1980)), 6, true},
+		{selector.And(selector.Eq("genre", selector.String("drama")), selector.Eq("oscars", selector.Bool(true))), 3, true},
+		{selector.Or(selector.Eq("genre", selector.String("scifi")), selector.Eq("year", selector.Int(1972))), 3, true},
+		{selector.And(selector.Eq("genre", selector.String("comedy")), selector.Eq("oscars", selector.Bool(true))), 0, false},
+	}
+
+	check := func() {
+		for _, n := range table {
+			if num, _ := c.CountWhere(context.Background(), n.ex); num != n.expected {
+				t.Error("unexpected result:", n.ex, num)
+			}
+			if exists, _ := c.Exists(context.Background(), n.ex); exists != n.exists {
+				t.Error("unexpected result:", n.ex, exists)
+			}
+		}
+	}
+
+	check()
+
+	c.CreateIndex(context.Background(), "genre")
+	c.CreateIndex(context.Background(), "year")
+
+	if err := c.CreateIndex(context.Background(), "year"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	check()
+
+	c.UpdateOne(context.Background(), "document_09", Update{"$set": {"year": 1986}}, nil)
+	if num, _ := c.CountWhere(context.Background(), selector.Eq("year", selector.Int(1986))); num != 3 {
+		t.Error("unexpected result:", num)
+	}
+
+	c.Delete(context.Background(), "document_09")
+	if num, _ := c.CountWhere(context.Background(), selector.Eq("year", selector.Int(1986))); num != 2 {
+		t.Error("unexpected result:", num)
+	}
+
+	if keys := c.candidates(selector.Eq("genre", selector.String("drama"))); len(keys) != 4 {
+		t.Error("unexpected result:", keys)
+	}
+
+	if keys := c.candidates(selector.Eq("oscars", selector.Bool(true))); keys != nil {
+		t.Error("unexpected result:", keys)
+	}
+
+	c.DropIndex(context.Background(), "genre")
+	if err := c.DropIndex(context.Background(), "genre"); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestCountWhereIndexedMatch(t *testing.T) {
+
+	c := newTestCollection(t, "countmatch")
+	c.Create(context.Background(), map[string]interface{}{"_id": "doc_01", "meta": map[string]interface{}{"rating": 7}, "score": 7.5})
+	c.Create(context.Background(), map[string]interface{}{"_id": "doc_02", "meta": map[string]interface{}{"rating": 8}, "score": 7})
+	c.Create(context.Background(), map[string]interface{}{"_id": "doc_03", "meta": "none", "score": "7"})
+
+	table := []struct {
+		ex       selector.Expr
+		expected int64
+	}{
+		{selector.Eq("meta.rating", selector.Int(7)), 1},
+		{selector.Eq("meta.rating", selector.Float(8)), 1},
+		{selector.Eq("score", selector.Int(7)), 2},
+		{selector.Eq("score", selector.Float(7.5)), 1},
+		{selector.Eq("score", selector.Float(7)), 1},
+		{selector.Gt("score", selector.Int(6)), 2},
+		{selector.Gt("score", selector.Float(7)), 1},
+		{selector.Eq("score", selector.String("7")), 1},
+	}
+
+	check := func() {
+		for _, n := range table {
+			if num, _ := c.CountWhere(context.Background(), n.ex); num != n.expected {
+				t.Error("unexpected result:", n.ex, num)
+			}
+			if exists, _ := c.Exists(context.Background(), n.ex); exists != (n.expected != 0) {
+				t.Error("unexpected result:", n.ex, exists)
+			}
+		}
+	}
+
+	check()
+
+	c.CreateIndex(context.Background(), "meta.rating")
+	c.CreateIndex(context.Background(), "score")
+
+	check()
+}
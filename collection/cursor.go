@@ -12,6 +12,7 @@ import (
 type cursor struct {
 	data []json.RawMessage
 	pos  int
+	open func(item json.RawMessage) (json.RawMessage, error)
 }
 
 //NewCursor - creates a new cursor
@@ -39,11 +40,21 @@ func (c *cursor) All(ctx context.Context, v interface{}) error {
 
 	etype := sval.Type().Elem()
 
-	for x := c.pos; x < len(c.data); x++ {
+	start := c.pos
+	if start < 0 {
+		start = 0
+	}
+
+	for x := start; x < len(c.data); x++ {
+
+		item, err := c.item(x)
+		if err != nil {
+			return err
+		}
 
 		newElem := reflect.New(etype)
 		i := newElem.Interface()
-		json.Unmarshal(c.data[x], i)
+		json.Unmarshal(item, i)
 		sval.Set(reflect.Append(sval, newElem.Elem()))
 	}
 
@@ -64,8 +75,23 @@ func (c *cursor) Next(ctx context.Context) bool {
 //Decode - decodes current value
 func (c *cursor) Decode(v interface{}) error {
 
-	return json.Unmarshal(c.data[c.pos], v)
+	item, err := c.item(c.pos)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(item, v)
+
+}
+
+//item - returns a document at a given position, encrypted fields are decrypted
+func (c *cursor) item(pos int) (json.RawMessage, error) {
+
+	if c.open == nil {
+		return c.data[pos], nil
+	}
 
+	return c.open(c.data[pos])
 }
 
 //Close - closes the cursor
@@ -0,0 +1,280 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/przebro/databazaar/selector"
+	"github.com/przebro/localstore/internal/document"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// Modes of encrypted fields
+const (
+	// FieldRandom - equal values give different ciphertexts, the field can't be used in selectors
+	FieldRandom = local.FieldRandom
+	// FieldDeterministic - equal values give equal ciphertexts, the field can be used in Eq and Ne selectors
+	FieldDeterministic = local.FieldDeterministic
+	// FieldRedact - a value is replaced with a keyed hash, it is never decrypted but can be used in Eq and Ne selectors
+	FieldRedact = local.FieldRedact
+)
+
+// FieldSpec - a top-level field of documents that is encrypted or redacted before a document is stored
+type FieldSpec = local.FieldSpec
+
+// EncryptFields - declares encrypted fields of the collection, fields are encrypted with keys of the store before
+// documents reach the collection and are decrypted on Get and when a cursor decodes a document. Stored documents
+// are rewritten, fields that are no longer declared are decrypted. After keys are rotated, calling EncryptFields again
+// re-encrypts deterministic fields with the current key, so they can be matched by selectors.
+func (col *LocalCollection) EncryptFields(ctx context.Context, fields ...FieldSpec) error {
+
+	old := col.jsonData.Fields()
+
+	if err := col.jsonData.SetFields(fields); err != nil {
+		return err
+	}
+
+	keys := col.jsonData.Keys()
+
+	_, err := col.jsonData.UpdateWhere(func(item json.RawMessage) bool { return true }, func(item json.RawMessage) (json.RawMessage, error) {
+
+		data, err := openFields(keys, old, item)
+		if err != nil {
+			return nil, err
+		}
+
+		return sealFields(keys, fields, data)
+	})
+
+	return err
+}
+
+// EncryptedFields - returns encrypted fields of the collection
+func (col *LocalCollection) EncryptedFields(ctx context.Context) []FieldSpec {
+	return col.jsonData.Fields()
+}
+
+// seal - encrypts declared fields of a document before it is stored, the document is checked against the schema
+// of the collection before its fields are encrypted
+func (col *LocalCollection) seal(data []byte) ([]byte, error) {
+
+	fields := col.jsonData.Fields()
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	if err := col.jsonData.SchemaCheck()(data); err != nil {
+		return nil, err
+	}
+
+	return sealFields(col.jsonData.Keys(), fields, data)
+}
+
+// open - decrypts declared fields of a stored document
+func (col *LocalCollection) open(item json.RawMessage) (json.RawMessage, error) {
+
+	fields := col.jsonData.Fields()
+	if len(fields) == 0 {
+		return item, nil
+	}
+
+	return openFields(col.jsonData.Keys(), fields, item)
+}
+
+// opener - returns a function that decrypts stored documents, nil if the collection has no encrypted fields
+func (col *LocalCollection) opener() func(item json.RawMessage) (json.RawMessage, error) {
+
+	fields := col.jsonData.Fields()
+	if len(fields) == 0 {
+		return nil
+	}
+
+	keys := col.jsonData.Keys()
+
+	return func(item json.RawMessage) (json.RawMessage, error) {
+		return openFields(keys, fields, item)
+	}
+}
+
+// modifier - wraps a function that modifies a stored document, so it modifies a decrypted document
+func (col *LocalCollection) modifier(fn func(item json.RawMessage) (json.RawMessage, error)) func(item json.RawMessage) (json.RawMessage, error) {
+
+	fields := col.jsonData.Fields()
+	if len(fields) == 0 {
+		return fn
+	}
+
+	keys := col.jsonData.Keys()
+	check := col.jsonData.SchemaCheck()
+
+	return func(item json.RawMessage) (json.RawMessage, error) {
+
+		data, err := openFields(keys, fields, item)
+		if err != nil {
+			return nil, err
+		}
+
+		if data, err = fn(data); err != nil {
+			return nil, err
+		}
+
+		if err = check(data); err != nil {
+			return nil, err
+		}
+
+		return sealFields(keys, fields, data)
+	}
+}
+
+// cursor - returns a cursor over stored documents, documents are decrypted when they are decoded
+func (col *LocalCollection) cursor(data []json.RawMessage) *cursor {
+	return &cursor{data: data, pos: -1, open: col.opener()}
+}
+
+// rewrite - replaces values compared with deterministic and redacted fields with sealed values,
+// so a selector can be matched against stored documents
+func (col *LocalCollection) rewrite(s selector.Expr) selector.Expr {
+
+	fields := col.jsonData.Fields()
+	if len(fields) == 0 {
+		return s
+	}
+
+	specs := map[string]FieldSpec{}
+	for _, f := range fields {
+		specs[f.Name] = f
+	}
+
+	return rewriteExpr(col.jsonData.Keys(), specs, s)
+}
+
+func rewriteExpr(keys local.KeyProvider, specs map[string]FieldSpec, s selector.Expr) selector.Expr {
+
+	switch sel := s.(type) {
+	case *selector.CmpExpr:
+		{
+			spec, exists := specs[sel.Field]
+			if !exists || spec.Mode == FieldRandom || !isValueExpr(sel.Ex) {
+				return s
+			}
+
+			if sel.Op != selector.EqOperator && sel.Op != selector.NeOperator {
+				return s
+			}
+
+			value, err := canonical(sel.Ex)
+			if err != nil {
+				return s
+			}
+
+			sealed, err := local.SealValue(keys, spec, value)
+			if err != nil {
+				return s
+			}
+
+			return &selector.CmpExpr{Field: sel.Field, Op: sel.Op, Ex: selector.String(sealed)}
+		}
+	case *selector.LogExpr:
+		{
+			ex := make([]selector.Expr, len(sel.Ex))
+			for i, e := range sel.Ex {
+				ex[i] = rewriteExpr(keys, specs, e)
+			}
+
+			return &selector.LogExpr{Op: sel.Op, Ex: ex}
+		}
+	}
+
+	return s
+}
+
+// sealFields - encrypts given fields of a document, values that are already sealed are left unchanged
+func sealFields(keys local.KeyProvider, fields []FieldSpec, data []byte) ([]byte, error) {
+
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+
+		raw, exists := doc[f.Name]
+		if !exists || string(raw) == "null" {
+			continue
+		}
+
+		var str string
+		if json.Unmarshal(raw, &str) == nil && local.Sealed(f, str) {
+			continue
+		}
+
+		value, err := document.Decode(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		cval, err := canonical(value)
+		if err != nil {
+			return nil, err
+		}
+
+		sealed, err := local.SealValue(keys, f, cval)
+		if err != nil {
+			return nil, err
+		}
+
+		if doc[f.Name], err = json.Marshal(sealed); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+// openFields - decrypts given fields of a document, values that are not sealed are left unchanged
+func openFields(keys local.KeyProvider, fields []FieldSpec, item json.RawMessage) (json.RawMessage, error) {
+
+	if len(fields) == 0 {
+		return item, nil
+	}
+
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(item, &doc); err != nil {
+		return nil, err
+	}
+
+	changed := false
+
+	for _, f := range fields {
+
+		var str string
+		if raw, exists := doc[f.Name]; !exists || json.Unmarshal(raw, &str) != nil {
+			continue
+		}
+
+		value, sealed, err := local.OpenValue(keys, f, str)
+		if err != nil {
+			return nil, err
+		}
+
+		if sealed {
+			doc[f.Name] = value
+			changed = true
+		}
+	}
+
+	if !changed {
+		return item, nil
+	}
+
+	return json.Marshal(doc)
+}
+
+// canonical - marshals a value, so equal values give equal bytes regardless of how they were written
+func canonical(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
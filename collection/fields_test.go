@@ -0,0 +1,245 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/przebro/databazaar/selector"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+type customer struct {
+	ID    string `json:"_id"`
+	Name  string `json:"name"`
+	SSN   string `json:"ssn,omitempty"`
+	Token string `json:"token,omitempty"`
+	Card  string `json:"card,omitempty"`
+	Age   int    `json:"age"`
+}
+
+func newEncryptedCollection(t *testing.T, dir string) (*LocalCollection, local.FileManager) {
+
+	t.Helper()
+
+	kp, _ := local.NewStaticKeys([]byte("0123456789abcdef0123456789abcdef"))
+
+	manager := local.GetFileManager(dir)
+	manager.SetKeys(kp)
+
+	data, err := manager.NewData("customers", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &LocalCollection{jsonData: data}, manager
+}
+
+func TestEncryptFields(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	c, manager := newEncryptedCollection(t, dir)
+	defer manager.Close()
+
+	c.Create(context.Background(), customer{ID: "c1", Name: "first", SSN: "123-45-6789", Token: "secret", Age: 30})
+
+	err := c.EncryptFields(context.Background(),
+		FieldSpec{Name: "ssn", Mode: FieldDeterministic},
+		FieldSpec{Name: "token", Mode: FieldRandom},
+		FieldSpec{Name: "card", Mode: FieldRedact},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Create(context.Background(), customer{ID: "c2", Name: "second", SSN: "987-65-4321", Token: "secret", Card: "4111", Age: 40})
+	c.Create(context.Background(), customer{ID: "c3", Name: "third", SSN: "123-45-6789", Card: "4111", Age: 50})
+
+	for _, id := range []string{"c1", "c2"} {
+
+		raw, _, _ := c.jsonData.Get(id)
+		if strings.Contains(string(raw), "secret") || strings.Contains(string(raw), "-45-") || strings.Contains(string(raw), "-65-") {
+			t.Error("unexpected result:", string(raw))
+		}
+	}
+
+	doc := customer{}
+	if err = c.Get(context.Background(), "c1", &doc); err != nil || doc.SSN != "123-45-6789" || doc.Token != "secret" {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	if err = c.Get(context.Background(), "c2", &doc); err != nil || !strings.HasPrefix(doc.Card, "$red$") {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	crsr, _ := c.Select(context.Background(), selector.Eq("ssn", selector.String("123-45-6789")), nil)
+	result := []customer{}
+	crsr.All(context.Background(), &result)
+
+	if len(result) != 2 || result[0].SSN != "123-45-6789" || result[1].SSN != "123-45-6789" {
+		t.Error("unexpected result:", result)
+	}
+
+	if n, _ := c.CountWhere(context.Background(), selector.Eq("card", selector.String("4111"))); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	if n, _ := c.CountWhere(context.Background(), selector.Eq("token", selector.String("secret"))); n != 0 {
+		t.Error("unexpected result:", n)
+	}
+
+	crsr, _ = c.All(context.Background())
+	for crsr.Next(context.Background()) {
+		doc := customer{}
+		if err = crsr.Decode(&doc); err != nil || doc.SSN == "" || strings.HasPrefix(doc.SSN, "$") {
+			t.Error("unexpected result:", doc, err)
+		}
+	}
+
+	doc = customer{}
+	if err = c.UpdateOne(context.Background(), "c1", Update{"$set": {"token": "changed"}, "$inc": {"age": 1}}, &doc); err != nil || doc.Token != "changed" || doc.Age != 31 {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	if raw, _, _ := c.jsonData.Get("c1"); strings.Contains(string(raw), "changed") {
+		t.Error("unexpected result:", string(raw))
+	}
+
+	if err = c.Update(context.Background(), doc); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	doc = customer{}
+	if err = c.Get(context.Background(), "c2", &doc); err == nil {
+		c.Update(context.Background(), doc)
+	}
+
+	if n, _ := c.CountWhere(context.Background(), selector.Eq("card", selector.String("4111"))); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	if err = c.EncryptFields(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _, _ := c.jsonData.Get("c1")
+	stored := map[string]interface{}{}
+	json.Unmarshal(raw, &stored)
+
+	if stored["ssn"] != "123-45-6789" || stored["token"] != "changed" {
+		t.Error("unexpected result:", stored)
+	}
+
+	if err = c.EncryptFields(context.Background(), FieldSpec{Name: "ssn", Mode: "unknown"}); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestEncryptFieldsWithoutKey(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	defer manager.Close()
+
+	data, _ := manager.NewData("plain", 0, false)
+	c := &LocalCollection{jsonData: data}
+
+	if err := c.EncryptFields(context.Background(), FieldSpec{Name: "ssn", Mode: FieldRandom}); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestEncryptedFieldsReload(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	c, manager := newEncryptedCollection(t, dir)
+
+	c.EncryptFields(context.Background(), FieldSpec{Name: "ssn", Mode: FieldDeterministic})
+	c.Create(context.Background(), customer{ID: "c1", SSN: "123-45-6789"})
+	c.jsonData.Sync()
+	manager.Close()
+
+	data, err := manager.GetData("customers", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	c = &LocalCollection{jsonData: data}
+
+	doc := customer{}
+	if err = c.Get(context.Background(), "c1", &doc); err != nil || doc.SSN != "123-45-6789" {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	if ok, _ := c.Exists(context.Background(), selector.Eq("ssn", selector.String("123-45-6789"))); !ok {
+		t.Error("unexpected result")
+	}
+}
+
+func TestEncryptedFieldsSchema(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	c, manager := newEncryptedCollection(t, dir)
+	defer manager.Close()
+
+	c.SetSchema(context.Background(), []byte(`{"properties" : {"ssn" : {"type" : "string", "pattern" : "^[0-9]{3}-[0-9]{2}-[0-9]{4}$"}, "age" : {"type" : "integer", "minimum" : 0}}}`))
+	c.EncryptFields(context.Background(), FieldSpec{Name: "ssn", Mode: FieldDeterministic}, FieldSpec{Name: "age", Mode: FieldRandom})
+
+	if _, err := c.Create(context.Background(), customer{ID: "c1", SSN: "123-45-6789", Age: 30}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err := c.Create(context.Background(), customer{ID: "c2", SSN: "123456789", Age: 30}); len(violations(err)) != 1 {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.UpdateOne(context.Background(), "c1", Update{"$set": {"age": -1}}, nil); len(violations(err)) != 1 {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.UpdateOne(context.Background(), "c1", Update{"$inc": {"age": 1}}, nil); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.Validate(context.Background()); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestEncryptedFieldsChanges(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	c, manager := newEncryptedCollection(t, dir)
+	defer manager.Close()
+
+	c.EncryptFields(context.Background(), FieldSpec{Name: "ssn", Mode: FieldRandom})
+	c.Create(context.Background(), customer{ID: "c1", SSN: "123-45-6789"})
+
+	kp, _ := local.NewStaticKeys([]byte("fedcba9876543210fedcba9876543210"))
+	manager.SetKeys(kp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := c.Changes(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changes := receive(t, ch, 1); changes[0].Err == nil || changes[0].Document != nil {
+		t.Error("unexpected result:", changes[0])
+	}
+}
@@ -0,0 +1,46 @@
+package collection
+
+import (
+	"context"
+
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// Formats of a collection file, a format is detected when a collection is loaded
+const (
+	FormatJSON    = local.FormatJSON
+	FormatJSONL   = local.FormatJSONL
+	FormatCBOR    = local.FormatCBOR
+	FormatMsgpack = local.FormatMsgpack
+)
+
+// Format - returns a format of the collection file
+func (col *LocalCollection) Format(ctx context.Context) string {
+
+	return col.jsonData.Format()
+}
+
+// SetFormat - converts the collection file to a given format in place
+func (col *LocalCollection) SetFormat(ctx context.Context, format string) error {
+
+	return col.jsonData.SetFormat(format)
+}
+
+// Compressions of a collection file, a compression is detected when a collection is loaded
+const (
+	CompressionNone = local.CompressionNone
+	CompressionGzip = local.CompressionGzip
+	CompressionZstd = local.CompressionZstd
+)
+
+// Compression - returns a compression of the collection file
+func (col *LocalCollection) Compression(ctx context.Context) string {
+
+	return col.jsonData.Compression()
+}
+
+// SetCompression - compresses the collection file with a given compression or stores it uncompressed
+func (col *LocalCollection) SetCompression(ctx context.Context, compression string) error {
+
+	return col.jsonData.SetCompression(compression)
+}
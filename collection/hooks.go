@@ -0,0 +1,182 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/przebro/localstore/internal/document"
+)
+
+// Kinds of operations passed to hooks
+const (
+	OperationCreate     = "create"
+	OperationUpdate     = "update"
+	OperationDelete     = "delete"
+	OperationCreateMany = "createMany"
+	OperationBulkUpdate = "bulkUpdate"
+	OperationUpdateMany = "updateMany"
+	OperationDeleteMany = "deleteMany"
+)
+
+const hooksName = "hooks"
+
+var (
+	errHookIDChanged = errors.New("hook must not change the id of a document")
+)
+
+// Operation - a write of a single document passed to hooks, operations on many documents
+// pass every document separately
+type Operation struct {
+	Op string
+	ID string
+	// Document - the written document, for a delete it holds the removed document if it exists
+	Document map[string]interface{}
+}
+
+// BeforeHook - called before a document is written. A hook can modify the document
+// or reject the operation by returning an error
+type BeforeHook func(ctx context.Context, op *Operation) error
+
+// AfterHook - called after a written document is durable, that is, when the collection file is synced
+type AfterHook func(op Operation)
+
+type pendingOperation struct {
+	seq uint64
+	op  Operation
+}
+
+// hooks - hooks of a collection, shared by all LocalCollection values of the same collection
+type hooks struct {
+	lock    sync.RWMutex
+	before  []BeforeHook
+	after   []AfterHook
+	pending []pendingOperation
+	once    sync.Once
+}
+
+// Before - registers a hook called before every write of a document: Create, CreateWithTTL, Update, Delete, CreateMany,
+// BulkUpdate, UpdateOne, UpdateMany, DeleteMany, Patch and writes of a transaction. Hooks are called in the order
+// of registration. UpdateOne, UpdateMany, DeleteMany and Patch call hooks under the collection lock,
+// so a hook must not access the collection
+func (col *LocalCollection) Before(hook BeforeHook) {
+
+	h := col.hooks()
+
+	defer h.lock.Unlock()
+	h.lock.Lock()
+
+	h.before = append(h.before, hook)
+}
+
+// After - registers a hook called after a document written by any of operations calling before hooks is durable,
+// documents written by a transaction are reported after it is committed. With updatesync the hook is called
+// before the operation returns, otherwise after the next sync.
+func (col *LocalCollection) After(hook AfterHook) {
+
+	h := col.hooks()
+
+	defer h.lock.Unlock()
+	h.lock.Lock()
+
+	h.after = append(h.after, hook)
+}
+
+func (col *LocalCollection) hooks() *hooks {
+
+	h := col.jsonData.Attach(hooksName, func() interface{} { return &hooks{} }).(*hooks)
+	h.once.Do(func() { col.jsonData.OnSync(h.synced) })
+
+	return h
+}
+
+// before - calls before hooks and returns the document modified by them
+func (col *LocalCollection) before(ctx context.Context, op *Operation, data []byte) ([]byte, error) {
+	return col.hooks().call(ctx, op, data)
+}
+
+// call - calls before hooks, it doesn't take the collection lock so it can be called by a function that modifies a document
+func (h *hooks) call(ctx context.Context, op *Operation, data []byte) ([]byte, error) {
+
+	h.lock.RLock()
+	before := h.before
+	after := len(h.after) != 0
+	h.lock.RUnlock()
+
+	if len(before) == 0 && !after {
+		return data, nil
+	}
+
+	if data != nil {
+		doc, err := document.DecodeObject(data)
+		if err != nil {
+			return nil, err
+		}
+		op.Document = doc
+	}
+
+	for _, fn := range before {
+		if err := fn(ctx, op); err != nil {
+			return nil, err
+		}
+	}
+
+	if data == nil || len(before) == 0 {
+		return data, nil
+	}
+
+	data, err := json.Marshal(op.Document)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, err := documentID(data); err != nil || id != op.ID {
+		return nil, errHookIDChanged
+	}
+
+	return data, nil
+}
+
+// after - schedules after hooks for written documents, they are called when the latest change is synced
+func (col *LocalCollection) after(ops ...Operation) {
+
+	h := col.hooks()
+
+	h.lock.Lock()
+	if len(h.after) == 0 {
+		h.lock.Unlock()
+		return
+	}
+
+	seq := col.jsonData.LastChange()
+	for _, op := range ops {
+		h.pending = append(h.pending, pendingOperation{seq: seq, op: op})
+	}
+	h.lock.Unlock()
+
+	h.synced(col.jsonData.Synced())
+}
+
+// synced - calls after hooks for operations that are synced
+func (h *hooks) synced(seq uint64) {
+
+	h.lock.Lock()
+
+	n := 0
+	for n < len(h.pending) && h.pending[n].seq <= seq {
+		n++
+	}
+
+	ready := h.pending[:n]
+	h.pending = h.pending[n:]
+	after := h.after
+
+	h.lock.Unlock()
+
+	for _, p := range ready {
+		for _, fn := range after {
+			fn(p.op)
+		}
+	}
+}
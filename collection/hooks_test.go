@@ -0,0 +1,251 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/selector"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+func TestBeforeHooks(t *testing.T) {
+
+	c := newTestCollection(t, "beforehooks")
+	errInvalid := errors.New("invalid title")
+
+	c.Before(func(ctx context.Context, op *Operation) error {
+
+		if op.Op == OperationDelete {
+			return nil
+		}
+
+		if op.Document["title"] == "invalid" {
+			return errInvalid
+		}
+
+		op.Document["updated_at"] = "now"
+
+		return nil
+	})
+
+	if _, err := c.Create(context.Background(), tst.TestDocument{ID: "movie_01", Title: "invalid"}); err != errInvalid {
+		t.Error("unexpected result:", err)
+	}
+
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_01", Title: "valid"})
+
+	doc := map[string]interface{}{}
+	if c.Get(context.Background(), "movie_01", &doc); doc["updated_at"] != "now" {
+		t.Error("unexpected result:", doc)
+	}
+
+	_, err := c.CreateMany(context.Background(), []interface{}{
+		tst.TestDocument{ID: "movie_02", Title: "valid"},
+		tst.TestDocument{ID: "movie_03", Title: "invalid"},
+	})
+
+	berr, ok := err.(*BulkError)
+	if !ok || len(berr.Errors) != 1 || berr.Errors[0].Index != 1 {
+		t.Error("unexpected result:", err)
+	}
+
+	if n, _ := c.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	if err = c.BulkUpdate(context.Background(), []interface{}{tst.TestDocument{ID: "movie_01", Title: "invalid"}}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	c.Before(func(ctx context.Context, op *Operation) error {
+
+		if op.Op == OperationDelete && op.Document["title"] == "valid" {
+			return errInvalid
+		}
+
+		if op.Op == OperationUpdate {
+			op.Document["_id"] = "movie_99"
+		}
+
+		return nil
+	})
+
+	if err = c.Delete(context.Background(), "movie_01"); err != errInvalid {
+		t.Error("unexpected result:", err)
+	}
+
+	if err = c.Update(context.Background(), tst.TestDocument{ID: "movie_01", Title: "other"}); err != errHookIDChanged {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestAfterHooks(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	defer manager.Close()
+
+	data, _ := manager.NewData("afterhooks", 0, false)
+	c := &LocalCollection{jsonData: data}
+
+	ops := []Operation{}
+	c.After(func(op Operation) {
+		ops = append(ops, op)
+	})
+
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_01", Title: "first"})
+	c.BulkUpdate(context.Background(), []interface{}{tst.TestDocument{ID: "movie_02"}, tst.TestDocument{ID: "movie_03"}})
+
+	if len(ops) != 0 {
+		t.Error("unexpected result:", ops)
+	}
+
+	data.Sync()
+
+	if len(ops) != 3 || ops[0].ID != "movie_01" || ops[0].Document["title"] != "first" || ops[2].Op != OperationBulkUpdate {
+		t.Error("unexpected result:", ops)
+	}
+
+	synced, _ := manager.NewData("syncedhooks", 0, true)
+	c = &LocalCollection{jsonData: synced}
+
+	deleted := []string{}
+	c.After(func(op Operation) {
+		if op.Op == OperationDelete {
+			deleted = append(deleted, op.ID)
+		}
+	})
+
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_01"})
+	c.Delete(context.Background(), "movie_01")
+
+	if len(deleted) != 1 || deleted[0] != "movie_01" {
+		t.Error("unexpected result:", deleted)
+	}
+}
+
+func TestHooksWritePaths(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	defer manager.Close()
+
+	data, _ := manager.NewData("writepaths", 0, true)
+	c := &LocalCollection{jsonData: data}
+	errRejected := errors.New("rejected")
+
+	c.Before(func(ctx context.Context, op *Operation) error {
+
+		if op.Document["title"] == "reject" {
+			return errRejected
+		}
+
+		if op.Op != OperationDelete && op.Op != OperationDeleteMany {
+			op.Document["by"] = op.Op
+		}
+
+		return nil
+	})
+
+	ops := []Operation{}
+	c.After(func(op Operation) {
+		ops = append(ops, op)
+	})
+
+	by := func(id string) interface{} {
+		doc := map[string]interface{}{}
+		c.Get(context.Background(), id, &doc)
+		return doc["by"]
+	}
+
+	last := func() Operation {
+		if len(ops) == 0 {
+			return Operation{}
+		}
+		return ops[len(ops)-1]
+	}
+
+	if _, err := c.CreateWithTTL(context.Background(), tst.TestDocument{ID: "movie_01", Title: "reject"}, time.Hour); err != errRejected {
+		t.Error("unexpected result:", err)
+	}
+
+	c.CreateWithTTL(context.Background(), tst.TestDocument{ID: "movie_01"}, time.Hour)
+	if by("movie_01") != OperationCreate || last().Op != OperationCreate {
+		t.Error("unexpected result:", by("movie_01"), last())
+	}
+
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_02"})
+
+	if err := c.UpdateOne(context.Background(), "movie_01", Update{"$set": {"title": "reject"}}, nil); !errors.Is(err, errRejected) {
+		t.Error("unexpected result:", err)
+	}
+
+	c.UpdateOne(context.Background(), "movie_01", Update{"$set": {"by": ""}}, nil)
+	if by("movie_01") != OperationUpdate || last().Op != OperationUpdate || last().ID != "movie_01" {
+		t.Error("unexpected result:", by("movie_01"), last())
+	}
+
+	if err := c.Patch(context.Background(), "movie_02", []byte(`{"title": "reject"}`)); !errors.Is(err, errRejected) {
+		t.Error("unexpected result:", err)
+	}
+
+	c.Patch(context.Background(), "movie_02", []byte(`{"by": ""}`))
+	if by("movie_02") != OperationUpdate || last().ID != "movie_02" {
+		t.Error("unexpected result:", by("movie_02"), last())
+	}
+
+	if _, err := c.UpdateMany(context.Background(), selector.Ne("_id", selector.String("")), Update{"$set": {"title": "reject"}}); !errors.Is(err, errRejected) {
+		t.Error("unexpected result:", err)
+	}
+
+	n := len(ops)
+	c.UpdateMany(context.Background(), selector.Ne("_id", selector.String("")), Update{"$set": {"title": "many"}})
+	if by("movie_01") != OperationUpdateMany || by("movie_02") != OperationUpdateMany || len(ops) != n+2 {
+		t.Error("unexpected result:", by("movie_01"), ops)
+	}
+
+	tx, _ := c.Begin(context.Background())
+	if _, err := tx.Create(context.Background(), tst.TestDocument{ID: "movie_03", Title: "reject"}); err != errRejected {
+		t.Error("unexpected result:", err)
+	}
+	tx.Create(context.Background(), tst.TestDocument{ID: "movie_03", Title: "reject_later"})
+	tx.Update(context.Background(), tst.TestDocument{ID: "movie_01", Title: "many"})
+	tx.Delete(context.Background(), "movie_03")
+
+	n = len(ops)
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if len(ops) != n+3 || ops[n].Op != OperationCreate || ops[n+2].Op != OperationDelete || by("movie_01") != OperationUpdate {
+		t.Error("unexpected result:", ops[n:], by("movie_01"))
+	}
+
+	c.Create(context.Background(), tst.TestDocument{ID: "movie_04", Title: "reject_later"})
+	data.Update("movie_04", []byte(`{"_id":"movie_04","title":"reject"}`))
+
+	if _, err := c.DeleteMany(context.Background(), selector.Ne("_id", selector.String(""))); !errors.Is(err, errRejected) {
+		t.Error("unexpected result:", err)
+	}
+
+	if num, _ := c.Count(context.Background()); num != 3 {
+		t.Error("unexpected result:", num)
+	}
+
+	n = len(ops)
+	if num, err := c.DeleteMany(context.Background(), selector.Eq("title", selector.String("many"))); err != nil || num != 2 {
+		t.Error("unexpected result:", num, err)
+	}
+
+	if len(ops) != n+2 || last().Op != OperationDeleteMany {
+		t.Error("unexpected result:", ops[n:])
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/przebro/localstore/internal/document"
 	local "github.com/przebro/localstore/internal/file"
 
 	"github.com/przebro/databazaar/collection"
@@ -18,14 +19,22 @@ type LocalCollection struct {
 	jsonData *local.JsonFileData
 }
 
-type resultCollector struct {
-	r []result.BazaarResult
+// DocumentError - describes a single document rejected by a bulk operation
+type DocumentError struct {
+	Index int
+	ID    string
+	Err   error
 }
 
-// Collect - implementation of a KeyCollector
-func (c *resultCollector) Collect(key string) {
+// BulkError - collects errors of all documents rejected by a bulk operation
+type BulkError struct {
+	Errors []DocumentError
+}
+
+func (e *BulkError) Error() string {
 
-	c.r = append(c.r, result.BazaarResult{ID: key})
+	first := e.Errors[0]
+	return fmt.Sprintf("%d document(s) rejected, first at index %d: %s", len(e.Errors), first.Index, first.Err)
 }
 
 // Collection - wraps a local collection and returns as DataCollection
@@ -36,16 +45,17 @@ func Collection(d *local.JsonFileData) collection.DataCollection {
 // Create - creates a new record in the collection
 func (col *LocalCollection) Create(ctx context.Context, document interface{}) (*result.BazaarResult, error) {
 
-	id, _, err := collection.RequiredFields(document)
+	id, doc, err := marshalDocument(document)
 	if err != nil {
 		return nil, err
 	}
-	if id == "" {
-		return nil, collection.ErrEmptyOrInvalidID
+
+	op := Operation{Op: OperationCreate, ID: id}
+	if doc, err = col.before(ctx, &op, doc); err != nil {
+		return nil, err
 	}
 
-	doc, err := json.Marshal(document)
-	if err != nil {
+	if doc, err = col.seal(doc); err != nil {
 		return nil, err
 	}
 
@@ -54,39 +64,55 @@ func (col *LocalCollection) Create(ctx context.Context, document interface{}) (*
 		return nil, err
 	}
 
+	col.after(op)
+
 	return &result.BazaarResult{ID: id}, nil
 }
 
 // Get - returns a single record with given id from the collection, if the key not exists returns an error
 func (col *LocalCollection) Get(ctx context.Context, id string, result interface{}) error {
 
-	data, exists := col.jsonData.Get(id)
+	data, exists, err := col.jsonData.Get(id)
+	if err != nil {
+		return err
+	}
 
 	if !exists {
 		return collection.ErrNoDocuments
 	}
 
+	data, err = col.open(data)
+	if err != nil {
+		return err
+	}
+
 	return json.Unmarshal(data, result)
 }
 
 // Update - updates a single record in the collection
 func (col *LocalCollection) Update(ctx context.Context, doc interface{}) error {
 
-	id, _, err := collection.RequiredFields(doc)
+	id, data, err := marshalDocument(doc)
 	if err != nil {
 		return err
 	}
-	if id == "" {
-		return collection.ErrEmptyOrInvalidID
+
+	op := Operation{Op: OperationUpdate, ID: id}
+	if data, err = col.before(ctx, &op, data); err != nil {
+		return err
 	}
 
-	data, err := json.Marshal(doc)
-	if err != nil {
+	if data, err = col.seal(data); err != nil {
 		return err
 	}
 
-	return col.jsonData.Update(id, data)
+	if err = col.jsonData.Update(id, data); err != nil {
+		return err
+	}
+
+	col.after(op)
 
+	return nil
 }
 
 // Delete - deletes a record from the collection
@@ -95,7 +121,25 @@ func (col *LocalCollection) Delete(ctx context.Context, id string) error {
 	if id == "" {
 		return collection.ErrEmptyOrInvalidID
 	}
-	col.jsonData.Delete(id)
+
+	current, exists, err := col.jsonData.Get(id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		current, _ = col.open(current)
+	}
+
+	op := Operation{Op: OperationDelete, ID: id}
+	if _, err := col.before(ctx, &op, current); err != nil {
+		return err
+	}
+
+	if err := col.jsonData.Delete(id); err != nil {
+		return err
+	}
+
+	col.after(op)
 
 	return nil
 }
@@ -106,63 +150,88 @@ func (col *LocalCollection) Count(ctx context.Context) (int64, error) {
 	return col.jsonData.Count(), nil
 }
 
-// CreateMany - bulk insert records into the collection
+// CreateMany - bulk insert records into the collection. Documents are inserted all-or-nothing,
+// if any of them is rejected, the returned BulkError holds an error for every rejected document.
 func (col *LocalCollection) CreateMany(ctx context.Context, docs []interface{}) ([]result.BazaarResult, error) {
 
-	fn := func(doc interface{}) (key string, value []byte, err error) {
+	txn := col.jsonData.Begin()
+	bulkErr := &BulkError{}
+	res := []result.BazaarResult{}
+	ops := []Operation{}
+
+	for n, doc := range docs {
 
-		if key, _, err = collection.RequiredFields(doc); err != nil {
-			return
+		op := Operation{Op: OperationCreateMany}
+
+		key, value, err := marshalDocument(doc)
+		if err == nil {
+			op.ID = key
+			value, err = col.before(ctx, &op, value)
 		}
 
-		if key == "" {
-			err = collection.ErrEmptyOrInvalidID
-			return
+		if err == nil {
+			value, err = col.seal(value)
 		}
 
-		if value, err = json.Marshal(doc); err != nil {
-			return
+		if err == nil {
+			err = txn.Insert(key, value)
 		}
 
-		return
+		if err != nil {
+			bulkErr.Errors = append(bulkErr.Errors, DocumentError{Index: n, ID: key, Err: err})
+			continue
+		}
+
+		res = append(res, result.BazaarResult{ID: key})
+		ops = append(ops, op)
 	}
 
-	res := &resultCollector{r: []result.BazaarResult{}}
+	if len(bulkErr.Errors) != 0 {
+		txn.Rollback()
+		return []result.BazaarResult{}, bulkErr
+	}
 
-	err := col.jsonData.ForEach(docs, res, fn)
+	if err := txn.Commit(); err != nil {
+		return []result.BazaarResult{}, bulkError(docs, err)
+	}
 
-	return res.r, err
+	col.after(ops...)
 
+	return res, nil
 }
 
 // BulkUpdate - bulk update/inserts records into the collection
 func (col *LocalCollection) BulkUpdate(ctx context.Context, docs []interface{}) error {
 
-	var key string
-	var err error
-	var val []byte
-
 	keys := []string{}
 	items := []json.RawMessage{}
+	ops := []Operation{}
 
-	for _, doc := range docs {
-		if key, _, err = collection.RequiredFields(doc); err != nil {
+	for n, doc := range docs {
+		key, val, err := marshalDocument(doc)
+		if err != nil {
 			return err
 		}
 
-		if key == "" {
-			return collection.ErrEmptyOrInvalidID
+		op := Operation{Op: OperationBulkUpdate, ID: key}
+		if val, err = col.before(ctx, &op, val); err == nil {
+			val, err = col.seal(val)
 		}
 
-		if val, err = json.Marshal(doc); err != nil {
-			return err
+		if err != nil {
+			return &BulkError{Errors: []DocumentError{{Index: n, ID: key, Err: err}}}
 		}
 
 		keys = append(keys, key)
 		items = append(items, val)
+		ops = append(ops, op)
+	}
+
+	if err := col.jsonData.Bulk(keys, items); err != nil {
+		return bulkError(docs, err)
 	}
 
-	col.jsonData.Bulk(keys, items)
+	col.after(ops...)
 
 	return nil
 
@@ -170,26 +239,30 @@ func (col *LocalCollection) BulkUpdate(ctx context.Context, docs []interface{})
 
 // All - returns all available documents from the collection
 func (col *LocalCollection) All(ctx context.Context) (collection.BazaarCursor, error) {
-	data := col.jsonData.All()
-	return NewCursor(data), nil
+	data, err := col.jsonData.All()
+	if err != nil {
+		return nil, err
+	}
+	return col.cursor(data), nil
 }
 func (col *LocalCollection) Select(ctx context.Context, s selector.Expr, fld selector.Fields) (collection.BazaarCursor, error) {
 
-	fn := func(item json.RawMessage) bool {
+	s = col.rewrite(s)
+	match := matcher(s)
+	data := []json.RawMessage{}
 
-		r := map[string]interface{}{}
-
-		if err := json.Unmarshal(item, &r); err != nil {
-			return false
+	err := col.jsonData.Range(col.candidates(s), func(key string, item json.RawMessage) bool {
+		if match(item) {
+			data = append(data, item)
 		}
+		return true
+	})
 
-		return apply(r, s)
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := col.jsonData.Over(fn)
-	fmt.Println(len(data), "is error:", err)
-
-	return NewCursor(data), err
+	return col.cursor(data), nil
 }
 
 // AsQuerable - Normally this method should return QuerableCollection that allows querying the collection, but this is a simple key-value store
@@ -201,11 +274,60 @@ func (col *LocalCollection) Type() string {
 	return "local"
 }
 
+// marshalDocument - validates required fields of a document and returns its id and json representation
+func marshalDocument(doc interface{}) (id string, data []byte, err error) {
+
+	if id, _, err = collection.RequiredFields(doc); err != nil {
+		return
+	}
+
+	if id == "" {
+		err = collection.ErrEmptyOrInvalidID
+		return
+	}
+
+	data, err = json.Marshal(doc)
+
+	return
+}
+
+// bulkError - converts an error returned by a commit into a BulkError pointing to the rejected document
+func bulkError(docs []interface{}, err error) error {
+
+	kerr, ok := err.(*local.KeyError)
+	if !ok {
+		return err
+	}
+
+	for n, doc := range docs {
+		if id, _, _ := collection.RequiredFields(doc); id == kerr.Key {
+			return &BulkError{Errors: []DocumentError{{Index: n, ID: id, Err: kerr.Err}}}
+		}
+	}
+
+	return err
+}
+
+// matcher - returns a function that decodes an item and checks if it matches the selector
+func matcher(s selector.Expr) func(item json.RawMessage) bool {
+
+	return func(item json.RawMessage) bool {
+
+		r := map[string]interface{}{}
+
+		if err := json.Unmarshal(item, &r); err != nil {
+			return false
+		}
+
+		return apply(r, s)
+	}
+}
+
 func apply(item map[string]interface{}, s selector.Expr) bool {
 
 	if sel, ok := s.(*selector.CmpExpr); ok {
 
-		val, exists := item[sel.Field]
+		val, exists := document.Field(item, sel.Field)
 		if exists {
 			if isValueExpr(sel.Ex) {
 				return compare(sel.Op, val, sel.Ex)
@@ -239,11 +361,16 @@ func apply(item map[string]interface{}, s selector.Expr) bool {
 
 }
 
+// compare - compares a value of a field with a value expression, values of different types never match.
+// Numbers are compared by their value in the same way as index keys, so 1 and 1.0 are equal
 func compare(op string, val interface{}, expr selector.Expr) bool {
 
 	if sel, ok := expr.(selector.Bool); ok {
 
-		a := val.(bool)
+		a, ok := val.(bool)
+		if !ok {
+			return false
+		}
 		b := bool(sel)
 
 		if op == selector.EqOperator {
@@ -256,19 +383,28 @@ func compare(op string, val interface{}, expr selector.Expr) bool {
 	}
 
 	if sel, ok := expr.(selector.Int); ok {
-		a := int(val.(float64))
+		a, ok := integer(val)
+		if !ok {
+			return false
+		}
 		b := int(sel)
 
 		return evalNum(a, b, op)
 	}
 	if sel, ok := expr.(selector.Float); ok {
-		a := val.(float64)
+		a, ok := document.Number(val)
+		if !ok {
+			return false
+		}
 		b := float64(sel)
 
 		return evalNum(a, b, op)
 	}
 	if sel, ok := expr.(selector.String); ok {
-		a := val.(string)
+		a, ok := val.(string)
+		if !ok {
+			return false
+		}
 		b := string(sel)
 
 		r := strings.Compare(a, b)
@@ -287,6 +423,20 @@ func compare(op string, val interface{}, expr selector.Expr) bool {
 	return false
 }
 
+// integer - converts a numeric value of a document to an integer, a fraction is truncated
+func integer(val interface{}) (int, bool) {
+
+	if n, ok := val.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return int(i), true
+		}
+	}
+
+	f, ok := document.Number(val)
+
+	return int(f), ok
+}
+
 func evalNum[T int | float32 | float64](a, b T, op string) bool {
 
 	if op == selector.EqOperator {
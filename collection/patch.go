@@ -0,0 +1,79 @@
+package collection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/przebro/databazaar/collection"
+	"github.com/przebro/localstore/internal/document"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+var (
+	errInvalidPatch = errors.New("patch must be either a json object or an array of operations")
+	errIDChanged    = errors.New("patch must not change the id of a document")
+)
+
+// Patch - applies a patch to a document with a given id. The patch is either RFC 7396 JSON Merge Patch (a json object)
+// or RFC 6902 JSON Patch (an array of operations). The document is read, patched and written under the collection lock,
+// so concurrent patches of different fields don't overwrite each other.
+func (col *LocalCollection) Patch(ctx context.Context, id string, patch []byte) error {
+
+	if id == "" {
+		return collection.ErrEmptyOrInvalidID
+	}
+
+	var fn func(doc, patch []byte) ([]byte, error)
+
+	switch trimmed := bytes.TrimSpace(patch); {
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		fn = document.MergePatch
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		fn = document.JSONPatch
+	default:
+		return errInvalidPatch
+	}
+
+	h := col.hooks()
+	op := Operation{Op: OperationUpdate, ID: id}
+
+	_, err := col.jsonData.Modify(id, col.modifier(func(item json.RawMessage) (json.RawMessage, error) {
+
+		data, err := fn(item, patch)
+		if err != nil {
+			return nil, err
+		}
+
+		if pid, err := documentID(data); err != nil || pid != id {
+			return nil, errIDChanged
+		}
+
+		return h.call(ctx, &op, data)
+	}))
+
+	if err == local.ErrKeyNotFound {
+		return collection.ErrNoDocuments
+	}
+
+	if err != nil {
+		return err
+	}
+
+	col.after(op)
+
+	return nil
+}
+
+// documentID - returns an id of a marshaled document
+func documentID(data []byte) (string, error) {
+
+	doc := struct {
+		ID string `json:"_id"`
+	}{}
+
+	err := json.Unmarshal(data, &doc)
+
+	return doc.ID, err
+}
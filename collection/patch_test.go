@@ -0,0 +1,142 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/przebro/databazaar/collection"
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/localstore/internal/document"
+)
+
+func TestMergePatch(t *testing.T) {
+
+	c := newTestCollection(t, "mergepatch")
+	c.Create(context.Background(), tst.TestDocument{ID: "patch_01", Title: "Alien", Year: 1978, Genre: "horror"})
+
+	err := c.Patch(context.Background(), "patch_01", []byte(`{"year": 1979, "genre": null}`))
+	if err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	doc := map[string]interface{}{}
+	c.Get(context.Background(), "patch_01", &doc)
+
+	if doc["year"] != float64(1979) || doc["title"] != "Alien" {
+		t.Error("unexpected result:", doc)
+	}
+
+	if _, exists := doc["genre"]; exists {
+		t.Error("unexpected result:", doc)
+	}
+
+	if err := c.Patch(context.Background(), "patch_01", []byte(`{"_id": "patch_02"}`)); err != errIDChanged {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.Patch(context.Background(), "patch_02", []byte(`{"year": 1979}`)); err != collection.ErrNoDocuments {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.Patch(context.Background(), "patch_01", []byte(`"year"`)); err != errInvalidPatch {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+
+	c := newTestCollection(t, "jsonpatch")
+	c.Create(context.Background(), map[string]interface{}{"_id": "patch_01", "title": "Alien", "tags": []string{"space", "horror"}})
+
+	type testTable struct {
+		patch    string
+		expected string
+		err      bool
+	}
+
+	table := []testTable{
+		{`[{"op":"add","path":"/year","value":1979}]`, `{"_id":"patch_01","tags":["space","horror"],"title":"Alien","year":1979}`, false},
+		{`[{"op":"add","path":"/tags/1","value":"scifi"}]`, `{"_id":"patch_01","tags":["space","scifi","horror"],"title":"Alien","year":1979}`, false},
+		{`[{"op":"add","path":"/tags/-","value":"classic"}]`, `{"_id":"patch_01","tags":["space","scifi","horror","classic"],"title":"Alien","year":1979}`, false},
+		{`[{"op":"remove","path":"/tags/0"}]`, `{"_id":"patch_01","tags":["scifi","horror","classic"],"title":"Alien","year":1979}`, false},
+		{`[{"op":"replace","path":"/title","value":"Aliens"}]`, `{"_id":"patch_01","tags":["scifi","horror","classic"],"title":"Aliens","year":1979}`, false},
+		{`[{"op":"move","from":"/title","path":"/name"}]`, `{"_id":"patch_01","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, false},
+		{`[{"op":"copy","from":"/tags/2","path":"/kind"}]`, `{"_id":"patch_01","kind":"classic","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, false},
+		{`[{"op":"test","path":"/year","value":1979.0},{"op":"remove","path":"/kind"}]`, `{"_id":"patch_01","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, false},
+		{`[{"op":"remove","path":"/year"},{"op":"test","path":"/name","value":"Alien"}]`, `{"_id":"patch_01","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, true},
+		{`[{"op":"replace","path":"/missing","value":1}]`, `{"_id":"patch_01","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, true},
+		{`[{"op":"add","path":"/tags/5","value":1}]`, `{"_id":"patch_01","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, true},
+		{`[{"op":"move","from":"/tags","path":"/tags/0"}]`, `{"_id":"patch_01","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, true},
+		{`[{"op":"unknown","path":"/year"}]`, `{"_id":"patch_01","name":"Aliens","tags":["scifi","horror","classic"],"year":1979}`, true},
+	}
+
+	for _, n := range table {
+
+		err := c.Patch(context.Background(), "patch_01", []byte(n.patch))
+		if (err != nil) != n.err {
+			t.Error("unexpected result:", n.patch, err)
+		}
+
+		data, _, _ := c.jsonData.Get("patch_01")
+		if string(data) != n.expected {
+			t.Error("unexpected result:", n.patch, string(data))
+		}
+	}
+
+	err := c.Patch(context.Background(), "patch_01", []byte(`[{"op":"test","path":"/year","value":1980}]`))
+	if !errors.Is(err, document.ErrTestFailed) {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.Patch(context.Background(), "patch_01", []byte(`[{"op":"replace","path":"","value":{"_id":"patch_01","title":"Alien"}}]`)); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if data, _, _ := c.jsonData.Get("patch_01"); string(data) != `{"_id":"patch_01","title":"Alien"}` {
+		t.Error("unexpected result:", string(data))
+	}
+}
+
+func TestConcurrentPatch(t *testing.T) {
+
+	c := newTestCollection(t, "concurrentpatch")
+	c.Create(context.Background(), map[string]interface{}{"_id": "patch_01"})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Patch(context.Background(), "patch_01", []byte(fmt.Sprintf(`{"field_%d": %d}`, i, i)))
+		}(i)
+	}
+	wg.Wait()
+
+	doc := map[string]interface{}{}
+	c.Get(context.Background(), "patch_01", &doc)
+
+	if len(doc) != 51 {
+		t.Error("unexpected result:", len(doc))
+	}
+}
+
+func TestModifyPanic(t *testing.T) {
+
+	c := newTestCollection(t, "modifypanic")
+	c.Create(context.Background(), map[string]interface{}{"_id": "patch_01"})
+
+	func() {
+		defer func() { recover() }()
+		c.jsonData.Modify("patch_01", func(item json.RawMessage) (json.RawMessage, error) {
+			panic("modifier")
+		})
+	}()
+
+	if err := c.Patch(context.Background(), "patch_01", []byte(`{"year": 1979}`)); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
@@ -0,0 +1,17 @@
+package collection
+
+import (
+	"context"
+
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// ErrReadOnly - returned when a collection opened by a shared reader is changed
+var ErrReadOnly = local.ErrReadOnly
+
+// ReadOnly - returns true if the collection is opened by a shared reader, such a collection follows changes
+// written by another process and can't be changed
+func (col *LocalCollection) ReadOnly(ctx context.Context) bool {
+
+	return col.jsonData.ReadOnly()
+}
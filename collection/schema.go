@@ -0,0 +1,44 @@
+package collection
+
+import (
+	"context"
+
+	"github.com/przebro/localstore/internal/schema"
+)
+
+// ValidationError - returned when a document doesn't match the schema of a collection, it holds all failed constraints
+type ValidationError = schema.ValidationError
+
+// Violation - a failed constraint, Path is a JSON Pointer to the failing value
+type Violation = schema.Violation
+
+// SetSchema - sets a JSON Schema of the collection, every created or updated document must match it.
+// Supported keywords are type, enum, const, required, properties, additionalProperties, items, minItems, maxItems,
+// uniqueItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum and exclusiveMaximum.
+// The schema is stored next to the collection file, an empty schema removes it. Existing documents are not checked.
+func (col *LocalCollection) SetSchema(ctx context.Context, schema []byte) error {
+	return col.jsonData.SetSchema(schema)
+}
+
+// Schema - returns the schema of the collection or nil if it is not set
+func (col *LocalCollection) Schema(ctx context.Context) []byte {
+	return col.jsonData.Schema()
+}
+
+// Validate - checks existing documents against the schema of the collection, if some of them don't match it,
+// the returned BulkError holds an error for every such document in the order of insertion, Index is always -1.
+// Encrypted fields are decrypted before documents are checked, redacted fields are checked as they are stored
+func (col *LocalCollection) Validate(ctx context.Context) error {
+
+	errs := col.jsonData.Validate(col.opener())
+	if len(errs) == 0 {
+		return nil
+	}
+
+	bulkErr := &BulkError{}
+	for _, e := range errs {
+		bulkErr.Errors = append(bulkErr.Errors, DocumentError{Index: -1, ID: e.Key, Err: e.Err})
+	}
+
+	return bulkErr
+}
@@ -0,0 +1,127 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+const movieSchema = `{
+	"type" : "object",
+	"required" : ["_id", "title"],
+	"properties" : {
+		"_id" : {"type" : "string", "pattern" : "^movie_[0-9]+$"},
+		"title" : {"type" : "string", "minLength" : 1, "maxLength" : 20},
+		"year" : {"type" : "integer", "minimum" : 1900, "exclusiveMaximum" : 2100},
+		"score" : {"type" : "number", "maximum" : 10},
+		"genre" : {"enum" : ["drama", "comedy"]},
+		"tags" : {"type" : "array", "items" : {"type" : "string"}, "maxItems" : 2, "uniqueItems" : true}
+	},
+	"additionalProperties" : false
+}`
+
+func violations(err error) []Violation {
+
+	verr := &ValidationError{}
+	if !errors.As(err, &verr) {
+		return nil
+	}
+
+	return verr.Violations
+}
+
+func TestSchema(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	data, _ := manager.NewData("schema", 0, false)
+	c := &LocalCollection{jsonData: data}
+
+	c.Create(context.Background(), map[string]interface{}{"_id": "invalid", "title": ""})
+
+	if err := c.SetSchema(context.Background(), []byte(`{"type" : "unknown"}`)); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if err := c.SetSchema(context.Background(), []byte(movieSchema)); err != nil {
+		t.Fatal("unexpected result:", err)
+	}
+
+	if _, err := c.Create(context.Background(), map[string]interface{}{"_id": "movie_01", "title": "valid", "year": 1999, "tags": []string{"a"}}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	tt := []struct {
+		doc  map[string]interface{}
+		path string
+	}{
+		{map[string]interface{}{"_id": "movie_02"}, ""},
+		{map[string]interface{}{"_id": "movie_02", "title": 5}, "/title"},
+		{map[string]interface{}{"_id": "movie_02", "title": "123456789012345678901"}, "/title"},
+		{map[string]interface{}{"_id": "movie_02", "title": "t", "year": 1999.5}, "/year"},
+		{map[string]interface{}{"_id": "movie_02", "title": "t", "year": 2100}, "/year"},
+		{map[string]interface{}{"_id": "movie_02", "title": "t", "score": 11}, "/score"},
+		{map[string]interface{}{"_id": "movie_02", "title": "t", "genre": "horror"}, "/genre"},
+		{map[string]interface{}{"_id": "movie_02", "title": "t", "tags": []interface{}{"a", 1}}, "/tags/1"},
+		{map[string]interface{}{"_id": "movie_02", "title": "t", "tags": []string{"a", "a"}}, "/tags"},
+		{map[string]interface{}{"_id": "movie_02", "title": "t", "other": true}, "/other"},
+		{map[string]interface{}{"_id": "film_02", "title": "t"}, "/_id"},
+	}
+
+	for _, tc := range tt {
+
+		_, err := c.Create(context.Background(), tc.doc)
+		v := violations(err)
+		if len(v) != 1 || v[0].Path != tc.path {
+			t.Error("unexpected result:", tc.doc, err)
+		}
+	}
+
+	err := c.BulkUpdate(context.Background(), []interface{}{
+		map[string]interface{}{"_id": "movie_03", "title": "t"},
+		map[string]interface{}{"_id": "movie_04"},
+	})
+
+	berr, ok := err.(*BulkError)
+	if !ok || berr.Errors[0].Index != 1 || violations(berr.Errors[0].Err) == nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if err = c.UpdateOne(context.Background(), "movie_01", Update{"$set": {"score": 12}}, nil); violations(err) == nil {
+		t.Error("unexpected result:", err)
+	}
+
+	err = c.Validate(context.Background())
+	if berr, ok = err.(*BulkError); !ok || len(berr.Errors) != 1 || berr.Errors[0].ID != "invalid" {
+		t.Error("unexpected result:", err)
+	}
+
+	manager.Close()
+
+	data, _ = manager.GetData("schema", 0, false)
+	c = &LocalCollection{jsonData: data}
+
+	if c.Schema(context.Background()) == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err = c.Create(context.Background(), tst.TestDocument{ID: "movie_05"}); violations(err) == nil {
+		t.Error("unexpected result:", err)
+	}
+
+	c.SetSchema(context.Background(), nil)
+
+	if _, err = c.Create(context.Background(), tst.TestDocument{ID: "movie_05"}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err = os.Stat(dir + "/schema.schema.json"); !os.IsNotExist(err) {
+		t.Error("unexpected result:", err)
+	}
+}
@@ -0,0 +1,162 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/przebro/databazaar/collection"
+	"github.com/przebro/databazaar/result"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// Transaction - groups reads and writes on a collection, changes are applied all-or-nothing on Commit.
+// Before hooks are called when a change is staged, after hooks when the transaction is committed
+type Transaction struct {
+	txn *local.Txn
+	ops []Operation
+}
+
+// Begin - starts a new transaction on the collection
+func (col *LocalCollection) Begin(ctx context.Context) (*Transaction, error) {
+	return &Transaction{txn: col.jsonData.Begin()}, nil
+}
+
+// Get - returns a single record with given id, changes made within the transaction are visible
+func (tx *Transaction) Get(ctx context.Context, id string, result interface{}) error {
+
+	data, exists, err := tx.txn.Get(id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return collection.ErrNoDocuments
+	}
+
+	data, err = tx.collection().open(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// Create - stages a new record, returns an error if a record with the same id already exists
+func (tx *Transaction) Create(ctx context.Context, document interface{}) (*result.BazaarResult, error) {
+
+	id, doc, err := marshalDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	col := tx.collection()
+
+	op := Operation{Op: OperationCreate, ID: id}
+	if doc, err = col.before(ctx, &op, doc); err != nil {
+		return nil, err
+	}
+
+	if doc, err = col.seal(doc); err != nil {
+		return nil, err
+	}
+
+	if err = tx.txn.Insert(id, doc); err != nil {
+		return nil, err
+	}
+	tx.ops = append(tx.ops, op)
+
+	return &result.BazaarResult{ID: id}, nil
+}
+
+// Update - stages an update of a single record
+func (tx *Transaction) Update(ctx context.Context, doc interface{}) error {
+
+	id, data, err := marshalDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	col := tx.collection()
+
+	op := Operation{Op: OperationUpdate, ID: id}
+	if data, err = col.before(ctx, &op, data); err != nil {
+		return err
+	}
+
+	if data, err = col.seal(data); err != nil {
+		return err
+	}
+
+	if err = tx.txn.Update(id, data); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, op)
+
+	return nil
+}
+
+// Delete - stages removal of a record
+func (tx *Transaction) Delete(ctx context.Context, id string) error {
+
+	if id == "" {
+		return collection.ErrEmptyOrInvalidID
+	}
+
+	col := tx.collection()
+
+	current, exists, err := tx.txn.Get(id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		current, _ = col.open(current)
+	}
+
+	op := Operation{Op: OperationDelete, ID: id}
+	if _, err := col.before(ctx, &op, current); err != nil {
+		return err
+	}
+
+	if err := tx.txn.Delete(id); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, op)
+
+	return nil
+}
+
+// Commit - applies all staged changes to the collection
+func (tx *Transaction) Commit(ctx context.Context) error {
+
+	if err := tx.txn.Commit(); err != nil {
+		return err
+	}
+
+	tx.Committed()
+
+	return nil
+}
+
+// Committed - schedules after hooks for changes of the transaction, it is called by a transaction spanning
+// multiple collections after it is committed
+func (tx *Transaction) Committed() {
+
+	ops := tx.ops
+	tx.ops = nil
+
+	tx.collection().after(ops...)
+}
+
+// Rollback - discards all staged changes
+func (tx *Transaction) Rollback(ctx context.Context) error {
+	return tx.txn.Rollback()
+}
+
+// collection - returns a collection of the transaction
+func (tx *Transaction) collection() *LocalCollection {
+	return &LocalCollection{jsonData: tx.txn.Data()}
+}
+
+// WrapTransaction - wraps a part of a transaction spanning multiple collections
+func WrapTransaction(txn *local.Txn) *Transaction {
+	return &Transaction{txn: txn}
+}
@@ -0,0 +1,157 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/przebro/databazaar/collection"
+	tst "github.com/przebro/databazaar/collection/testing"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+func newTestCollection(t *testing.T, name string) *LocalCollection {
+
+	data, err := local.GetFileManager("../").NewData(name, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Collection(data).(*LocalCollection)
+}
+
+func TestTransactionCommit(t *testing.T) {
+
+	c := newTestCollection(t, "txcommit")
+	c.Create(context.Background(), tst.TestDocument{ID: "tx_01", Title: "Alien", Year: 1979})
+
+	tx, _ := c.Begin(context.Background())
+
+	if _, err := tx.Create(context.Background(), tst.TestDocument{ID: "tx_02", Title: "Aliens", Year: 1986}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err := tx.Create(context.Background(), tst.TestDocument{ID: "tx_01"}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if err := tx.Update(context.Background(), tst.TestDocument{ID: "tx_01", Title: "Alien", Year: 1980}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	doc := tst.TestDocument{}
+	if err := tx.Get(context.Background(), "tx_02", &doc); err != nil || doc.Year != 1986 {
+		t.Error("unexpected result:", err, doc.Year)
+	}
+
+	if err := c.Get(context.Background(), "tx_02", &doc); err != collection.ErrNoDocuments {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if n, _ := c.Count(context.Background()); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	c.Get(context.Background(), "tx_01", &doc)
+	if doc.Year != 1980 {
+		t.Error("unexpected result:", doc.Year)
+	}
+
+	if err := tx.Commit(context.Background()); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+
+	c := newTestCollection(t, "txrollback")
+	c.Create(context.Background(), tst.TestDocument{ID: "tx_01", Title: "Alien", Year: 1979})
+
+	tx, _ := c.Begin(context.Background())
+	tx.Create(context.Background(), tst.TestDocument{ID: "tx_02", Title: "Aliens", Year: 1986})
+	tx.Delete(context.Background(), "tx_01")
+
+	doc := tst.TestDocument{}
+	if err := tx.Get(context.Background(), "tx_01", &doc); err != collection.ErrNoDocuments {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := tx.Rollback(context.Background()); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if n, _ := c.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	if err := c.Get(context.Background(), "tx_01", &doc); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestTransactionConflict(t *testing.T) {
+
+	c := newTestCollection(t, "txconflict")
+
+	tx, _ := c.Begin(context.Background())
+	tx.Create(context.Background(), tst.TestDocument{ID: "tx_01", Title: "Alien"})
+	tx.Create(context.Background(), tst.TestDocument{ID: "tx_02", Title: "Aliens"})
+
+	c.Create(context.Background(), tst.TestDocument{ID: "tx_02", Title: "Alien 3"})
+
+	err := tx.Commit(context.Background())
+	kerr := &local.KeyError{}
+	if !errors.As(err, &kerr) || kerr.Key != "tx_02" {
+		t.Error("unexpected result:", err)
+	}
+
+	if n, _ := c.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+}
+
+func TestCreateManyAtomic(t *testing.T) {
+
+	c := newTestCollection(t, "txmany")
+	c.Create(context.Background(), tst.TestDocument{ID: "tx_03", Title: "Alien Resurrection"})
+
+	docs := []interface{}{
+		tst.TestDocument{ID: "tx_01", Title: "Alien"},
+		tst.TestDocument{Title: "Aliens"},
+		tst.TestDocument{ID: "tx_03", Title: "Alien Resurrection"},
+		tst.TestDocument{ID: "tx_01", Title: "Alien"},
+		func() {},
+	}
+
+	r, err := c.CreateMany(context.Background(), docs)
+
+	berr, ok := err.(*BulkError)
+	if !ok {
+		t.Fatal("unexpected result:", err)
+	}
+
+	if len(r) != 0 {
+		t.Error("unexpected result:", len(r))
+	}
+
+	indexes := []int{}
+	for _, e := range berr.Errors {
+		indexes = append(indexes, e.Index)
+	}
+
+	if len(indexes) != 4 || indexes[0] != 1 || indexes[1] != 2 || indexes[2] != 3 || indexes[3] != 4 {
+		t.Error("unexpected result:", indexes)
+	}
+
+	if berr.Errors[0].Err != collection.ErrEmptyOrInvalidID {
+		t.Error("unexpected result:", berr.Errors[0].Err)
+	}
+
+	if n, _ := c.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+}
@@ -0,0 +1,51 @@
+package collection
+
+import (
+	"context"
+	"time"
+
+	"github.com/przebro/databazaar/result"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// ExpiresField - a field of a document that holds its expiration time, a document can declare it
+// e.g. Expires time.Time `json:"_expires,omitempty"` to set the expiration time explicitly
+const ExpiresField = local.ExpiresField
+
+// CreateWithTTL - creates a new record that expires after a given time. Expired records are not visible
+// and are removed from the collection in background
+func (col *LocalCollection) CreateWithTTL(ctx context.Context, document interface{}, ttl time.Duration) (*result.BazaarResult, error) {
+
+	id, doc, err := marshalDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc, err = local.Expire(doc, time.Now().Add(ttl)); err != nil {
+		return nil, err
+	}
+
+	op := Operation{Op: OperationCreate, ID: id}
+	if doc, err = col.before(ctx, &op, doc); err != nil {
+		return nil, err
+	}
+
+	if doc, err = col.seal(doc); err != nil {
+		return nil, err
+	}
+
+	if err = col.jsonData.Insert(id, doc); err != nil {
+		return nil, err
+	}
+
+	col.after(op)
+
+	return &result.BazaarResult{ID: id}, nil
+}
+
+// SetTTL - sets a default time to live of records that are created or updated without an expiration time,
+// zero disables expiration of such records
+func (col *LocalCollection) SetTTL(ctx context.Context, ttl time.Duration) error {
+
+	return col.jsonData.SetTTL(ttl)
+}
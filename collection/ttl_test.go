@@ -0,0 +1,141 @@
+package collection
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/przebro/databazaar/collection"
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/selector"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+func TestCreateWithTTL(t *testing.T) {
+
+	c := newTestCollection(t, "createttl")
+	c.CreateIndex(context.Background(), "year")
+
+	c.Create(context.Background(), tst.TestDocument{ID: "ttl_01", Year: 1979})
+	c.CreateWithTTL(context.Background(), tst.TestDocument{ID: "ttl_02", Year: 1979}, 50*time.Millisecond)
+	c.CreateWithTTL(context.Background(), tst.TestDocument{ID: "ttl_03", Year: 1979}, time.Hour)
+
+	if n, _ := c.Count(context.Background()); n != 3 {
+		t.Error("unexpected result:", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	doc := tst.TestDocument{}
+	if err := c.Get(context.Background(), "ttl_02", &doc); err != collection.ErrNoDocuments {
+		t.Error("unexpected result:", err)
+	}
+
+	if n, _ := c.Count(context.Background()); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	if n, _ := c.CountWhere(context.Background(), selector.Eq("year", selector.Int(1979))); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	crsr, _ := c.All(context.Background())
+	docs := []tst.TestDocument{}
+	crsr.All(context.Background(), &docs)
+	if len(docs) != 2 {
+		t.Error("unexpected result:", len(docs))
+	}
+
+	crsr, _ = c.Select(context.Background(), selector.Eq("year", selector.Int(1979)), selector.Fields{})
+	if len(crsr.(*cursor).data) != 2 {
+		t.Error("unexpected result:", len(crsr.(*cursor).data))
+	}
+
+	if _, err := c.Create(context.Background(), tst.TestDocument{ID: "ttl_02", Year: 1980}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.Get(context.Background(), "ttl_02", &doc); err != nil || doc.Year != 1980 {
+		t.Error("unexpected result:", err, doc)
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+
+	c := newTestCollection(t, "defaultttl")
+	c.SetTTL(context.Background(), 50*time.Millisecond)
+
+	c.Create(context.Background(), tst.TestDocument{ID: "ttl_01"})
+	c.CreateWithTTL(context.Background(), tst.TestDocument{ID: "ttl_02"}, time.Hour)
+
+	doc := map[string]interface{}{}
+	c.Get(context.Background(), "ttl_01", &doc)
+	if _, exists := doc[ExpiresField]; !exists {
+		t.Error("unexpected result:", doc)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n, _ := c.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	c.SetTTL(context.Background(), 0)
+	c.Create(context.Background(), tst.TestDocument{ID: "ttl_03"})
+
+	doc = map[string]interface{}{}
+	c.Get(context.Background(), "ttl_03", &doc)
+	if _, exists := doc[ExpiresField]; exists {
+		t.Error("unexpected result:", doc)
+	}
+}
+
+func TestDefaultTTLSchema(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	manager := local.GetFileManager(dir)
+	defer manager.Close()
+
+	data, _ := manager.NewData("defaultttlschema", 0, false)
+	c := &LocalCollection{jsonData: data}
+	c.SetTTL(context.Background(), time.Hour)
+
+	err := c.SetSchema(context.Background(), []byte(`{
+		"type" : "object",
+		"required" : ["_id", "_expires"],
+		"properties" : {"_id" : {"type" : "string"}, "_expires" : {"type" : "string"}},
+		"additionalProperties" : false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Create(context.Background(), map[string]interface{}{"_id": "ttl_01"}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if err := c.Update(context.Background(), map[string]interface{}{"_id": "ttl_01"}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+
+	c := newTestCollection(t, "purgettl")
+	c.CreateWithTTL(context.Background(), tst.TestDocument{ID: "ttl_01"}, 10*time.Millisecond)
+	c.Create(context.Background(), tst.TestDocument{ID: "ttl_02"})
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.jsonData.Purge()
+	if n := c.jsonData.Purge(); n != 0 {
+		t.Error("unexpected result:", n)
+	}
+
+	if n, _ := c.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+}
@@ -0,0 +1,141 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/przebro/databazaar/collection"
+	"github.com/przebro/databazaar/selector"
+	"github.com/przebro/localstore/internal/document"
+	local "github.com/przebro/localstore/internal/file"
+)
+
+// Update - update operators applied to a document, each operator holds fields with operands:
+// $set, $setIfAbsent, $unset, $inc, $min, $max, $push, $addToSet and $pull e.g. Update{"$inc": {"score": 1}}.
+// $push and $addToSet accept {"$each": [...]} to add multiple values, nested fields are separated by a dot.
+type Update = document.Operators
+
+// UpdateOne - atomically applies update operators to a document with a given id.
+// The document is modified under the collection lock, if result is not nil the new document is decoded into it.
+func (col *LocalCollection) UpdateOne(ctx context.Context, id string, update Update, result interface{}) error {
+
+	if id == "" {
+		return collection.ErrEmptyOrInvalidID
+	}
+
+	h := col.hooks()
+	op := Operation{Op: OperationUpdate, ID: id}
+
+	data, err := col.jsonData.Modify(id, col.modifier(func(item json.RawMessage) (json.RawMessage, error) {
+
+		data, err := document.ApplyOperators(item, update)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.call(ctx, &op, data)
+	}))
+
+	if err == local.ErrKeyNotFound {
+		return collection.ErrNoDocuments
+	}
+
+	if err != nil {
+		return err
+	}
+
+	col.after(op)
+
+	if result == nil {
+		return nil
+	}
+
+	if data, err = col.open(data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// UpdateMany - applies update operators to every document that matches the selector. Documents are matched and modified
+// under a single lock acquisition, if the update fails for any of them none is changed. Returns a number of updated documents.
+func (col *LocalCollection) UpdateMany(ctx context.Context, s selector.Expr, update Update) (int64, error) {
+
+	h := col.hooks()
+	ops := []Operation{}
+
+	num, err := col.jsonData.UpdateWhere(matcher(col.rewrite(s)), col.modifier(func(item json.RawMessage) (json.RawMessage, error) {
+
+		data, err := document.ApplyOperators(item, update)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := documentID(data)
+		if err != nil {
+			return nil, err
+		}
+
+		op := Operation{Op: OperationUpdateMany, ID: id}
+		if data, err = h.call(ctx, &op, data); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+
+		return data, nil
+	}))
+
+	if err != nil {
+		return int64(num), err
+	}
+
+	col.after(ops...)
+
+	return int64(num), nil
+}
+
+// DeleteMany - deletes every document that matches the selector, returns a number of deleted documents.
+// If a before hook rejects any of them none is deleted
+func (col *LocalCollection) DeleteMany(ctx context.Context, s selector.Expr) (int64, error) {
+
+	h := col.hooks()
+	match := matcher(col.rewrite(s))
+	open := col.opener()
+	ops := []Operation{}
+
+	num, err := col.jsonData.DeleteWhere(func(item json.RawMessage) (bool, error) {
+
+		if !match(item) {
+			return false, nil
+		}
+
+		data := item
+		if open != nil {
+			var err error
+			if data, err = open(item); err != nil {
+				return false, err
+			}
+		}
+
+		id, err := documentID(data)
+		if err != nil {
+			return false, err
+		}
+
+		op := Operation{Op: OperationDeleteMany, ID: id}
+		if _, err = h.call(ctx, &op, data); err != nil {
+			return false, err
+		}
+		ops = append(ops, op)
+
+		return true, nil
+	})
+
+	if err != nil {
+		return int64(num), err
+	}
+
+	col.after(ops...)
+
+	return int64(num), nil
+}
@@ -0,0 +1,172 @@
+package collection
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/przebro/databazaar/collection"
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/selector"
+)
+
+func TestUpdateOne(t *testing.T) {
+
+	c := newTestCollection(t, "updateone")
+	c.Create(context.Background(), map[string]interface{}{"_id": "upd_01", "title": "Alien", "score": 8, "tags": []string{"scifi"}, "year": 1979})
+
+	type testTable struct {
+		update   Update
+		expected string
+		err      bool
+	}
+
+	table := []testTable{
+		{Update{"$inc": {"score": 1, "views": 1}}, `{"_id":"upd_01","score":9,"tags":["scifi"],"title":"Alien","views":1,"year":1979}`, false},
+		{Update{"$inc": {"score": 0.5}}, `{"_id":"upd_01","score":9.5,"tags":["scifi"],"title":"Alien","views":1,"year":1979}`, false},
+		{Update{"$set": {"meta.director": "Ridley Scott"}, "$unset": {"views": ""}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["scifi"],"title":"Alien","year":1979}`, false},
+		{Update{"$push": {"tags": "horror"}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["scifi","horror"],"title":"Alien","year":1979}`, false},
+		{Update{"$addToSet": {"tags": map[string]interface{}{"$each": []string{"horror", "classic"}}}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["scifi","horror","classic"],"title":"Alien","year":1979}`, false},
+		{Update{"$pull": {"tags": "scifi"}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["horror","classic"],"title":"Alien","year":1979}`, false},
+		{Update{"$min": {"year": 1978}, "$max": {"score": 9}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["horror","classic"],"title":"Alien","year":1978}`, false},
+		{Update{"$inc": {"title": 1}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["horror","classic"],"title":"Alien","year":1978}`, true},
+		{Update{"$push": {"title": "x"}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["horror","classic"],"title":"Alien","year":1978}`, true},
+		{Update{"$set": {"_id": "upd_02"}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["horror","classic"],"title":"Alien","year":1978}`, true},
+		{Update{"$rename": {"title": "name"}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"score":9.5,"tags":["horror","classic"],"title":"Alien","year":1978}`, true},
+		{Update{"$setIfAbsent": {"year": 2000, "rating": "R"}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"rating":"R","score":9.5,"tags":["horror","classic"],"title":"Alien","year":1978}`, false},
+		{Update{"$inc": {"year": int64(math.MaxInt64)}}, `{"_id":"upd_01","meta":{"director":"Ridley Scott"},"rating":"R","score":9.5,"tags":["horror","classic"],"title":"Alien","year":1978}`, true},
+	}
+
+	for _, n := range table {
+
+		err := c.UpdateOne(context.Background(), "upd_01", n.update, nil)
+		if (err != nil) != n.err {
+			t.Error("unexpected result:", n.update, err)
+		}
+
+		data, _, _ := c.jsonData.Get("upd_01")
+		if string(data) != n.expected {
+			t.Error("unexpected result:", n.update, string(data))
+		}
+	}
+
+	doc := map[string]interface{}{}
+	if err := c.UpdateOne(context.Background(), "upd_01", Update{"$inc": {"score": -0.5}}, &doc); err != nil || doc["score"] != float64(9) {
+		t.Error("unexpected result:", err, doc)
+	}
+
+	if err := c.UpdateOne(context.Background(), "upd_02", Update{"$inc": {"score": 1}}, nil); err != collection.ErrNoDocuments {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestConcurrentIncrement(t *testing.T) {
+
+	c := newTestCollection(t, "concurrentinc")
+	c.Create(context.Background(), map[string]interface{}{"_id": "counter", "value": 0})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.UpdateOne(context.Background(), "counter", Update{"$inc": {"value": 1}}, nil)
+		}()
+	}
+	wg.Wait()
+
+	doc := map[string]interface{}{}
+	c.Get(context.Background(), "counter", &doc)
+
+	if doc["value"] != float64(100) {
+		t.Error("unexpected result:", doc)
+	}
+}
+
+func TestUpdateMany(t *testing.T) {
+
+	c := newTestCollection(t, "updatemany")
+	for _, doc := range testCollection {
+		c.Create(context.Background(), doc)
+	}
+
+	num, err := c.UpdateMany(context.Background(), selector.Eq("year", selector.Int(1986)), Update{"$set": {"decade": "80s"}, "$inc": {"score": 1}})
+	if err != nil || num != 2 {
+		t.Error("unexpected result:", num, err)
+	}
+
+	doc := tst.TestDocument{}
+	c.Get(context.Background(), "document_07", &doc)
+	if doc.Score != 9.1 {
+		t.Error("unexpected result:", doc.Score)
+	}
+
+	num, err = c.UpdateMany(context.Background(), selector.Gte("year", selector.Int(1980)), Update{"$push": {"title": "x"}})
+	if err == nil || num != 0 {
+		t.Error("unexpected result:", num, err)
+	}
+
+	c.Get(context.Background(), "document_07", &doc)
+	if doc.Title != "Platoon" {
+		t.Error("unexpected result:", doc.Title)
+	}
+
+	num, _ = c.UpdateMany(context.Background(), selector.Eq("year", selector.Int(2001)), Update{"$set": {"decade": "00s"}})
+	if num != 0 {
+		t.Error("unexpected result:", num)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+
+	c := newTestCollection(t, "deletemany")
+	for _, doc := range testCollection {
+		c.Create(context.Background(), doc)
+	}
+
+	num, err := c.DeleteMany(context.Background(), selector.Or(selector.Eq("oscars", selector.Bool(true)), selector.Lt("year", selector.Int(1981))))
+	if err != nil || num != 5 {
+		t.Error("unexpected result:", num, err)
+	}
+
+	if n, _ := c.Count(context.Background()); n != int64(len(testCollection))-5 {
+		t.Error("unexpected result:", n)
+	}
+}
+
+func TestUpdateManyPanic(t *testing.T) {
+
+	c := newTestCollection(t, "updatemanypanic")
+	for _, doc := range testCollection {
+		c.Create(context.Background(), doc)
+	}
+
+	for _, fn := range []func(){
+		func() {
+			c.UpdateMany(context.Background(), selector.Eq("title", selector.Int(1)), Update{"$set": {"decade": "80s"}})
+		},
+		func() { c.DeleteMany(context.Background(), selector.Eq("title", selector.Int(1))) },
+	} {
+		func() {
+			defer func() { recover() }()
+			fn()
+		}()
+	}
+
+	done := make(chan int64)
+	go func() {
+		n, _ := c.Count(context.Background())
+		done <- n
+	}()
+
+	select {
+	case n := <-done:
+		if n != int64(len(testCollection)) {
+			t.Error("unexpected result:", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("collection is locked")
+	}
+}
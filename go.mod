@@ -1,5 +1,15 @@
 module github.com/przebro/localstore
 
-go 1.19
+go 1.22
 
-require github.com/przebro/databazaar v0.0.0-20230311115505-36adbd379b42
+require (
+	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/klauspost/compress v1.18.0
+	github.com/przebro/databazaar v0.0.0-20230311115505-36adbd379b42
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
@@ -0,0 +1,251 @@
+// Package document provides operations on generic json documents
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	errNotAnObject = errors.New("document is not an object")
+)
+
+// Decode - decodes a json document into a generic value, numbers are kept as json.Number to preserve their precision
+func Decode(data []byte) (interface{}, error) {
+
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// DecodeObject - decodes a json document that must be an object
+func DecodeObject(data []byte) (map[string]interface{}, error) {
+
+	v, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errNotAnObject
+	}
+
+	return obj, nil
+}
+
+// Copy - returns a deep copy of a generic value
+func Copy(v interface{}) interface{} {
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			m[k] = Copy(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(val))
+		for i, e := range val {
+			s[i] = Copy(e)
+		}
+		return s
+	}
+
+	return v
+}
+
+// Equal - compares two generic values, numbers are compared by their value not by representation
+func Equal(a, b interface{}) bool {
+
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, exists := y[k]
+			if !exists || !Equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if fa, ok := Number(a); ok {
+		fb, ok := Number(b)
+		return ok && fa == fb
+	}
+
+	return a == b
+}
+
+// Number - converts a numeric value into float64
+func Number(v interface{}) (float64, bool) {
+
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+
+	return 0, false
+}
+
+// Compare - compares two values of the same json type, returns false if values are not comparable.
+// Numbers, strings and booleans are ordered naturally, a missing value (nil) is lower than any other value.
+func Compare(a, b interface{}) (int, bool) {
+
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0, true
+		}
+		if a == nil {
+			return -1, true
+		}
+		return 1, true
+	}
+
+	if fa, ok := Number(a); ok {
+		fb, ok := Number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	if sa, ok := a.(string); ok {
+		sb, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(sa, sb), true
+	}
+
+	if ba, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case ba == bb:
+			return 0, true
+		case !ba:
+			return -1, true
+		}
+		return 1, true
+	}
+
+	return 0, false
+}
+
+// Normalize - converts a go value into a generic json value
+func Normalize(v interface{}) (interface{}, error) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(data)
+}
+
+// Field - returns a value of a field, nested fields are separated by a dot
+func Field(doc map[string]interface{}, path string) (interface{}, bool) {
+
+	var node interface{} = doc
+
+	for _, name := range strings.Split(path, ".") {
+
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if node, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return node, true
+}
+
+// SetField - sets a value of a field, missing parent objects are created
+func SetField(doc map[string]interface{}, path string, value interface{}) error {
+
+	names := strings.Split(path, ".")
+	obj := doc
+
+	for _, name := range names[:len(names)-1] {
+
+		node, exists := obj[name]
+		if !exists {
+			node = map[string]interface{}{}
+			obj[name] = node
+		}
+
+		next, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %w", path, errNotAnObject)
+		}
+		obj = next
+	}
+
+	obj[names[len(names)-1]] = value
+
+	return nil
+}
+
+// UnsetField - removes a field
+func UnsetField(doc map[string]interface{}, path string) {
+
+	names := strings.Split(path, ".")
+	obj := doc
+
+	for _, name := range names[:len(names)-1] {
+
+		next, ok := obj[name].(map[string]interface{})
+		if !ok {
+			return
+		}
+		obj = next
+	}
+
+	delete(obj, names[len(names)-1])
+}
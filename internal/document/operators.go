@@ -0,0 +1,243 @@
+package document
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Supported update operators
+const (
+	OpInc         = "$inc"
+	OpSet         = "$set"
+	OpSetIfAbsent = "$setIfAbsent"
+	OpUnset       = "$unset"
+	OpPush        = "$push"
+	OpPull        = "$pull"
+	OpAddToSet    = "$addToSet"
+	OpMin         = "$min"
+	OpMax         = "$max"
+
+	modEach = "$each"
+	idField = "_id"
+)
+
+var (
+	errNotANumber    = errors.New("value is not a number")
+	errNotAnArray    = errors.New("value is not an array")
+	errNotComparable = errors.New("values are not comparable")
+	errIDField       = errors.New("the id of a document can't be modified")
+	errOverflow      = errors.New("integer overflow")
+)
+
+// operators - all supported update operators in the order they are applied
+var operators = []string{OpSet, OpSetIfAbsent, OpUnset, OpInc, OpMin, OpMax, OpPush, OpAddToSet, OpPull}
+
+// Operators - update operators, each operator holds fields with operands e.g. {"$inc" : {"score" : 1}}
+type Operators map[string]map[string]interface{}
+
+// ApplyOperators - applies update operators to a document. Operators are applied in a fixed order
+// and fields of each operator are applied in alphabetical order.
+func ApplyOperators(data []byte, ops Operators) ([]byte, error) {
+
+	for op := range ops {
+		if !isOperator(op) {
+			return nil, fmt.Errorf("unknown operator: %s", op)
+		}
+	}
+
+	doc, err := DecodeObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range operators {
+
+		fields := make([]string, 0, len(ops[op]))
+		for f := range ops[op] {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+
+		for _, f := range fields {
+
+			if f == idField {
+				return nil, errIDField
+			}
+
+			operand, err := Normalize(ops[op][f])
+			if err != nil {
+				return nil, err
+			}
+
+			if err = applyOperator(doc, op, f, operand); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", op, f, err)
+			}
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+func isOperator(op string) bool {
+
+	for _, o := range operators {
+		if o == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+func applyOperator(doc map[string]interface{}, op, field string, operand interface{}) error {
+
+	current, exists := Field(doc, field)
+
+	switch op {
+	case OpSet:
+		return SetField(doc, field, operand)
+	case OpSetIfAbsent:
+		{
+			if exists {
+				return nil
+			}
+			return SetField(doc, field, operand)
+		}
+	case OpUnset:
+		{
+			UnsetField(doc, field)
+			return nil
+		}
+	case OpInc:
+		{
+			if !exists {
+				current = json.Number("0")
+			}
+
+			total, err := sum(current, operand)
+			if err != nil {
+				return err
+			}
+
+			return SetField(doc, field, total)
+		}
+	case OpMin, OpMax:
+		{
+			if !exists {
+				return SetField(doc, field, operand)
+			}
+
+			r, ok := Compare(operand, current)
+			if !ok {
+				return errNotComparable
+			}
+
+			if (op == OpMin && r < 0) || (op == OpMax && r > 0) {
+				return SetField(doc, field, operand)
+			}
+
+			return nil
+		}
+	}
+
+	arr := []interface{}{}
+	if exists {
+		var ok bool
+		if arr, ok = current.([]interface{}); !ok {
+			return errNotAnArray
+		}
+	}
+
+	switch op {
+	case OpPush:
+		arr = append(arr, each(operand)...)
+	case OpAddToSet:
+		for _, v := range each(operand) {
+			if !contains(arr, v) {
+				arr = append(arr, v)
+			}
+		}
+	case OpPull:
+		{
+			if !exists {
+				return nil
+			}
+
+			result := []interface{}{}
+			for _, v := range arr {
+				if !Equal(v, operand) {
+					result = append(result, v)
+				}
+			}
+			arr = result
+		}
+	}
+
+	return SetField(doc, field, arr)
+}
+
+// sum - adds two numbers, the result is an integer only if both numbers are integers, an overflow of integers is an error
+func sum(a, b interface{}) (interface{}, error) {
+
+	na, ok := a.(json.Number)
+	if !ok {
+		return nil, errNotANumber
+	}
+
+	nb, ok := b.(json.Number)
+	if !ok {
+		return nil, errNotANumber
+	}
+
+	if ia, err := na.Int64(); err == nil {
+		if ib, err := nb.Int64(); err == nil {
+			if (ib > 0 && ia > math.MaxInt64-ib) || (ib < 0 && ia < math.MinInt64-ib) {
+				return nil, errOverflow
+			}
+			return json.Number(strconv.FormatInt(ia+ib, 10)), nil
+		}
+	}
+
+	fa, err := na.Float64()
+	if err != nil {
+		return nil, errNotANumber
+	}
+
+	fb, err := nb.Float64()
+	if err != nil {
+		return nil, errNotANumber
+	}
+
+	if math.IsInf(fa+fb, 0) {
+		return nil, errNotANumber
+	}
+
+	return json.Number(strconv.FormatFloat(fa+fb, 'g', -1, 64)), nil
+}
+
+// each - returns elements of an operand in a form {"$each" : [...]} or the operand itself
+func each(operand interface{}) []interface{} {
+
+	if obj, ok := operand.(map[string]interface{}); ok && len(obj) == 1 {
+		if items, ok := obj[modEach].([]interface{}); ok {
+			return items
+		}
+	}
+
+	return []interface{}{operand}
+}
+
+func contains(arr []interface{}, v interface{}) bool {
+
+	for _, e := range arr {
+		if Equal(e, v) {
+			return true
+		}
+	}
+
+	return false
+}
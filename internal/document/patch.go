@@ -0,0 +1,344 @@
+package document
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	opAdd     = "add"
+	opRemove  = "remove"
+	opReplace = "replace"
+	opMove    = "move"
+	opCopy    = "copy"
+	opTest    = "test"
+)
+
+var (
+	// ErrTestFailed - returned when a test operation of a JSON Patch does not match
+	ErrTestFailed    = errors.New("patch test operation failed")
+	errInvalidPath   = errors.New("invalid json pointer")
+	errPathNotFound  = errors.New("path not found")
+	errInvalidIndex  = errors.New("invalid array index")
+	errMissingValue  = errors.New("missing value")
+	errMoveIntoChild = errors.New("cannot move a value into one of its children")
+)
+
+// Operation - a single operation of RFC 6902 JSON Patch
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MergePatch - applies RFC 7396 JSON Merge Patch to a document
+func MergePatch(doc, patch []byte) ([]byte, error) {
+
+	target, err := Decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := Decode(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+
+	pobj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	tobj, ok := target.(map[string]interface{})
+	if !ok {
+		tobj = map[string]interface{}{}
+	}
+
+	for k, v := range pobj {
+		if v == nil {
+			delete(tobj, k)
+			continue
+		}
+		tobj[k] = merge(tobj[k], v)
+	}
+
+	return tobj
+}
+
+// JSONPatch - applies RFC 6902 JSON Patch to a document, the patch is applied entirely or not at all
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+
+	ops := []Operation{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, err
+	}
+
+	target, err := Decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, op := range ops {
+		if target, err = applyOperation(target, op); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", n, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc interface{}, op Operation) (interface{}, error) {
+
+	path, err := ParsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case opAdd, opReplace, opTest:
+		{
+			if op.Value == nil {
+				return nil, errMissingValue
+			}
+
+			value, err := Decode(op.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			if op.Op == opAdd {
+				return add(doc, path, value)
+			}
+
+			//replacing the root replaces the whole document
+			if op.Op == opReplace && len(path) == 0 {
+				return value, nil
+			}
+
+			if op.Op == opReplace {
+				if doc, _, err = remove(doc, path); err != nil {
+					return nil, err
+				}
+				return add(doc, path, value)
+			}
+
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !Equal(current, value) {
+				return nil, ErrTestFailed
+			}
+
+			return doc, nil
+		}
+	case opRemove:
+		{
+			doc, _, err = remove(doc, path)
+			return doc, err
+		}
+	case opMove, opCopy:
+		{
+			from, err := ParsePointer(op.From)
+			if err != nil {
+				return nil, err
+			}
+
+			var value interface{}
+
+			if op.Op == opMove {
+				if strings.HasPrefix(op.Path, op.From+"/") {
+					return nil, errMoveIntoChild
+				}
+				if doc, value, err = remove(doc, from); err != nil {
+					return nil, err
+				}
+			} else {
+				if value, err = get(doc, from); err != nil {
+					return nil, err
+				}
+				value = Copy(value)
+			}
+
+			return add(doc, path, value)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown operation: %s", op.Op)
+}
+
+// ParsePointer - splits RFC 6901 JSON Pointer into unescaped reference tokens
+func ParsePointer(pointer string) ([]string, error) {
+
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if pointer[0] != '/' {
+		return nil, errInvalidPath
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+
+	for _, token := range path {
+
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			{
+				v, exists := node[token]
+				if !exists {
+					return nil, errPathNotFound
+				}
+				doc = v
+			}
+		case []interface{}:
+			{
+				i, err := index(token, len(node)-1)
+				if err != nil {
+					return nil, err
+				}
+				doc = node[i]
+			}
+		default:
+			return nil, errPathNotFound
+		}
+	}
+
+	return doc, nil
+}
+
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return walk(doc, path, func(parent interface{}, token string) (interface{}, error) {
+
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			{
+				node[token] = value
+				return node, nil
+			}
+		case []interface{}:
+			{
+				if token == "-" {
+					return append(node, value), nil
+				}
+
+				i, err := index(token, len(node))
+				if err != nil {
+					return nil, err
+				}
+
+				node = append(node, nil)
+				copy(node[i+1:], node[i:])
+				node[i] = value
+
+				return node, nil
+			}
+		}
+
+		return nil, errPathNotFound
+	})
+}
+
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+
+	if len(path) == 0 {
+		return nil, nil, errInvalidPath
+	}
+
+	var removed interface{}
+
+	doc, err := walk(doc, path, func(parent interface{}, token string) (interface{}, error) {
+
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			{
+				v, exists := node[token]
+				if !exists {
+					return nil, errPathNotFound
+				}
+				removed = v
+				delete(node, token)
+
+				return node, nil
+			}
+		case []interface{}:
+			{
+				i, err := index(token, len(node)-1)
+				if err != nil {
+					return nil, err
+				}
+				removed = node[i]
+
+				return append(node[:i], node[i+1:]...), nil
+			}
+		}
+
+		return nil, errPathNotFound
+	})
+
+	return doc, removed, err
+}
+
+// walk - follows the path and replaces the parent of the last token with a value returned by fn
+func walk(doc interface{}, path []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	if child, err = walk(child, path[1:], fn); err != nil {
+		return nil, err
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		i, _ := strconv.Atoi(path[0])
+		node[i] = child
+	}
+
+	return doc, nil
+}
+
+// index - parses an array index, the index can't be greater than max
+func index(token string, max int) (int, error) {
+
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, errInvalidIndex
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max {
+		return 0, errInvalidIndex
+	}
+
+	return i, nil
+}
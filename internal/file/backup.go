@@ -0,0 +1,474 @@
+package localstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestName - a name of the last entry of a backup that holds checksums of all other entries
+const manifestName = "MANIFEST.json"
+
+const backupVersion = 1
+
+var (
+	errNotEmpty      = errors.New("directory is not empty")
+	errInvalidBackup = errors.New("invalid backup")
+	errChecksum      = errors.New("backup checksum mismatch")
+)
+
+// manifest - describes files of a backup
+type manifest struct {
+	Version int                     `json:"version"`
+	Created time.Time               `json:"created"`
+	Files   map[string]manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupWriter - writes entries of a backup and collects their checksums
+type backupWriter struct {
+	ctx      context.Context
+	tw       *tar.Writer
+	created  time.Time
+	manifest manifest
+}
+
+// Backup - writes a consistent snapshot of all collections in the directory to w as a tar archive. Loaded collections
+// are captured at the same moment from memory, files of collections that are not loaded are opened at the same moment.
+// The manager is locked only while collections are captured, the archive is written without the lock.
+func (cm *jsonFileManager) Backup(ctx context.Context, w io.Writer) error {
+
+	sources, snaps, err := cm.backupSources()
+	if err != nil {
+		return err
+	}
+	defer release(snaps)
+	defer closeSources(sources)
+
+	now := time.Now().UTC()
+	bw := &backupWriter{ctx: ctx, tw: tar.NewWriter(w), created: now,
+		manifest: manifest{Version: backupVersion, Created: now, Files: map[string]manifestFile{}},
+	}
+
+	for _, src := range sources {
+		if err = bw.addSource(src); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(bw.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = bw.entry(manifestName, int64(len(data)), bytes.NewReader(data), false); err != nil {
+		return err
+	}
+
+	return bw.tw.Close()
+}
+
+// backupSource - content of an entry of a backup: a captured snapshot, data or an opened file
+type backupSource struct {
+	name string
+	sn   *snapshot
+	data []byte
+	file *os.File
+}
+
+// backupSources - captures entries of a backup under the manager lock. Loaded collections are captured with their
+// shards from memory, files of other collections are opened, so they can be read after the lock is released
+// because files are replaced by a rename. Captured snapshots must be released and sources must be closed
+func (cm *jsonFileManager) backupSources() ([]backupSource, map[string]snapshot, error) {
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	names, err := cm.collections()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snaps, logs, err := cm.capture(true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name := range snaps {
+		if !fileExists(filepath.Join(cm.path, name+".json")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	sources := []backupSource{}
+	open := func(name, path string) error {
+
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		sources = append(sources, backupSource{name: name, file: f})
+
+		return nil
+	}
+
+	fail := func(err error) ([]backupSource, map[string]snapshot, error) {
+		closeSources(sources)
+		release(snaps)
+		return nil, nil, err
+	}
+
+	for _, name := range names {
+
+		path := filepath.Join(cm.path, name+".json")
+
+		if sn, loaded := snaps[name]; loaded {
+
+			sn := sn
+			sources = append(sources, backupSource{name: name + ".json", sn: &sn})
+
+			for _, i := range sortedIndexes(sn.parts) {
+				part := sn.parts[i]
+				sources = append(sources, backupSource{name: filepath.Base(shardPath(path, i, sn.shards[i])), sn: &part})
+			}
+
+			if len(logs[name]) != 0 {
+				sources = append(sources, backupSource{name: name + logSuffix, data: logs[name]})
+			}
+
+		} else {
+
+			if err = open(name+".json", path); err != nil {
+				return fail(err)
+			}
+
+			if err = open(name+logSuffix, filepath.Join(cm.path, name+logSuffix)); err != nil {
+				return fail(err)
+			}
+
+			shards, err := committedShards(path, cm.keys)
+			if err != nil {
+				return fail(err)
+			}
+
+			for _, i := range sortedIndexes(shards) {
+				if err = open(filepath.Base(shards[i]), shards[i]); err != nil {
+					return fail(err)
+				}
+			}
+		}
+
+		if err = open(name+schemaSuffix, filepath.Join(cm.path, name+schemaSuffix)); err != nil {
+			return fail(err)
+		}
+	}
+
+	cm.catalogLock.Lock()
+	err = cm.loadCatalog()
+	catalog, _ := json.MarshalIndent(cm.catalog, "", "  ")
+	cm.catalogLock.Unlock()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	sources = append(sources, backupSource{name: catalogName, data: catalog})
+
+	return sources, snaps, nil
+}
+
+// closeSources - closes files opened for a backup
+func closeSources(sources []backupSource) {
+	for _, src := range sources {
+		if src.file != nil {
+			src.file.Close()
+		}
+	}
+}
+
+// sortedIndexes - returns indexes of shards in order
+func sortedIndexes[T any](m map[int]T) []int {
+
+	indexes := make([]int, 0, len(m))
+	for i := range m {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	return indexes
+}
+
+// capture - captures a state of all loaded collections at the same moment and their logs if withLogs is set, must be called
+// under the manager lock. Collections are locked in the same order as by transactions, flushes are stopped, so every change
+// is either in a captured log or in pending changes that are added to it. Captured snapshots must be released
+func (cm *jsonFileManager) capture(withLogs bool) (map[string]snapshot, map[string][]byte, error) {
+
+	names := make([]string, 0, len(cm.m))
+	for name := range cm.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cm.m[name].flushLock.Lock()
+	}
+	defer func() {
+		for i := len(names) - 1; i >= 0; i-- {
+			cm.m[names[i]].flushLock.Unlock()
+		}
+	}()
+
+	snaps := map[string]snapshot{}
+	logs := map[string][]byte{}
+	pending := map[string][]Change{}
+
+	for _, name := range names {
+		cm.m[name].lock.RLock()
+	}
+
+	var err error
+	for _, name := range names {
+
+		s := cm.m[name]
+		snaps[name] = s.copySnapshot()
+		pending[name] = append([]Change{}, s.pending...)
+
+		if !withLogs {
+			continue
+		}
+
+		if logs[name], err = ioutil.ReadFile(s.logPath()); err != nil && !os.IsNotExist(err) {
+			break
+		}
+		err = nil
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		cm.m[names[i]].lock.RUnlock()
+	}
+
+	if err != nil {
+		release(snaps)
+		return nil, nil, err
+	}
+
+	if !withLogs {
+		return snaps, logs, nil
+	}
+
+	for _, name := range names {
+
+		data, err := cm.m[name].marshalChanges(pending[name])
+		if err != nil {
+			release(snaps)
+			return nil, nil, err
+		}
+
+		logs[name] = append(logs[name], data...)
+	}
+
+	return snaps, logs, nil
+}
+
+// release - releases captured snapshots
+func release(snaps map[string]snapshot) {
+	for _, sn := range snaps {
+		sn.release()
+	}
+}
+
+// add - adds an entry with given content
+func (bw *backupWriter) add(name string, data []byte) error {
+	return bw.entry(name, int64(len(data)), bytes.NewReader(data), true)
+}
+
+// addSource - adds an entry with content of a source, a snapshot is encoded first to know the size of the entry
+func (bw *backupWriter) addSource(src backupSource) error {
+
+	if src.sn != nil {
+
+		buf := bytes.Buffer{}
+		if err := src.sn.encode(&buf); err != nil {
+			return err
+		}
+
+		return bw.add(src.name, buf.Bytes())
+	}
+
+	if src.file == nil {
+		return bw.add(src.name, src.data)
+	}
+
+	st, err := src.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return bw.entry(src.name, st.Size(), src.file, true)
+}
+
+// entry - writes an entry of a backup, with sum the checksum of the entry is recorded in the manifest
+func (bw *backupWriter) entry(name string, size int64, r io.Reader, sum bool) error {
+
+	if err := bw.ctx.Err(); err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size, ModTime: bw.created}
+	if err := bw.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(bw.tw, h), r, size); err != nil {
+		return err
+	}
+
+	if sum {
+		bw.manifest.Files[name] = manifestFile{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+
+	return nil
+}
+
+// Restore - installs collections from a backup written by Backup, the directory must be empty. Entries are
+// extracted to a temporary directory and installed only if all of them match checksums in the manifest
+func (cm *jsonFileManager) Restore(ctx context.Context, r io.Reader) error {
+
+	if err := cm.writable(); err != nil {
+		return err
+	}
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	entries, err := ioutil.ReadDir(cm.path)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) != 0 || len(cm.m) != 0 {
+		return errNotEmpty
+	}
+
+	staging, err := ioutil.TempDir(cm.path, ".restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	files, m, err := extract(ctx, r, staging)
+	if err != nil {
+		return err
+	}
+
+	if m == nil || m.Version != backupVersion {
+		return fmt.Errorf("%w: missing manifest", errInvalidBackup)
+	}
+
+	if len(files) != len(m.Files) {
+		return fmt.Errorf("%w: %d files, %d expected", errChecksum, len(files), len(m.Files))
+	}
+
+	for name, f := range m.Files {
+		if got, exists := files[name]; !exists || got != f {
+			return fmt.Errorf("%w: %s", errChecksum, name)
+		}
+	}
+
+	for name := range files {
+		if err = os.Rename(filepath.Join(staging, name), filepath.Join(cm.path, name)); err != nil {
+			return err
+		}
+	}
+
+	syncDir(cm.path)
+
+	cm.catalogLock.Lock()
+	cm.catalog = nil
+	cm.catalogLock.Unlock()
+
+	return nil
+}
+
+// extract - extracts entries of a backup to a directory, returns checksums of extracted entries and the manifest
+func extract(ctx context.Context, r io.Reader, dir string) (map[string]manifestFile, *manifest, error) {
+
+	files := map[string]manifestFile{}
+	var m *manifest
+
+	tr := tar.NewReader(r)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", errInvalidBackup, err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg || !validEntry(hdr.Name) {
+			return nil, nil, fmt.Errorf("%w: unexpected entry %s", errInvalidBackup, hdr.Name)
+		}
+
+		if hdr.Name == manifestName {
+			m = &manifest{}
+			if err = json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, nil, fmt.Errorf("%w: %s", errInvalidBackup, err)
+			}
+			continue
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, hdr.Name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(f, h), tr)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		files[hdr.Name] = manifestFile{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+
+	return files, m, nil
+}
+
+// validEntry - checks if a name of an entry is a plain name of a file
+func validEntry(name string) bool {
+	return name != "" && filepath.Base(name) == name && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
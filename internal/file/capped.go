@@ -0,0 +1,155 @@
+package localstore
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	errInvalidCap = errors.New("invalid capacity of a collection")
+)
+
+// entry - a position of an item in the order of insertion
+type entry struct {
+	key string
+	seq uint64
+}
+
+// Entry - an item with its insertion sequence number
+type Entry struct {
+	Seq  uint64
+	Key  string
+	Item json.RawMessage
+	// Err - set if the body of a paged item can't be read, Item is nil then
+	Err error
+}
+
+// SetCap - limits a collection by a number of items and/or total size of items in bytes, zero means no limit.
+// When the limit is exceeded, the oldest inserted items are removed. The most recent item is never removed.
+func (s *JsonFileData) SetCap(maxDocs int, maxBytes int64) error {
+
+	if maxDocs < 0 || maxBytes < 0 {
+		return errInvalidCap
+	}
+
+	s.lock.Lock()
+
+	if s.shards != nil && (maxDocs > 0 || maxBytes > 0) {
+		s.lock.Unlock()
+		return errShardedCap
+	}
+
+	s.maxDocs = maxDocs
+	s.maxBytes = maxBytes
+	num := s.items.len()
+	s.evict("")
+	num -= s.items.len()
+
+	s.lock.Unlock()
+
+	if num != 0 && s.updatesync.Load() {
+		s.Sync()
+	}
+
+	return s.alter(func(e *CatalogEntry) { e.MaxDocs, e.MaxBytes = maxDocs, maxBytes })
+}
+
+// Cap - returns limits of a collection
+func (s *JsonFileData) Cap() (int, int64) {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.maxDocs, s.maxBytes
+}
+
+// After - returns items inserted after an item with a given sequence number, in the order of insertion.
+// The returned channel is closed when a new item is inserted.
+func (s *JsonFileData) After(seq uint64) ([]Entry, <-chan struct{}) {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	result := []Entry{}
+	now := time.Now()
+
+	pos := sort.Search(len(s.queue), func(i int) bool { return s.queue[i].seq > seq })
+	for _, e := range s.queue[pos:] {
+		if s.valid(e) && !s.isExpired(e.key, now) {
+			item, _, err := s.items.get(e.key)
+			result = append(result, Entry{Seq: e.seq, Key: e.key, Item: item, Err: err})
+		}
+	}
+
+	return result, s.notify
+}
+
+// evict - removes the oldest items until the collection fits in its limits, an item with the key keep is not removed
+// so an item that grew by an update stays in the collection. Must be called under the lock
+func (s *JsonFileData) evict(keep string) {
+
+	kept := []entry{}
+	for len(s.queue) > 0 && s.items.len() > 1 && ((s.maxDocs > 0 && s.items.len() > s.maxDocs) || (s.maxBytes > 0 && s.size > s.maxBytes)) {
+
+		e := s.queue[0]
+		s.queue = s.queue[1:]
+
+		if !s.valid(e) {
+			continue
+		}
+
+		if e.key == keep {
+			kept = append(kept, e)
+			continue
+		}
+
+		s.remove(e.key)
+	}
+
+	if len(kept) != 0 {
+		s.queue = append(kept, s.queue...)
+	}
+
+	s.compact()
+}
+
+// compact - drops entries of removed items from the queue when they take more than a half of it
+func (s *JsonFileData) compact() {
+
+	if len(s.queue) < 1024 || len(s.queue) < 2*s.items.len() {
+		return
+	}
+
+	queue := make([]entry, 0, s.items.len())
+	for _, e := range s.queue {
+		if s.valid(e) {
+			queue = append(queue, e)
+		}
+	}
+
+	s.queue = queue
+}
+
+// valid - checks if an entry of the queue points to an existing item
+func (s *JsonFileData) valid(e entry) bool {
+
+	seq, exists := s.seqs[e.key]
+
+	return exists && seq == e.seq
+}
+
+// ordered - returns keys in the order of insertion, must be called under the lock
+func (s *JsonFileData) ordered() []string {
+
+	keys := make([]string, 0, s.items.len())
+
+	for _, e := range s.queue {
+		if s.valid(e) {
+			keys = append(keys, e.key)
+		}
+	}
+
+	return keys
+}
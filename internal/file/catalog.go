@@ -0,0 +1,502 @@
+package localstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// catalogName - a name of a file that holds settings of all collections in a directory
+const catalogName = "_catalog.json"
+
+// Duration - a time.Duration stored in the catalog in a readable form e.g. "1h30m0s"
+type Duration time.Duration
+
+// MarshalJSON - implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON - implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+// CatalogEntry - settings of a collection recorded in the catalog. SyncTime and UpdateSync are set only if
+// the collection overrides settings of a store. Schema holds a name of a file with the schema.
+type CatalogEntry struct {
+	SyncTime    *int        `json:"synctime,omitempty"`
+	UpdateSync  *bool       `json:"updatesync,omitempty"`
+	Indexes     []string    `json:"indexes,omitempty"`
+	Schema      string      `json:"schema,omitempty"`
+	TTL         Duration    `json:"ttl,omitempty"`
+	MaxDocs     int         `json:"maxdocs,omitempty"`
+	MaxBytes    int64       `json:"maxbytes,omitempty"`
+	Format      string      `json:"format"`
+	Compression string      `json:"compression,omitempty"`
+	Fields      []FieldSpec `json:"fields,omitempty"`
+	History     bool        `json:"history,omitempty"`
+	Paged       bool        `json:"paged,omitempty"`
+	Shards      *Sharding   `json:"shards,omitempty"`
+	Created     time.Time   `json:"created"`
+}
+
+// Options - settings of a collection used when a collection is created or altered, nil fields are left unchanged.
+// Indexes and Fields replace all indexes and encrypted fields of a collection and an empty Schema removes the schema. A changed Format or Compression converts the collection file.
+// A zero Shards stores a sharded collection in a single file again. Fields are not applied by the manager, they are declared
+// by the collection layer that rewrites stored documents.
+type Options struct {
+	SyncTime    *int
+	UpdateSync  *bool
+	Indexes     []string
+	Schema      []byte
+	TTL         *time.Duration
+	MaxDocs     *int
+	MaxBytes    *int64
+	Format      *string
+	Compression *string
+	Fields      []FieldSpec
+	History     *bool
+	Paged       *bool
+	Shards      *Sharding
+}
+
+func (e *CatalogEntry) syncTime(tm int) int {
+
+	if e.SyncTime != nil {
+		return *e.SyncTime
+	}
+
+	return tm
+}
+
+func (e *CatalogEntry) compression() string {
+
+	if e.Compression == "" {
+		return CompressionNone
+	}
+
+	return e.Compression
+}
+
+func (e *CatalogEntry) updateSync(updatesync bool) bool {
+
+	if e.UpdateSync != nil {
+		return *e.UpdateSync
+	}
+
+	return updatesync
+}
+
+// Create - creates a new collection with given settings, settings that are not given are taken from a store
+func (cm *jsonFileManager) Create(name string, tm int, updatesync bool, opts Options) (*JsonFileData, error) {
+
+	if err := cm.writable(); err != nil {
+		return nil, err
+	}
+
+	if _, err := cm.getFileData(name, tm, updatesync, false); err != errCollectionNotExists {
+		return nil, errCollectionExists
+	}
+
+	s, err := cm.createFileData(name, tm, updatesync, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.configure(opts); err != nil {
+		cm.Discard(name)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Discard - removes a collection that was just created but could not be configured from the manager and the catalog,
+// files written while it was configured are removed
+func (cm *jsonFileManager) Discard(name string) error {
+
+	cm.lock.Lock()
+	s, exists := cm.m[name]
+	if exists {
+		delete(cm.m, name)
+		close(s.done)
+		s.items.close()
+	}
+	cm.lock.Unlock()
+
+	if !exists {
+		return errCollectionNotExists
+	}
+
+	if shards, err := allShardFiles(s.path); err == nil {
+		for _, f := range shards {
+			removeShard(f)
+		}
+	}
+
+	for _, f := range []string{s.path, s.path + sumSuffix, s.logPath(), s.schemaPath()} {
+		os.Remove(f)
+	}
+
+	defer cm.catalogLock.Unlock()
+	cm.catalogLock.Lock()
+
+	if err := cm.loadCatalog(); err != nil {
+		return err
+	}
+
+	delete(cm.catalog, name)
+
+	if !fileExists(filepath.Join(cm.path, catalogName)) {
+		return nil
+	}
+
+	return cm.saveCatalog()
+}
+
+// Alter - changes settings of an existing collection, the collection is loaded if necessary
+func (cm *jsonFileManager) Alter(name string, tm int, updatesync bool, opts Options) error {
+
+	if err := cm.writable(); err != nil {
+		return err
+	}
+
+	s, err := cm.GetData(name, tm, updatesync)
+	if err != nil {
+		return err
+	}
+
+	return s.configure(opts)
+}
+
+// Catalog - returns settings of a collection recorded in the catalog
+func (cm *jsonFileManager) Catalog(name string) (CatalogEntry, bool) {
+
+	defer cm.catalogLock.Unlock()
+	cm.catalogLock.Lock()
+
+	if err := cm.loadCatalog(); err != nil {
+		return CatalogEntry{}, false
+	}
+
+	e, exists := cm.catalog[name]
+	if !exists {
+		return CatalogEntry{}, false
+	}
+
+	return *e, true
+}
+
+// entry - returns a catalog entry of an existing collection, a collection created before the catalog
+// is recorded with default settings when it is synced. A shared reader reads the catalog again, because it is written by a writer
+func (cm *jsonFileManager) entry(name, fpath string) (*CatalogEntry, error) {
+
+	defer cm.catalogLock.Unlock()
+	cm.catalogLock.Lock()
+
+	if cm.readOnly {
+		cm.catalog = nil
+	}
+
+	if err := cm.loadCatalog(); err != nil {
+		return nil, err
+	}
+
+	if e, exists := cm.catalog[name]; exists {
+		return e, nil
+	}
+
+	e := &CatalogEntry{Format: FormatJSON, Created: time.Now().UTC()}
+	if st, err := os.Stat(fpath); err == nil {
+		e.Created = st.ModTime().UTC()
+	}
+
+	if spath := filepath.Join(cm.path, name+schemaSuffix); fileExists(spath) {
+		e.Schema = filepath.Base(spath)
+	}
+
+	cm.catalog[name] = e
+	cm.catalogDirty = true
+
+	return e, nil
+}
+
+// putEntry - records settings of a new collection, the catalog is written when the collection is synced
+func (cm *jsonFileManager) putEntry(name string, e *CatalogEntry) error {
+
+	defer cm.catalogLock.Unlock()
+	cm.catalogLock.Lock()
+
+	if err := cm.loadCatalog(); err != nil {
+		return err
+	}
+
+	cm.catalog[name] = e
+	cm.catalogDirty = true
+
+	return nil
+}
+
+// alterer - returns a function that changes a catalog entry of a collection. The catalog is written immediately
+// if the collection file exists, otherwise when the collection is synced
+func (cm *jsonFileManager) alterer(name string) func(fn func(e *CatalogEntry)) error {
+
+	return func(fn func(e *CatalogEntry)) error {
+
+		defer cm.catalogLock.Unlock()
+		cm.catalogLock.Lock()
+
+		if err := cm.loadCatalog(); err != nil {
+			return err
+		}
+
+		e, exists := cm.catalog[name]
+		if !exists {
+			e = &CatalogEntry{Format: FormatJSON, Created: time.Now().UTC()}
+			cm.catalog[name] = e
+		}
+
+		fn(e)
+		cm.catalogDirty = true
+
+		if !fileExists(filepath.Join(cm.path, name+".json")) {
+			return nil
+		}
+
+		return cm.saveCatalog()
+	}
+}
+
+// syncCatalog - writes the catalog if it has changed since it was written
+func (cm *jsonFileManager) syncCatalog() error {
+
+	defer cm.catalogLock.Unlock()
+	cm.catalogLock.Lock()
+
+	if cm.catalogDirty {
+		return cm.saveCatalog()
+	}
+
+	return nil
+}
+
+// loadCatalog - reads the catalog if it is not read yet, must be called under the catalog lock
+func (cm *jsonFileManager) loadCatalog() error {
+
+	if cm.catalog != nil {
+		return nil
+	}
+
+	catalog := map[string]*CatalogEntry{}
+
+	data, err := ioutil.ReadFile(filepath.Join(cm.path, catalogName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		if err = json.Unmarshal(data, &catalog); err != nil {
+			return err
+		}
+	}
+
+	cm.catalog = catalog
+
+	return nil
+}
+
+// saveCatalog - writes the catalog, must be called under the catalog lock
+func (cm *jsonFileManager) saveCatalog() error {
+
+	data, err := json.MarshalIndent(cm.catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = writeFile(filepath.Join(cm.path, catalogName), data); err != nil {
+		return err
+	}
+
+	cm.catalogDirty = false
+
+	return nil
+}
+
+// SyncPolicy - returns a sync interval in seconds and whether a collection is synced after every change
+func (s *JsonFileData) SyncPolicy() (int, bool) {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.synctime, s.updatesync.Load()
+}
+
+// SetSyncPolicy - changes a sync interval and/or whether a collection is synced after every change, nil values are left unchanged
+func (s *JsonFileData) SetSyncPolicy(tm *int, updatesync *bool) error {
+
+	s.lock.Lock()
+	if tm != nil {
+		s.synctime = *tm
+	}
+	if updatesync != nil {
+		s.updatesync.Store(*updatesync)
+	}
+	s.lock.Unlock()
+
+	select {
+	case s.resync <- struct{}{}:
+	default:
+	}
+
+	return s.alter(func(e *CatalogEntry) {
+		if tm != nil {
+			e.SyncTime = tm
+		}
+		if updatesync != nil {
+			e.UpdateSync = updatesync
+		}
+	})
+}
+
+// configure - applies settings to a collection
+func (s *JsonFileData) configure(opts Options) error {
+
+	if opts.SyncTime != nil || opts.UpdateSync != nil {
+		if err := s.SetSyncPolicy(opts.SyncTime, opts.UpdateSync); err != nil {
+			return err
+		}
+	}
+
+	if opts.Indexes != nil {
+
+		wanted := map[string]bool{}
+		for _, f := range opts.Indexes {
+			wanted[f] = true
+		}
+
+		for _, f := range s.Indexes() {
+			if wanted[f] {
+				delete(wanted, f)
+				continue
+			}
+			if err := s.DropIndex(f); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range opts.Indexes {
+			if wanted[f] {
+				if err := s.CreateIndex(f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if opts.Schema != nil {
+		if err := s.SetSchema(opts.Schema); err != nil {
+			return err
+		}
+	}
+
+	if opts.TTL != nil {
+		if err := s.SetTTL(*opts.TTL); err != nil {
+			return err
+		}
+	}
+
+	if opts.Format != nil {
+		if err := s.SetFormat(*opts.Format); err != nil {
+			return err
+		}
+	}
+
+	if opts.History != nil {
+		if err := s.SetHistory(*opts.History); err != nil {
+			return err
+		}
+	}
+
+	if opts.Paged != nil {
+		if err := s.SetPaged(*opts.Paged); err != nil {
+			return err
+		}
+	}
+
+	if opts.Compression != nil {
+		if err := s.SetCompression(*opts.Compression); err != nil {
+			return err
+		}
+	}
+
+	if opts.Shards != nil {
+		if err := s.SetShards(*opts.Shards); err != nil {
+			return err
+		}
+	}
+
+	if opts.MaxDocs != nil || opts.MaxBytes != nil {
+
+		maxDocs, maxBytes := s.Cap()
+		if opts.MaxDocs != nil {
+			maxDocs = *opts.MaxDocs
+		}
+		if opts.MaxBytes != nil {
+			maxBytes = *opts.MaxBytes
+		}
+
+		if err := s.SetCap(maxDocs, maxBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restore - applies settings recorded in the catalog to a loaded collection, sync settings are applied when it is initialized
+func (s *JsonFileData) restore(e *CatalogEntry) {
+
+	for _, f := range e.Indexes {
+		s.CreateIndex(f)
+	}
+
+	s.SetTTL(time.Duration(e.TTL))
+	s.fields = append([]FieldSpec{}, e.Fields...)
+	s.history = e.History
+	s.SetCap(e.MaxDocs, e.MaxBytes)
+}
+
+// alter - records a change of settings in the catalog, settings of collections that don't belong to a manager are not recorded
+func (s *JsonFileData) alter(fn func(e *CatalogEntry)) error {
+
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	if s.onAlter == nil {
+		return nil
+	}
+
+	return s.onAlter(fn)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
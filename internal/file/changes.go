@@ -0,0 +1,419 @@
+package localstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of changes
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// logSuffix - a suffix of a file with a log of changes of a collection
+const logSuffix = ".changes"
+
+var (
+	errChangesExpired = errors.New("changes are no longer available")
+)
+
+var (
+	// ringSize - a number of recent changes kept in memory
+	ringSize = 1024
+	// logSize - a number of changes kept in a log, older changes are dropped when the log grows twice as big
+	logSize = 65536
+	// pendingSize - a number of changes kept in memory until a collection is synced, when there are more
+	// they are appended to the log without waiting for the sync
+	pendingSize = 1024
+)
+
+// Change - a single mutation of a collection. The sequence number of the latest change of an item serves as its revision.
+// Item is the current content of an item attached when changes are read, Data is the content written by the change,
+// it is kept in the log only if a collection keeps history
+type Change struct {
+	Seq  uint64          `json:"seq"`
+	Op   string          `json:"op"`
+	Key  string          `json:"key"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data,omitempty"`
+	Item json.RawMessage `json:"-"`
+}
+
+// LastChange - returns the sequence number of the latest change
+func (s *JsonFileData) LastChange() uint64 {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.change
+}
+
+// Done - returns a channel that is closed when the collection is closed
+func (s *JsonFileData) Done() <-chan struct{} {
+	return s.done
+}
+
+// ChangesAfter - returns changes with a sequence number greater than since, in order. If a change is the latest change
+// of an existing item, the item is attached to it. The returned channel is closed when a new change is recorded.
+func (s *JsonFileData) ChangesAfter(since uint64) ([]Change, <-chan struct{}, error) {
+
+	for {
+		s.lock.RLock()
+		first := s.change + 1
+		if len(s.ring) != 0 {
+			first = s.ring[0].Seq
+		}
+		s.lock.RUnlock()
+
+		result := []Change{}
+		if since+1 < first {
+
+			changes, err := s.readLog()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(changes) == 0 || changes[0].Seq > since+1 {
+				return nil, nil, errChangesExpired
+			}
+
+			for _, c := range changes {
+				if c.Seq > since && c.Seq < first {
+					result = append(result, c)
+				}
+			}
+		}
+
+		last := since
+		if len(result) != 0 {
+			last = result[len(result)-1].Seq
+		}
+
+		s.lock.RLock()
+
+		//changes kept in memory are merged by their sequence numbers, pending changes may be older than the ring
+		//and the ring holds pending changes too, a gap means that changes were moved to the log in the meantime
+		complete := true
+		for _, c := range s.recent(last) {
+			if c.Seq != last+1 {
+				complete = false
+				break
+			}
+			result = append(result, c)
+			last = c.Seq
+		}
+
+		if !complete {
+			s.lock.RUnlock()
+			continue
+		}
+
+		for i, c := range result {
+			if c.Op == ChangeDelete || s.revs[c.Key] != c.Seq {
+				continue
+			}
+
+			item, ok, err := s.items.get(c.Key)
+			if err != nil {
+				s.lock.RUnlock()
+				return nil, nil, err
+			}
+			if ok {
+				result[i].Item = item
+			}
+		}
+
+		notify := s.notify
+		s.lock.RUnlock()
+
+		return result, notify, nil
+	}
+}
+
+// record - records a change of an item and notifies waiting readers, must be called under the lock.
+// Changes made while a collection is loaded are not recorded. When too many changes are pending,
+// the collection is asked to append them to the log, so they don't grow until the next sync
+func (s *JsonFileData) record(op, key string, item json.RawMessage) {
+
+	if s.loading {
+		return
+	}
+
+	s.change++
+	c := Change{Seq: s.change, Op: op, Key: key, Time: time.Now().UTC()}
+	if s.history {
+		c.Data = item
+	}
+
+	if op == ChangeDelete {
+		delete(s.revs, key)
+	} else {
+		s.revs[key] = c.Seq
+	}
+
+	s.pending = append(s.pending, c)
+	if len(s.pending) >= pendingSize {
+		select {
+		case s.spill <- struct{}{}:
+		default:
+		}
+	}
+
+	s.ring = append(s.ring, c)
+	if len(s.ring) >= 2*ringSize {
+		s.ring = append([]Change{}, s.ring[ringSize:]...)
+	}
+
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// recent - returns changes kept in memory with a sequence number greater than since, ordered by sequence numbers,
+// must be called under the lock
+func (s *JsonFileData) recent(since uint64) []Change {
+
+	changes := []Change{}
+	seen := map[uint64]bool{}
+	for _, source := range [][]Change{s.pending, s.ring} {
+		for _, c := range source {
+			if c.Seq > since && !seen[c.Seq] {
+				seen[c.Seq] = true
+				changes = append(changes, c)
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+
+	return changes
+}
+
+// logPath - returns a path of a log of changes
+func (s *JsonFileData) logPath() string {
+	return strings.TrimSuffix(s.path, ".json") + logSuffix
+}
+
+// readLog - reads changes from a log, reading stops at a damaged line that is left by an interrupted write
+func (s *JsonFileData) readLog() ([]Change, error) {
+	changes, _, err := s.scanLog()
+	return changes, err
+}
+
+// scanLog - reads changes from a log and reports if the log is damaged
+func (s *JsonFileData) scanLog() ([]Change, bool, error) {
+
+	f, err := os.Open(s.logPath())
+	if os.IsNotExist(err) {
+		return []Change{}, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	keys := s.currentKeys()
+	changes := []Change{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+
+		line, valid := checkLine(scanner.Bytes())
+		if !valid {
+			return changes, true, nil
+		}
+
+		if len(line) != 0 && line[0] != '{' {
+
+			sealed, err := base64.StdEncoding.DecodeString(string(line))
+			if err != nil {
+				return changes, true, nil
+			}
+
+			if line, err = openData(keys, sealed); errors.Is(err, errTruncated) {
+				return changes, true, nil
+			}
+
+			if err != nil {
+				return nil, false, err
+			}
+		}
+
+		c := Change{}
+		if err := json.Unmarshal(line, &c); err != nil {
+			return changes, true, nil
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, false, scanner.Err()
+}
+
+// marshalChanges - writes changes as lines of a log followed by a checksum, with keys every line is encrypted and encoded with base64
+func (s *JsonFileData) marshalChanges(changes []Change) ([]byte, error) {
+
+	keys := s.currentKeys()
+	buf := bytes.Buffer{}
+
+	for _, c := range changes {
+
+		line, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if keys != nil {
+			sealed, err := sealData(keys, line)
+			if err != nil {
+				return nil, err
+			}
+			line = []byte(base64.StdEncoding.EncodeToString(sealed))
+		}
+
+		buf.Write(sumLine(line))
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// currentKeys - returns keys used to encrypt the collection
+func (s *JsonFileData) currentKeys() KeyProvider {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.keys
+}
+
+// loadLog - restores the sequence number, revisions of items and recent changes from a log,
+// a damaged log is rewritten so new changes are not appended after a damaged line. A shared reader never rewrites a log,
+// a damaged line is left by a writer that is appending to it
+func (s *JsonFileData) loadLog() error {
+
+	changes, damaged, err := s.scanLog()
+	if err != nil {
+		return err
+	}
+
+	if damaged && !s.readOnly {
+		if err = s.truncateLog(); err != nil {
+			return err
+		}
+	}
+
+	defer s.lock.Unlock()
+	s.lock.Lock()
+
+	s.logged = len(changes)
+
+	for _, c := range changes {
+
+		s.change = c.Seq
+		if c.Op == ChangeDelete {
+			delete(s.revs, c.Key)
+			continue
+		}
+		s.revs[c.Key] = c.Seq
+	}
+
+	if len(changes) > ringSize {
+		changes = changes[len(changes)-ringSize:]
+	}
+
+	s.ring = append([]Change{}, changes...)
+	s.synced = s.change
+
+	return nil
+}
+
+// flushLog - appends pending changes to the log without writing the collection file
+func (s *JsonFileData) flushLog() error {
+
+	defer s.flushLock.Unlock()
+	s.flushLock.Lock()
+
+	s.lock.Lock()
+	pending := s.pending
+	s.pending = []Change{}
+	s.lock.Unlock()
+
+	err := s.appendLog(pending)
+	if err != nil {
+		s.lock.Lock()
+		s.pending = append(pending, s.pending...)
+		s.lock.Unlock()
+	}
+
+	return err
+}
+
+// appendLog - appends changes to a log, when the log grows too big it is rewritten with the most recent changes
+func (s *JsonFileData) appendLog(changes []Change) error {
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	data, err := s.marshalChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.logPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.logged += len(changes)
+	if s.logged < 2*logSize {
+		return nil
+	}
+
+	return s.truncateLog()
+}
+
+// truncateLog - rewrites a log with the most recent changes
+func (s *JsonFileData) truncateLog() error {
+
+	changes, err := s.readLog()
+	if err != nil {
+		return err
+	}
+
+	if len(changes) > logSize {
+		changes = changes[len(changes)-logSize:]
+	}
+
+	data, err := s.marshalChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	if err = writeFile(s.logPath(), data); err != nil {
+		return err
+	}
+
+	s.logged = len(changes)
+
+	return nil
+}
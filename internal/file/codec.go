@@ -0,0 +1,571 @@
+package localstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/przebro/localstore/internal/document"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Formats of collection files. A collection file is named <name>.json in every format, the same as a compressed
+// or an encrypted file, so a name of the file doesn't change when a collection is converted and the format
+// is recognized by the content of the file
+const (
+	// FormatJSON - a single json object
+	FormatJSON = "json"
+	// FormatJSONL - a header line followed by a line with a key and an item for every item
+	FormatJSONL = "jsonl"
+	// FormatCBOR - a self-described CBOR header followed by a sequence of CBOR arrays with a key and an item
+	FormatCBOR = "cbor"
+	// FormatMsgpack - a MessagePack header followed by a sequence of MessagePack arrays with a key and an item
+	FormatMsgpack = "msgpack"
+)
+
+var (
+	errUnknownFormat = errors.New("unknown format of a collection")
+)
+
+// cborMagic - the self-described CBOR tag that starts a CBOR collection file
+var cborMagic = []byte{0xd9, 0xd9, 0xf7}
+
+const (
+	// cborDecimalTag - the CBOR tag of a decimal fraction
+	cborDecimalTag = 4
+	// msgpackDecimalExt - the MessagePack extension type of a decimal number
+	msgpackDecimalExt int8 = 1
+)
+
+func init() {
+	msgpack.RegisterExt(msgpackDecimalExt, (*decimal)(nil))
+}
+
+// Codec - encodes items of a collection into a collection file and decodes them back
+type Codec interface {
+	Format() string
+	// Encode - writes items in a given order, item returns an item with a given position. Shards are generations
+	// of shard files recorded in the header
+	Encode(w io.Writer, shards []uint64, keys []string, item func(i int) (json.RawMessage, error)) error
+	// Decode - passes items to fn in the order they are stored, returns a header of the file
+	Decode(r io.Reader, fn func(key string, item json.RawMessage)) (header, error)
+}
+
+var codecs = map[string]Codec{
+	FormatJSON:    jsonCodec{},
+	FormatJSONL:   jsonlCodec{},
+	FormatCBOR:    cborCodec{},
+	FormatMsgpack: msgpackCodec{},
+}
+
+// GetCodec - returns a codec of a given format
+func GetCodec(format string) (Codec, error) {
+
+	c, exists := codecs[format]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+
+	return c, nil
+}
+
+// Format - returns a format of a collection file
+func (s *JsonFileData) Format() string {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.codec.Format()
+}
+
+// SetFormat - converts a collection file to a given format, the collection is written immediately
+func (s *JsonFileData) SetFormat(format string) error {
+
+	codec, err := GetCodec(format)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	changed := s.codec.Format() != format
+	s.codec = codec
+	s.touchAll()
+	s.lock.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	if err = s.flush(); err != nil {
+		return err
+	}
+
+	return s.alter(func(e *CatalogEntry) { e.Format = format })
+}
+
+// readItems - detects a format of a collection file and reads its items
+func readItems(r io.Reader, fn func(key string, item json.RawMessage)) (Codec, header, error) {
+
+	c, br, err := detect(bufio.NewReader(r))
+	if err != nil {
+		return nil, header{}, err
+	}
+
+	h, err := c.Decode(br, fn)
+
+	return c, h, err
+}
+
+// maxHeaderLine - the longest first line of a json file that is read to find a header of json lines. A header
+// with generations of maxShards shard files is much shorter, the first line of a json object written in a single
+// line can be as long as the whole file
+const maxHeaderLine = 1 << 20
+
+// detect - recognizes a format of a collection file by its first bytes, returns a reader of the whole file.
+// The first line of a json file is read as a whole, because a header of json lines with generations of many
+// shard files doesn't fit in a buffer of the reader
+func detect(br *bufio.Reader) (Codec, *bufio.Reader, error) {
+
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, nil, errInvalidFormat
+	}
+
+	switch {
+	case first[0] == '{':
+		{
+			line, err := readLine(br, maxHeaderLine)
+			if err != nil && err != io.EOF {
+				return nil, nil, err
+			}
+
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(line), br))
+
+			doc := map[string]header{}
+			if json.Unmarshal(line, &doc) == nil && doc[headerKey].Format == FormatJSONL {
+				return jsonlCodec{}, r, nil
+			}
+
+			return jsonCodec{}, r, nil
+		}
+	case first[0] == cborMagic[0]:
+		return cborCodec{}, br, nil
+	case first[0]&0xf0 == 0x80:
+		return msgpackCodec{}, br, nil
+	}
+
+	return nil, nil, errInvalidFormat
+}
+
+// readLine - reads a line with its end of line, a line longer than limit is read only up to the limit
+func readLine(br *bufio.Reader, limit int) ([]byte, error) {
+
+	line := []byte{}
+
+	for {
+		part, err := br.ReadSlice('\n')
+		line = append(line, part...)
+
+		if err != bufio.ErrBufferFull || len(line) >= limit {
+			if err == bufio.ErrBufferFull {
+				err = nil
+			}
+			return line, err
+		}
+	}
+}
+
+// native - converts json numbers of a generic value to int64 or float64, so binary codecs can store them.
+// A number that can't be converted without losing precision is kept as a decimal
+func native(v interface{}) (interface{}, error) {
+
+	switch val := v.(type) {
+	case json.Number:
+		{
+			if i, err := val.Int64(); err == nil {
+				return i, nil
+			}
+			if f, err := val.Float64(); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == val.String() {
+				return f, nil
+			}
+			d := decimal(val)
+			return &d, nil
+		}
+	case map[string]interface{}:
+		for k, e := range val {
+			n, err := native(e)
+			if err != nil {
+				return nil, err
+			}
+			val[k] = n
+		}
+	case []interface{}:
+		for i, e := range val {
+			n, err := native(e)
+			if err != nil {
+				return nil, err
+			}
+			val[i] = n
+		}
+	}
+
+	return v, nil
+}
+
+// decodeItem - decodes an item to a generic value that can be stored by a binary codec
+func decodeItem(item json.RawMessage) (interface{}, error) {
+
+	v, err := document.Decode(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return native(v)
+}
+
+// decimal - a text of a json number that doesn't fit into int64 or float64, binary codecs store it
+// as a CBOR decimal fraction or as a MessagePack extension, so it is decoded to the same number
+type decimal string
+
+// MarshalJSON - implements json.Marshaler
+func (d *decimal) MarshalJSON() ([]byte, error) {
+	return []byte(*d), nil
+}
+
+// MarshalMsgpack - implements msgpack.Marshaler
+func (d *decimal) MarshalMsgpack() ([]byte, error) {
+	return []byte(*d), nil
+}
+
+// UnmarshalMsgpack - implements msgpack.Unmarshaler
+func (d *decimal) UnmarshalMsgpack(data []byte) error {
+	*d = decimal(data)
+	return nil
+}
+
+// MarshalCBOR - implements cbor.Marshaler, a number is written as a decimal fraction: an exponent and a mantissa
+func (d *decimal) MarshalCBOR() ([]byte, error) {
+
+	text := string(*d)
+	exp := int64(0)
+
+	if n := strings.IndexAny(text, "eE"); n >= 0 {
+		e, err := strconv.ParseInt(text[n+1:], 10, 64)
+		if err != nil {
+			return nil, errInvalidFormat
+		}
+		exp, text = e, text[:n]
+	}
+
+	if n := strings.IndexByte(text, '.'); n >= 0 {
+		exp -= int64(len(text) - n - 1)
+		text = text[:n] + text[n+1:]
+	}
+
+	mant, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return nil, errInvalidFormat
+	}
+
+	return cbor.Marshal(cbor.Tag{Number: cborDecimalTag, Content: []interface{}{exp, mant}})
+}
+
+// cborDecimals - replaces decimal fractions of a value decoded by the CBOR codec with decimals
+func cborDecimals(v interface{}) interface{} {
+
+	switch val := v.(type) {
+	case cbor.Tag:
+		{
+			content, ok := val.Content.([]interface{})
+			if val.Number != cborDecimalTag || !ok || len(content) != 2 {
+				return v
+			}
+
+			exp, mant := fmt.Sprint(content[0]), fmt.Sprint(content[1])
+			if m, ok := content[1].(big.Int); ok {
+				mant = m.String()
+			}
+
+			d := decimal(mant + "e" + exp)
+			return &d
+		}
+	case map[string]interface{}:
+		for k, e := range val {
+			val[k] = cborDecimals(e)
+		}
+	case []interface{}:
+		for i, e := range val {
+			val[i] = cborDecimals(e)
+		}
+	}
+
+	return v
+}
+
+// jsonlCodec - stores items of a collection as json lines
+type jsonlCodec struct{}
+
+// Format - implements Codec
+func (jsonlCodec) Format() string {
+	return FormatJSONL
+}
+
+// Encode - implements Codec
+func (jsonlCodec) Encode(w io.Writer, shards []uint64, keys []string, item func(i int) (json.RawMessage, error)) error {
+
+	bw := bufio.NewWriter(w)
+
+	hdr, err := json.Marshal(map[string]header{headerKey: {Version: formatVersion, Format: FormatJSONL, Shards: shards}})
+	if err != nil {
+		return err
+	}
+
+	bw.Write(hdr)
+	bw.WriteByte('\n')
+
+	for i, k := range keys {
+
+		key, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		v, err := item(i)
+		if err != nil {
+			return err
+		}
+
+		buf := bytes.Buffer{}
+		if err = json.Compact(&buf, v); err != nil {
+			return err
+		}
+
+		bw.WriteByte('[')
+		bw.Write(key)
+		bw.WriteByte(',')
+		bw.Write(buf.Bytes())
+		bw.WriteString("]\n")
+	}
+
+	return bw.Flush()
+}
+
+// Decode - implements Codec
+func (jsonlCodec) Decode(r io.Reader, fn func(key string, item json.RawMessage)) (header, error) {
+
+	dec := json.NewDecoder(r)
+
+	doc := map[string]header{}
+	if err := dec.Decode(&doc); err != nil {
+		return header{}, err
+	}
+
+	h, exists := doc[headerKey]
+	if !exists {
+		return header{}, errInvalidFormat
+	}
+
+	if err := h.check(); err != nil {
+		return header{}, err
+	}
+
+	for {
+		line := []json.RawMessage{}
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return header{}, err
+		}
+
+		key := ""
+		if len(line) != 2 || json.Unmarshal(line[0], &key) != nil {
+			return header{}, errInvalidFormat
+		}
+
+		fn(key, line[1])
+	}
+
+	return h, nil
+}
+
+// cborCodec - stores items of a collection as a CBOR sequence
+type cborCodec struct{}
+
+var cborDecMode, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+
+// Format - implements Codec
+func (cborCodec) Format() string {
+	return FormatCBOR
+}
+
+// Encode - implements Codec
+func (cborCodec) Encode(w io.Writer, shards []uint64, keys []string, item func(i int) (json.RawMessage, error)) error {
+
+	bw := bufio.NewWriter(w)
+	bw.Write(cborMagic)
+
+	enc := cbor.NewEncoder(bw)
+	if err := enc.Encode(header{Version: formatVersion, Format: FormatCBOR, Shards: shards}); err != nil {
+		return err
+	}
+
+	for i, k := range keys {
+
+		data, err := item(i)
+		if err != nil {
+			return err
+		}
+
+		v, err := decodeItem(data)
+		if err != nil {
+			return err
+		}
+
+		if err = enc.Encode([]interface{}{k, v}); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Decode - implements Codec
+func (cborCodec) Decode(r io.Reader, fn func(key string, item json.RawMessage)) (header, error) {
+
+	dec := cborDecMode.NewDecoder(r)
+
+	h := header{}
+	if err := dec.Decode(&h); err != nil {
+		return header{}, err
+	}
+
+	if err := h.check(); err != nil {
+		return header{}, err
+	}
+
+	for {
+		pair := []interface{}{}
+		err := dec.Decode(&pair)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return header{}, err
+		}
+
+		if err = decodePair(cborDecimals(pair).([]interface{}), fn); err != nil {
+			return header{}, err
+		}
+	}
+
+	return h, nil
+}
+
+// msgpackCodec - stores items of a collection as a sequence of MessagePack values
+type msgpackCodec struct{}
+
+// Format - implements Codec
+func (msgpackCodec) Format() string {
+	return FormatMsgpack
+}
+
+// Encode - implements Codec
+func (msgpackCodec) Encode(w io.Writer, shards []uint64, keys []string, item func(i int) (json.RawMessage, error)) error {
+
+	bw := bufio.NewWriter(w)
+
+	enc := msgpack.NewEncoder(bw)
+	enc.SetCustomStructTag("json")
+
+	if err := enc.Encode(header{Version: formatVersion, Format: FormatMsgpack, Shards: shards}); err != nil {
+		return err
+	}
+
+	for i, k := range keys {
+
+		data, err := item(i)
+		if err != nil {
+			return err
+		}
+
+		v, err := decodeItem(data)
+		if err != nil {
+			return err
+		}
+
+		if err = enc.Encode([]interface{}{k, v}); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Decode - implements Codec
+func (msgpackCodec) Decode(r io.Reader, fn func(key string, item json.RawMessage)) (header, error) {
+
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+
+	h := header{}
+	if err := dec.Decode(&h); err != nil {
+		return header{}, err
+	}
+
+	if h.Format != FormatMsgpack {
+		return header{}, errInvalidFormat
+	}
+
+	if err := h.check(); err != nil {
+		return header{}, err
+	}
+
+	for {
+		pair := []interface{}{}
+		err := dec.Decode(&pair)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return header{}, err
+		}
+
+		if err = decodePair(pair, fn); err != nil {
+			return header{}, err
+		}
+	}
+
+	return h, nil
+}
+
+// decodePair - passes a key and an item decoded by a binary codec to fn
+func decodePair(pair []interface{}, fn func(key string, item json.RawMessage)) error {
+
+	if len(pair) != 2 {
+		return errInvalidFormat
+	}
+
+	key, ok := pair[0].(string)
+	if !ok {
+		return errInvalidFormat
+	}
+
+	item, err := json.Marshal(pair[1])
+	if err != nil {
+		return err
+	}
+
+	fn(key, item)
+
+	return nil
+}
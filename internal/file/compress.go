@@ -0,0 +1,171 @@
+package localstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of collection files
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	errUnknownCompression = errors.New("unknown compression of a collection")
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ValidCompression - checks if a compression is supported
+func ValidCompression(compression string) error {
+
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", errUnknownCompression, compression)
+}
+
+// Compression - returns a compression of a collection file
+func (s *JsonFileData) Compression() string {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.compression
+}
+
+// SetCompression - changes a compression of a collection file, the collection is written immediately
+func (s *JsonFileData) SetCompression(compression string) error {
+
+	if err := ValidCompression(compression); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	changed := s.compression != compression
+	s.compression = compression
+	s.touchAll()
+	s.lock.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	return s.alter(func(e *CatalogEntry) { e.Compression = compression })
+}
+
+// compress - wraps w, so data written to the returned writer is compressed. Data is flushed when the writer is closed
+func compress(w io.Writer, compression string) (io.WriteCloser, error) {
+
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", errUnknownCompression, compression)
+}
+
+// decompress - detects a compression of data by its first bytes and returns a reader of uncompressed data
+func decompress(r io.Reader) (io.ReadCloser, string, error) {
+
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		{
+			zr, err := gzip.NewReader(br)
+			return zr, CompressionGzip, err
+		}
+	case bytes.HasPrefix(magic, zstdMagic):
+		{
+			zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, "", err
+			}
+			return zr.IOReadCloser(), CompressionZstd, nil
+		}
+	}
+
+	return io.NopCloser(br), CompressionNone, nil
+}
+
+// scanFile - reads items of a collection file, an encryption, a compression and a format of the file are detected.
+// Items are decrypted, decompressed and decoded while the file is read, so the file is never held in memory as a whole.
+// The checksum of the file is verified when all items are read
+func scanFile(path string, keys KeyProvider, fn func(key string, item json.RawMessage)) (Codec, string, header, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", header{}, err
+	}
+	defer f.Close()
+
+	cr, err := checkFile(f)
+	if err != nil {
+		return nil, "", header{}, err
+	}
+
+	er, d, err := openStream(bufio.NewReader(cr), keys)
+	if err != nil {
+		return nil, "", header{}, cr.check(err)
+	}
+
+	r, compression, err := decompress(er)
+	if err != nil {
+		return nil, "", header{}, cr.check(decryptError(d, err))
+	}
+	defer r.Close()
+
+	codec, h, err := readItems(r, fn)
+	if err != nil {
+		return nil, "", header{}, cr.check(decryptError(d, err))
+	}
+
+	if err = cr.check(nil); err != nil {
+		return nil, "", header{}, err
+	}
+
+	return codec, compression, h, nil
+}
+
+// decryptError - returns an error of decryption if it is the reason why data cannot be read
+func decryptError(d *decryptReader, err error) error {
+
+	if d != nil && d.err != nil {
+		return d.err
+	}
+
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,440 @@
+package localstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encMagic - starts encrypted data. Encrypted data is a header with an id of a key and a nonce prefix,
+// followed by chunks sealed with AES-GCM, so files are encrypted and decrypted while they are written and read
+var encMagic = []byte{'L', 'S', 'E', 0x01}
+
+const (
+	chunkSize  = 64 * 1024
+	prefixSize = 8
+	chunkFinal = 1
+)
+
+var (
+	// ErrDecrypt - returned when data cannot be decrypted, because a key is wrong or the data is damaged
+	ErrDecrypt    = errors.New("unable to decrypt data, the key is wrong or the data is damaged")
+	errTruncated  = fmt.Errorf("%w: data is truncated", ErrDecrypt)
+	errNoKey      = fmt.Errorf("%w: data is encrypted but no key is given", ErrDecrypt)
+	errUnknownKey = fmt.Errorf("%w: data is encrypted with an unknown key", ErrDecrypt)
+	errInvalidKey = errors.New("invalid key, a key must have 16, 24 or 32 bytes")
+)
+
+// KeyProvider - supplies keys used to encrypt collections. Current returns a key used to encrypt new data,
+// Key returns a key with a given id to decrypt data encrypted before keys were rotated
+type KeyProvider interface {
+	Current() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// staticKeys - a key provider with a fixed set of keys
+type staticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeys - returns a key provider that encrypts data with a current key and decrypts data
+// encrypted with the current key or any of old keys
+func NewStaticKeys(current []byte, old ...[]byte) (KeyProvider, error) {
+
+	kp := &staticKeys{current: KeyID(current), keys: map[string][]byte{}}
+
+	for _, key := range append([][]byte{current}, old...) {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, errInvalidKey
+		}
+		kp.keys[KeyID(key)] = key
+	}
+
+	return kp, nil
+}
+
+func (kp *staticKeys) Current() (string, []byte, error) {
+	return kp.current, kp.keys[kp.current], nil
+}
+
+func (kp *staticKeys) Key(id string) ([]byte, error) {
+
+	key, exists := kp.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", errUnknownKey, id)
+	}
+
+	return key, nil
+}
+
+// KeyID - returns an id of a key that is stored with encrypted data, the id doesn't reveal the key
+func KeyID(key []byte) string {
+
+	sum := sha256.Sum256(append([]byte("localstore key id:"), key...))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// sameKeys - checks if two key providers are the same provider or static providers with the same keys
+func sameKeys(a, b KeyProvider) bool {
+
+	if a == nil || b == nil || a == b {
+		return a == b
+	}
+
+	sa, ok := a.(*staticKeys)
+	sb, okb := b.(*staticKeys)
+	if !ok || !okb || sa.current != sb.current || len(sa.keys) != len(sb.keys) {
+		return false
+	}
+
+	for id := range sa.keys {
+		if _, exists := sb.keys[id]; !exists {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetKeys - sets keys used to encrypt collections, nil disables encryption of data written from now on
+func (cm *jsonFileManager) SetKeys(keys KeyProvider) {
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	cm.setKeys(keys)
+}
+
+// setKeys - sets keys of the manager and its loaded collections, must be called under the manager lock
+func (cm *jsonFileManager) setKeys(keys KeyProvider) {
+
+	cm.keys = keys
+
+	for _, s := range cm.m {
+		s.lock.Lock()
+		s.keys = keys
+		if p, paged := s.items.(*pagedItems); paged {
+			p.seal = keys != nil
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Rotate - rewrites all collections and their logs with the current key, or decrypts them if there are no keys
+func (cm *jsonFileManager) Rotate() ([]string, error) {
+
+	if err := cm.writable(); err != nil {
+		return nil, err
+	}
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	names, err := cm.collections()
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := []string{}
+
+	for _, name := range names {
+
+		s, loaded := cm.m[name]
+		if !loaded {
+			s, err = cm.loadFile(name)
+			defer s.items.close()
+			if err != nil {
+				return rotated, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+
+		s.lock.Lock()
+		s.touchAll()
+		s.lock.Unlock()
+
+		if err = s.flush(); err != nil {
+			return rotated, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if err = s.truncateLog(); err != nil {
+			return rotated, fmt.Errorf("%s: %w", name, err)
+		}
+
+		rotated = append(rotated, name)
+	}
+
+	return rotated, nil
+}
+
+// isEncrypted - checks if data starts with a header of encrypted data
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encMagic)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errInvalidKey
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter - seals data in chunks, the last chunk is marked, so a truncated stream is detected
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// encrypt - wraps w, so data written to the returned writer is encrypted with the current key. The last chunk
+// is written when the writer is closed
+func encrypt(w io.Writer, keys KeyProvider) (io.WriteCloser, error) {
+
+	id, key, err := keys.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err = rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	hdr := append([]byte{}, encMagic...)
+	hdr = append(hdr, byte(len(id)))
+	hdr = append(hdr, id...)
+	hdr = append(hdr, prefix...)
+
+	if _, err = w.Write(hdr); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+
+	n := 0
+
+	for len(p) > 0 {
+
+		if len(e.buf) == cap(e.buf) {
+			if err := e.seal(0); err != nil {
+				return n, err
+			}
+		}
+
+		k := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+		n += k
+	}
+
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(chunkFinal)
+}
+
+func (e *encryptWriter) seal(flag byte) error {
+
+	ct := e.aead.Seal(nil, nonce(e.prefix, e.counter), e.buf, []byte{flag})
+
+	hdr := make([]byte, 5)
+	hdr[0] = flag
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(ct)))
+
+	if _, err := e.w.Write(hdr); err != nil {
+		return err
+	}
+
+	if _, err := e.w.Write(ct); err != nil {
+		return err
+	}
+
+	e.counter++
+	e.buf = e.buf[:0]
+
+	return nil
+}
+
+// decryptReader - opens chunks sealed by encryptWriter, an error is kept so it can be reported
+// instead of an error of a decoder that reads decrypted data
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	final   bool
+	err     error
+}
+
+// decrypt - returns a reader of decrypted data, data must start with a header of encrypted data
+func decrypt(r io.Reader, keys KeyProvider) (*decryptReader, error) {
+
+	hdr := make([]byte, len(encMagic)+1)
+	if _, err := io.ReadFull(r, hdr); err != nil || !isEncrypted(hdr) {
+		return nil, errTruncated
+	}
+
+	id := make([]byte, int(hdr[len(encMagic)]))
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, errTruncated
+	}
+
+	if keys == nil {
+		return nil, errNoKey
+	}
+
+	key, err := keys.Key(string(id))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err = io.ReadFull(r, prefix); err != nil {
+		return nil, errTruncated
+	}
+
+	return &decryptReader{r: r, aead: aead, prefix: prefix}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+
+	for len(d.buf) == 0 {
+
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		if d.final {
+			return 0, io.EOF
+		}
+
+		d.err = d.open()
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, hdr); err != nil {
+		return errTruncated
+	}
+
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > chunkSize+uint32(d.aead.Overhead()) {
+		return ErrDecrypt
+	}
+
+	ct := make([]byte, size)
+	if _, err := io.ReadFull(d.r, ct); err != nil {
+		return errTruncated
+	}
+
+	pt, err := d.aead.Open(ct[:0], nonce(d.prefix, d.counter), ct, hdr[:1])
+	if err != nil {
+		return ErrDecrypt
+	}
+
+	d.buf = pt
+	d.counter++
+	d.final = hdr[0] == chunkFinal
+
+	return nil
+}
+
+func nonce(prefix []byte, counter uint32) []byte {
+
+	n := make([]byte, prefixSize+4)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], counter)
+
+	return n
+}
+
+// sealData - encrypts data with the current key, data is returned unchanged if there are no keys
+func sealData(keys KeyProvider, data []byte) ([]byte, error) {
+
+	if keys == nil {
+		return data, nil
+	}
+
+	buf := bytes.Buffer{}
+
+	w, err := encrypt(&buf, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// openData - decrypts data sealed by sealData, data that is not encrypted is returned unchanged
+func openData(keys KeyProvider, data []byte) ([]byte, error) {
+
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	r, err := decrypt(bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// openStream - returns a reader of decrypted data if r starts with a header of encrypted data
+func openStream(r *bufio.Reader, keys KeyProvider) (io.Reader, *decryptReader, error) {
+
+	if magic, _ := r.Peek(len(encMagic)); !isEncrypted(magic) {
+		return r, nil, nil
+	}
+
+	d, err := decrypt(r, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return d, d, nil
+}
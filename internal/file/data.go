@@ -1,36 +1,106 @@
 package localstore
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/przebro/localstore/internal/schema"
 )
 
 var (
-	errKeyNotFound         = errors.New("key not found")
+	//ErrKeyNotFound - returned when an item with a given key does not exist
+	ErrKeyNotFound         = errors.New("key not found")
 	errKeyExists           = errors.New("key aleready exists")
 	errCollectionNotExists = errors.New("collection does not exists")
 	errCollectionExists    = errors.New("collection already exists")
+	//ErrSettings - returned when a directory is opened with settings different from settings of a store that is already open
+	ErrSettings = errors.New("directory is already opened with different keys, watch mode or memory budget")
 )
 
 //JsonFileData - inmemory structure with sync and backup option
 type JsonFileData struct {
-	path       string
-	items      map[string]json.RawMessage
-	lock       sync.RWMutex
-	updatesync bool
+	path        string
+	items       itemStore
+	lock        sync.RWMutex
+	updatesync  atomic.Bool
+	synctime    int
+	resync      chan struct{}
+	spill       chan struct{}
+	onAlter     func(fn func(e *CatalogEntry)) error
+	onFlush     func() error
+	indexes     map[string]*index
+	expires     map[string]time.Time
+	ttl         time.Duration
+	seqs        map[string]uint64
+	seq         uint64
+	queue       []entry
+	size        int64
+	maxDocs     int
+	maxBytes    int64
+	notify      chan struct{}
+	done        chan struct{}
+	change      uint64
+	revs        map[string]uint64
+	ring        []Change
+	pending     []Change
+	logged      int
+	loading     bool
+	flushLock   sync.Mutex
+	synced      uint64
+	onSync      []func(seq uint64)
+	attached    map[string]interface{}
+	schema      *schema.Schema
+	schemaData  []byte
+	codec       Codec
+	compression string
+	keys        KeyProvider
+	fields      []FieldSpec
+	history     bool
+	external    string
+	disk        fileState
+	conflict    bool
+	readOnly    bool
+	cache       *pageCache
+	shards      *Sharding
+	dirty       map[int]bool
+	reshard     bool
+	gens        []uint64
+	generation  uint64
 }
 
 //jsonFileManager - Holds global state of all collections
 type jsonFileManager struct {
-	path string
-	m    map[string]*JsonFileData
-	lock sync.Mutex
+	path         string
+	m            map[string]*JsonFileData
+	lock         sync.Mutex
+	catalog      map[string]*CatalogEntry
+	catalogDirty bool
+	catalogLock  sync.Mutex
+	keys         KeyProvider
+	external     string
+	stop         chan struct{}
+	readOnly     bool
+	cache        *pageCache
+	budget       int64
+	opened       bool
+	recovered    bool
+	records      map[string]bool
+	recordLock   sync.Mutex
+}
+
+//Settings - settings of a manager given by a store, they are shared by all collections of a directory
+type Settings struct {
+	Keys     KeyProvider
+	External string
+	Memory   int64
 }
 
 //FileManager - manages collections in the directory
@@ -39,6 +109,27 @@ type FileManager interface {
 	Path() string
 	NewData(name string, tm int, updatesync bool) (*JsonFileData, error)
 	GetData(name string, tm int, updatesync bool) (*JsonFileData, error)
+	Begin(names []string, tm int, updatesync bool) (*MultiTxn, error)
+	Recover() error
+	Create(name string, tm int, updatesync bool, opts Options) (*JsonFileData, error)
+	Discard(name string) error
+	Alter(name string, tm int, updatesync bool, opts Options) error
+	Catalog(name string) (CatalogEntry, bool)
+	Upgrade() ([]string, error)
+	SetKeys(keys KeyProvider)
+	Rotate() ([]string, error)
+	Backup(ctx context.Context, w io.Writer) error
+	Restore(ctx context.Context, r io.Reader) error
+	Verify(ctx context.Context, repair bool) (Report, error)
+	SetExternal(mode string) error
+	Conflicts() []string
+	Snapshot(name string, collections []string) (SnapshotInfo, error)
+	Snapshots() ([]SnapshotInfo, error)
+	DropSnapshot(name string) error
+	RestoreSnapshot(name string, collections []string, tm int, updatesync bool) error
+	RestoreAt(name string, t time.Time, tm int, updatesync bool) error
+	SetMemory(budget int64)
+	Open(settings Settings) error
 }
 
 var managers = map[string]FileManager{}
@@ -57,13 +148,50 @@ func GetFileManager(path string) FileManager {
 	return m
 }
 
+//Open - applies settings of a store. Settings are shared by all stores of a directory, so until the manager is closed
+//it accepts only the same settings
+func (cm *jsonFileManager) Open(settings Settings) error {
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	if cm.opened {
+		if !sameKeys(cm.keys, settings.Keys) || cm.external != settings.External || cm.budget != memoryBudget(settings.Memory) {
+			return ErrSettings
+		}
+		return nil
+	}
+
+	if err := cm.setExternal(settings.External); err != nil {
+		return err
+	}
+
+	cm.setKeys(settings.Keys)
+	cm.setMemory(settings.Memory)
+	cm.opened = true
+
+	if !cm.readOnly {
+		removeSegments(cm.path)
+	}
+
+	return nil
+}
+
 //Close - sync closes all collections
 func (cm *jsonFileManager) Close() {
 	defer cm.lock.Unlock()
 	cm.lock.Lock()
 
+	cm.opened = false
+
+	if cm.stop != nil {
+		close(cm.stop)
+		cm.stop = nil
+	}
+
 	for k, n := range cm.m {
 		n.Sync()
+		close(n.done)
+		n.items.close()
 		delete(cm.m, k)
 	}
 }
@@ -73,21 +201,9 @@ func (cm *jsonFileManager) Path() string {
 	return cm.path
 }
 
-//KeyCollector - Collects results from insert multiple records
-type KeyCollector interface {
-	Collect(key string)
-}
-
 //NewData - creates a new store with optional sync every tm seconds and/or sync after insert/delete/update operations
 func (cm *jsonFileManager) NewData(name string, tm int, updatesync bool) (*JsonFileData, error) {
-
-	_, err := cm.getFileData(name, tm, updatesync, false)
-
-	if err == errCollectionNotExists {
-		return cm.createFileData(name, tm, updatesync)
-	}
-
-	return nil, errCollectionExists
+	return cm.Create(name, tm, updatesync, Options{})
 }
 
 //GetData - gets an existing store
@@ -110,6 +226,12 @@ func (cm *jsonFileManager) getFileData(name string, tm int, updatesync, load boo
 
 	s, exists := cm.m[name]
 
+	if exists && cm.readOnly {
+		if err := s.checkDisk(); err != nil {
+			return nil, err
+		}
+	}
+
 	if !exists {
 
 		_, err := os.Stat(fpath)
@@ -119,21 +241,47 @@ func (cm *jsonFileManager) getFileData(name string, tm int, updatesync, load boo
 		}
 		//Prevents from loading collection
 		if load {
-			var data []byte
-			var err error
-			if data, err = ioutil.ReadFile(fpath); err != nil {
+
+			entry, err := cm.entry(name, fpath)
+			if err != nil {
 				return nil, err
 			}
 
-			items := map[string]json.RawMessage{}
-			if err = json.Unmarshal(data, &items); err != nil {
+			s = initialize(fpath, entry.syncTime(tm), entry.updateSync(updatesync))
+			s.keys = cm.keys
+			s.external = cm.external
+			s.readOnly = cm.readOnly
+			s.cache = cm.pageCache()
+			s.shards = entry.Shards
+			if entry.Paged {
+				if err = s.page(); err != nil {
+					return nil, err
+				}
+			}
+			if err = s.load(); err != nil {
+				s.items.close()
 				return nil, err
 			}
-
-			s = initialize(fpath, updatesync, items)
+			if err = s.loadLog(); err != nil {
+				s.items.close()
+				return nil, err
+			}
+			if err = s.loadSchema(); err != nil {
+				s.items.close()
+				return nil, err
+			}
+			s.restore(entry)
+			s.onAlter = cm.alterer(name)
+			if format, compression := s.codec.Format(), s.compression; !cm.readOnly && (entry.Format != format || entry.compression() != compression) {
+				s.alter(func(e *CatalogEntry) {
+					e.Format = format
+					e.Compression = compression
+				})
+			}
+			s.onFlush = cm.syncCatalog
 			cm.m[name] = s
 
-			go watch(s, tm)
+			go watch(s)
 		}
 	}
 
@@ -141,7 +289,7 @@ func (cm *jsonFileManager) getFileData(name string, tm int, updatesync, load boo
 }
 
 //CreateCollection - creates a new collection only if file does not exists yet otherwise returns error
-func (cm *jsonFileManager) createFileData(name string, tm int, updatesync bool) (*JsonFileData, error) {
+func (cm *jsonFileManager) createFileData(name string, tm int, updatesync bool, opts Options) (*JsonFileData, error) {
 	defer cm.lock.Unlock()
 	cm.lock.Lock()
 
@@ -152,10 +300,50 @@ func (cm *jsonFileManager) createFileData(name string, tm int, updatesync bool)
 		return nil, errCollectionExists
 	}
 
-	s := initialize(fpath, updatesync, map[string]json.RawMessage{})
+	codec := Codec(jsonCodec{})
+	if opts.Format != nil {
+		var err error
+		if codec, err = GetCodec(*opts.Format); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Shards != nil {
+		if err := opts.Shards.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	compression := CompressionNone
+	if opts.Compression != nil {
+		if err := ValidCompression(*opts.Compression); err != nil {
+			return nil, err
+		}
+		compression = *opts.Compression
+	}
+
+	entry := &CatalogEntry{SyncTime: opts.SyncTime, UpdateSync: opts.UpdateSync, Format: codec.Format(), Compression: compression, Created: time.Now().UTC()}
+	if err := cm.putEntry(name, entry); err != nil {
+		return nil, err
+	}
+
+	s := initialize(fpath, entry.syncTime(tm), entry.updateSync(updatesync))
+	s.keys = cm.keys
+	s.external = cm.external
+	s.codec = codec
+	s.compression = compression
+	s.cache = cm.pageCache()
+	if err := s.loadLog(); err != nil {
+		return nil, err
+	}
+	if err := s.loadSchema(); err != nil {
+		return nil, err
+	}
+	s.onAlter = cm.alterer(name)
+	s.onFlush = cm.syncCatalog
 	cm.m[name] = s
 
-	go watch(s, tm)
+	go watch(s)
 
 	return s, nil
 
@@ -164,166 +352,615 @@ func (cm *jsonFileManager) createFileData(name string, tm int, updatesync bool)
 //Insert - inserts a new item
 func (s *JsonFileData) Insert(key string, item json.RawMessage) error {
 
-	if s.updatesync {
+	if s.updatesync.Load() {
 		defer s.Sync()
 	}
 
 	defer s.lock.Unlock()
 	s.lock.Lock()
-	if _, ok := s.items[key]; !ok {
-		s.items[key] = item
+	if _, ok, _ := s.item(key); !ok {
+		item, err := s.check(item)
+		if err != nil {
+			return err
+		}
+		s.set(key, item)
 		return nil
 	}
 
 	return errKeyExists
 }
 
-//ForEach - this method helps load multiple records into collection. It takes a slice of elements
-//that will be loaded into the collection, a function that will be performed for each element e.g. conversion to json format.
-//The KeyCollector will collect ids of inserted elements
-func (s *JsonFileData) ForEach(items []interface{}, kc KeyCollector, fn func(item interface{}) (string, []byte, error)) error {
+//Get - gets an item from a store, an error is returned if the body of a paged item can't be read
+func (s *JsonFileData) Get(key string) (json.RawMessage, bool, error) {
 
-	if s.updatesync {
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.item(key)
+}
+
+//Count - returns a total number of elements in a collection
+func (s *JsonFileData) Count() int64 {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return int64(s.items.len() - s.expired(time.Now()))
+}
+
+//Update - updates an item
+func (s *JsonFileData) Update(key string, item json.RawMessage) error {
+
+	if s.updatesync.Load() {
 		defer s.Sync()
 	}
+
 	defer s.lock.Unlock()
 	s.lock.Lock()
 
-	for n := range items {
-		k, v, e := fn(items[n])
-		if _, exists := s.items[k]; exists || e != nil {
-			return e
-		}
-		s.items[k] = v
-		kc.Collect(k)
+	item, err := s.check(item)
+	if err != nil {
+		return err
 	}
 
+	s.set(key, item)
+
 	return nil
 }
 
-func (s *JsonFileData) Over(fn func(item json.RawMessage) bool) ([]json.RawMessage, error) {
+//Modify - atomically replaces an item with a result of fn, fn is called under the lock with the current value
+//of the item and if it returns an error the item is left untouched
+func (s *JsonFileData) Modify(key string, fn func(item json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+
+	item, err := s.modify(key, fn)
+
+	if err == nil && s.updatesync.Load() {
+		s.Sync()
+	}
+
+	return item, err
+}
+
+//modify - replaces an item with a result of fn, the lock is released even if fn panics
+func (s *JsonFileData) modify(key string, fn func(item json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+
 	defer s.lock.Unlock()
 	s.lock.Lock()
 
-	result := []json.RawMessage{}
-	for _, n := range s.items {
-		if fn(n) {
-			result = append(result, n)
-		}
+	item, ok, err := s.item(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	if item, err = fn(item); err != nil {
+		return nil, err
+	}
+
+	if item, err = s.check(item); err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	return s.set(key, item), nil
 }
 
-//Get - gets an item from a store
-func (s *JsonFileData) Get(key string) (json.RawMessage, bool) {
+//UpdateWhere - replaces every item accepted by match with a result of fn under a single lock. Items are replaced only
+//if fn succeeds for all of them, returns a number of replaced items
+func (s *JsonFileData) UpdateWhere(match func(item json.RawMessage) bool, fn func(item json.RawMessage) (json.RawMessage, error)) (int, error) {
 
-	defer s.lock.RUnlock()
-	s.lock.RLock()
+	num, err := s.updateWhere(match, fn)
+
+	if num != 0 && s.updatesync.Load() {
+		s.Sync()
+	}
 
-	item, ok := s.items[key]
-	return item, ok
+	return num, err
 }
 
-//Count - returns a total number of elements in a collection
-func (s *JsonFileData) Count() int64 {
+//updateWhere - replaces items accepted by match, the lock is released even if match or fn panics
+func (s *JsonFileData) updateWhere(match func(item json.RawMessage) bool, fn func(item json.RawMessage) (json.RawMessage, error)) (int, error) {
 
-	defer s.lock.RUnlock()
-	s.lock.RLock()
+	defer s.lock.Unlock()
+	s.lock.Lock()
+
+	changes := map[string]json.RawMessage{}
+	now := time.Now()
+	var err error
+	ierr := s.items.each(func(k string, v json.RawMessage) bool {
+
+		if s.isExpired(k, now) || !match(v) {
+			return true
+		}
+
+		item, ferr := fn(v)
+		if ferr == nil {
+			item, ferr = s.check(item)
+		}
+		if ferr != nil {
+			err = &KeyError{Key: k, Err: ferr}
+			return false
+		}
+		changes[k] = item
+
+		return true
+	})
+
+	if ierr != nil {
+		return 0, ierr
+	}
+
+	if err != nil {
+		return 0, err
+	}
 
-	return int64(len(s.items))
+	for k, v := range changes {
+		s.set(k, v)
+	}
+
+	return len(changes), nil
 }
 
-//Update - updates an item
-func (s *JsonFileData) Update(key string, item json.RawMessage) error {
+//DeleteWhere - removes every item accepted by match under a single lock, returns a number of removed items.
+//If match returns an error for any item none is removed
+func (s *JsonFileData) DeleteWhere(match func(item json.RawMessage) (bool, error)) (int, error) {
 
-	if s.updatesync {
-		defer s.Sync()
+	if err := s.writable(); err != nil {
+		return 0, err
 	}
 
+	num, err := s.deleteWhere(match)
+
+	if num != 0 && s.updatesync.Load() {
+		s.Sync()
+	}
+
+	return num, err
+}
+
+//deleteWhere - removes items accepted by match, the lock is released even if match panics
+func (s *JsonFileData) deleteWhere(match func(item json.RawMessage) (bool, error)) (int, error) {
+
 	defer s.lock.Unlock()
 	s.lock.Lock()
 
-	s.items[key] = item
+	keys := []string{}
+	now := time.Now()
+	var err error
+	ierr := s.items.each(func(k string, v json.RawMessage) bool {
 
-	return nil
-}
+		if s.isExpired(k, now) {
+			return true
+		}
 
-//Bulk - performs bulk upsert
-func (s *JsonFileData) Bulk(keys []string, items []json.RawMessage) {
+		ok, merr := match(v)
+		if merr != nil {
+			err = &KeyError{Key: k, Err: merr}
+			return false
+		}
+		if ok {
+			keys = append(keys, k)
+		}
 
-	if s.updatesync {
-		defer s.Sync()
+		return true
+	})
+
+	if ierr != nil {
+		return 0, ierr
 	}
-	defer s.lock.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, k := range keys {
+		s.remove(k)
+	}
+
+	return len(keys), nil
+}
+
+//Bulk - performs bulk upsert, items are stored only if all of them match the schema
+func (s *JsonFileData) Bulk(keys []string, items []json.RawMessage) error {
+
 	s.lock.Lock()
+	checked := make([]json.RawMessage, len(items))
 	for i, k := range keys {
-		s.items[k] = items[i]
+		item, err := s.check(items[i])
+		if err != nil {
+			s.lock.Unlock()
+			return &KeyError{Key: k, Err: err}
+		}
+		checked[i] = item
+	}
+
+	for i, k := range keys {
+		s.set(k, checked[i])
+	}
+	s.lock.Unlock()
+
+	if s.updatesync.Load() {
+		s.Sync()
 	}
+
+	return nil
 }
 
 //Delete - removes an item from a store
-func (s *JsonFileData) Delete(key string) {
+func (s *JsonFileData) Delete(key string) error {
 
-	if s.updatesync {
-		defer s.Sync()
+	if err := s.writable(); err != nil {
+		return err
 	}
 
-	defer s.lock.Unlock()
 	s.lock.Lock()
-	delete(s.items, key)
+	s.remove(key)
+	s.lock.Unlock()
+
+	if s.updatesync.Load() {
+		s.Sync()
+	}
+
+	return nil
 }
 
 //All - Returns all items in store
-func (s *JsonFileData) All() []json.RawMessage {
+func (s *JsonFileData) All() ([]json.RawMessage, error) {
 
 	defer s.lock.RUnlock()
 	s.lock.RLock()
-	col := make([]json.RawMessage, len(s.items), len(s.items))
-	i := 0
-	for _, v := range s.items {
-		col[i] = v
-		i++
+	col := make([]json.RawMessage, 0, s.items.len())
+	now := time.Now()
+	err := s.items.each(func(k string, v json.RawMessage) bool {
+		if !s.isExpired(k, now) {
+			col = append(col, v)
+		}
+		return true
+	})
+
+	return col, err
+}
+
+//set - puts an item into the collection and updates indexes, must be called under the lock.
+//An item that was not stamped by check is stamped with the default time to live. Returns the stored item
+func (s *JsonFileData) set(key string, item json.RawMessage) json.RawMessage {
+
+	item = s.stamp(item)
+
+	old, exists, _ := s.items.get(key)
+	if exists {
+		s.record(ChangeUpdate, key, item)
+	} else {
+		s.record(ChangeCreate, key, item)
+	}
+
+	s.items.put(key, item)
+	s.touch(key)
+	s.size += int64(len(item) - len(old))
+	s.track(key, item)
+
+	for _, ix := range s.indexes {
+		if exists {
+			ix.remove(key, old)
+		}
+		ix.add(key, item)
+	}
+
+	if !exists {
+		s.seq++
+		s.seqs[key] = s.seq
+		s.queue = append(s.queue, entry{key: key, seq: s.seq})
+	}
+
+	s.evict(key)
+
+	return item
+}
+
+//remove - removes an item from the collection and updates indexes, must be called under the lock
+func (s *JsonFileData) remove(key string) {
+
+	old, exists, _ := s.items.get(key)
+	if !exists {
+		return
+	}
+
+	s.record(ChangeDelete, key, nil)
+
+	s.items.del(key)
+	s.touch(key)
+	delete(s.expires, key)
+	delete(s.seqs, key)
+	s.size -= int64(len(old))
+
+	for _, ix := range s.indexes {
+		ix.remove(key, old)
 	}
+}
+
+//item - returns an item if it exists and has not expired, must be called under the lock
+func (s *JsonFileData) item(key string) (json.RawMessage, bool, error) {
 
-	return col
+	item, ok, err := s.items.get(key)
+	if !ok || s.isExpired(key, time.Now()) {
+		return nil, false, nil
+	}
+
+	return item, true, err
 }
 
 //Sync - writes map to disk
 func (s *JsonFileData) Sync() {
+	s.flush()
+}
+
+//flush - writes map to a temporary file and replaces the collection file with it,
+//so the file on disk always holds a complete state of the collection. Items are written in the order of insertion.
+//Pending changes are appended to the log of changes before the collection file is replaced
+func (s *JsonFileData) flush() error {
+
+	defer s.flushLock.Unlock()
+	s.flushLock.Lock()
+
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	if err := s.reconcile(); err != nil {
+		return err
+	}
 
-	tmp := map[string]interface{}{}
 	s.lock.Lock()
-	for k, v := range s.items {
-		tmp[k] = v
+	sn, shards := s.fileSnapshots()
+	pending := s.pending
+	s.pending = []Change{}
+	synced := s.change
+	s.lock.Unlock()
 
+	err := s.appendLog(pending)
+	if err != nil {
+		s.lock.Lock()
+		s.pending = append(pending, s.pending...)
+		for i, shard := range shards {
+			s.dirty[i] = true
+			shard.release()
+		}
+		s.lock.Unlock()
+		sn.release()
+		return err
 	}
+
+	if err = s.writeShards(shards, sn.shards); err == nil {
+		if err = writeChecked(s.path, sn.encode); err != nil {
+			s.discardShards(shards, sn.shards)
+		}
+	}
+	sn.release()
+	if err != nil {
+		return err
+	}
+
+	s.removeStale(sn.shards)
+
+	if s.external != "" {
+		s.disk, _ = statFile(s.path)
+	}
+
+	s.lock.Lock()
+	if synced > s.synced {
+		s.synced = synced
+	}
+	hooks := s.onSync
 	s.lock.Unlock()
 
-	result, _ := json.Marshal(tmp)
-	ioutil.WriteFile(s.path, result, 0644)
+	for _, fn := range hooks {
+		fn(synced)
+	}
+
+	if s.onFlush != nil {
+		return s.onFlush()
+	}
+
+	return nil
+}
+
+//snapshot - a state of a collection that is written to a collection file, items are read by item
+//until the snapshot is released
+type snapshot struct {
+	order       []string
+	item        func(i int) (json.RawMessage, error)
+	release     func()
+	codec       Codec
+	compression string
+	keys        KeyProvider
+	change      uint64
+	shards      []uint64
+	parts       map[int]snapshot
+}
+
+//snapshot - captures a state of a collection, must be called under the lock
+func (s *JsonFileData) snapshot() snapshot {
+	return s.snapshotOf(s.ordered())
+}
+
+//snapshotOf - captures items with given keys, must be called under the lock
+func (s *JsonFileData) snapshotOf(order []string) snapshot {
+
+	item, release := s.items.view(order)
+
+	return snapshot{order: order, item: item, release: release, codec: s.codec, compression: s.compression, keys: s.keys, change: s.change}
+}
+
+//encode - writes a snapshot with a format, a compression and an encryption of the collection
+func (sn snapshot) encode(w io.Writer) error {
+
+	var ew io.WriteCloser = nopWriteCloser{w}
+	if sn.keys != nil {
+		var err error
+		if ew, err = encrypt(w, sn.keys); err != nil {
+			return err
+		}
+	}
+
+	cw, err := compress(ew, sn.compression)
+	if err != nil {
+		return err
+	}
+
+	if err = sn.codec.Encode(cw, sn.shards, sn.order, sn.item); err != nil {
+		return err
+	}
+
+	if err = cw.Close(); err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+//Synced - returns the sequence number of the latest change written to the collection file
+func (s *JsonFileData) Synced() uint64 {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.synced
+}
+
+//OnSync - registers fn called after the collection file is written, fn receives the sequence number
+//of the latest written change
+func (s *JsonFileData) OnSync(fn func(seq uint64)) {
+
+	defer s.lock.Unlock()
+	s.lock.Lock()
+
+	s.onSync = append(s.onSync, fn)
+}
+
+//Attach - returns a value attached to the collection under a name, if there is no such value it is created by fn.
+//It allows to keep a state of a collection that is shared by all its users, fn is called under the lock and must not use the collection
+func (s *JsonFileData) Attach(name string, fn func() interface{}) interface{} {
+
+	s.lock.RLock()
+	v, exists := s.attached[name]
+	s.lock.RUnlock()
+
+	if exists {
+		return v
+	}
+
+	defer s.lock.Unlock()
+	s.lock.Lock()
+
+	v, exists = s.attached[name]
+	if !exists {
+		v = fn()
+		s.attached[name] = v
+	}
+
+	return v
 }
 
-func initialize(path string, updatesync bool, items map[string]json.RawMessage) *JsonFileData {
+//load - loads items from a collection file
+func (s *JsonFileData) load() error {
+
+	defer s.lock.Unlock()
+	s.lock.Lock()
+
+	s.loading = true
+	defer func() { s.loading = false }()
+
+	files, err := allShardFiles(s.path)
+	if err != nil {
+		return err
+	}
+
+	var codec Codec
+	var compression string
+
+	if s.shards != nil || len(files) != 0 {
+		codec, compression, err = s.loadShards()
+	} else {
+		codec, compression, _, err = scanFile(s.path, s.keys, func(key string, item json.RawMessage) {
+			s.set(key, item)
+		})
+	}
+
+	if err == nil {
+		s.codec = codec
+		s.compression = compression
+	}
+
+	if err == nil && s.external != "" {
+		s.disk, err = statFile(s.path)
+	}
+
+	return err
+}
 
-	s := &JsonFileData{path: path, items: items, lock: sync.RWMutex{}, updatesync: updatesync}
+func initialize(path string, tm int, updatesync bool) *JsonFileData {
+
+	s := &JsonFileData{path: path, items: memItems{}, lock: sync.RWMutex{}, synctime: tm, resync: make(chan struct{}, 1), spill: make(chan struct{}, 1), indexes: map[string]*index{},
+		expires: map[string]time.Time{}, seqs: map[string]uint64{}, queue: []entry{}, notify: make(chan struct{}), done: make(chan struct{}),
+		revs: map[string]uint64{}, ring: []Change{}, pending: []Change{}, attached: map[string]interface{}{}, codec: jsonCodec{}, compression: CompressionNone,
+		dirty: map[int]bool{},
+	}
+	s.updatesync.Store(updatesync)
 
 	return s
 }
 
-func watch(s *JsonFileData, tm int) {
+//watch - syncs a collection every synctime seconds, appends pending changes to the log when there are too many of them
+//and purges expired items, stops when the collection is closed
+func watch(s *JsonFileData) {
 
-	if tm == 0 {
-		return
+	var t *time.Ticker
+	var tick <-chan time.Time
+
+	reset := func() {
+		if t != nil {
+			t.Stop()
+			t, tick = nil, nil
+		}
+		if tm, _ := s.SyncPolicy(); tm != 0 {
+			t = time.NewTicker(time.Duration(tm) * time.Second)
+			tick = t.C
+		}
 	}
 
-	t := time.NewTicker(time.Duration(tm) * time.Second)
+	reset()
+	defer func() {
+		if t != nil {
+			t.Stop()
+		}
+	}()
+
+	p := time.NewTicker(purgeInterval)
+	defer p.Stop()
+
 	for {
 		select {
-		case <-t.C:
+		case <-tick:
 			{
 				s.Sync()
 			}
+		case <-s.resync:
+			{
+				reset()
+			}
+		case <-s.spill:
+			{
+				s.flushLog()
+			}
+		case <-p.C:
+			{
+				s.Purge()
+			}
+		case <-s.done:
+			{
+				return
+			}
 		}
 	}
 }
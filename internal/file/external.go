@@ -0,0 +1,331 @@
+package localstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Modes of handling collection files changed by another process
+const (
+	// ExternalReload - a changed collection is reloaded from its file, changes that are not synced yet are discarded
+	ExternalReload = "reload"
+	// ExternalRefuse - a changed collection file is not overwritten until the conflict is resolved with Reload or Overwrite
+	ExternalRefuse = "refuse"
+)
+
+var (
+	// ErrConflict - returned when a collection file was changed by another process and the collection can't be written
+	ErrConflict = errors.New("collection file was changed by another process")
+
+	errUnknownExternal = errors.New("unknown mode of handling changed collection files")
+)
+
+// pollInterval - an interval of checking collection files where notifications about changed files are not available
+var pollInterval = time.Second
+
+// fileState - identifies content of a collection file, sum is a checksum of the whole file
+type fileState struct {
+	modTime time.Time
+	size    int64
+	sum     []byte
+}
+
+// statFile - returns a state of a collection file
+func statFile(path string) (fileState, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fileState{}, err
+	}
+
+	st := fileState{modTime: info.ModTime(), size: info.Size()}
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return fileState{}, err
+	}
+	st.sum = h.Sum(nil)
+
+	return st, nil
+}
+
+// SetExternal - sets how collection files changed by another process are handled and watches the directory for changes,
+// an empty mode turns watching off
+func (cm *jsonFileManager) SetExternal(mode string) error {
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	return cm.setExternal(mode)
+}
+
+// setExternal - sets a mode of the manager and its loaded collections, must be called under the manager lock
+func (cm *jsonFileManager) setExternal(mode string) error {
+
+	if mode != "" && mode != ExternalReload && mode != ExternalRefuse {
+		return fmt.Errorf("%w: %s", errUnknownExternal, mode)
+	}
+
+	if cm.readOnly && mode != ExternalReload {
+		return ErrReadOnly
+	}
+
+	cm.external = mode
+
+	for _, s := range cm.m {
+		if err := s.setExternal(mode); err != nil {
+			return err
+		}
+	}
+
+	if mode == "" && cm.stop != nil {
+		close(cm.stop)
+		cm.stop = nil
+	}
+
+	if mode != "" && cm.stop == nil {
+		cm.stop = make(chan struct{})
+		watchFiles(cm.path, cm.stop, cm.changed)
+	}
+
+	return nil
+}
+
+// Conflicts - returns names of loaded collections that can't be written because their files were changed by another process
+func (cm *jsonFileManager) Conflicts() []string {
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	names := []string{}
+	for name, s := range cm.m {
+		if s.Conflict() {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// changed - checks a file of a loaded collection with a given name or files of all loaded collections if name is empty
+func (cm *jsonFileManager) changed(name string) {
+
+	cm.lock.Lock()
+	targets := []*JsonFileData{}
+	for n, s := range cm.m {
+		if name == "" || n == name {
+			targets = append(targets, s)
+		}
+	}
+	cm.lock.Unlock()
+
+	for _, s := range targets {
+		s.checkDisk()
+	}
+}
+
+// Conflict - returns true if the collection file was changed by another process and the conflict is not resolved
+func (s *JsonFileData) Conflict() bool {
+
+	defer s.flushLock.Unlock()
+	s.flushLock.Lock()
+
+	return s.conflict
+}
+
+// Reload - resolves a conflict by replacing the collection with content of its file, changes are recorded
+// as if items were written one by one
+func (s *JsonFileData) Reload() error {
+
+	defer s.flushLock.Unlock()
+	s.flushLock.Lock()
+
+	st, err := statFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	return s.reload(st)
+}
+
+// Overwrite - resolves a conflict by writing the collection over the changed file
+func (s *JsonFileData) Overwrite() error {
+
+	s.flushLock.Lock()
+	s.conflict = false
+	st, err := statFile(s.path)
+	if err == nil {
+		s.disk = st
+	}
+	s.flushLock.Unlock()
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return s.flush()
+}
+
+// setExternal - sets how a changed collection file is handled, the current state of the file is recorded
+func (s *JsonFileData) setExternal(mode string) error {
+
+	defer s.flushLock.Unlock()
+	s.flushLock.Lock()
+
+	s.external = mode
+	if mode == "" {
+		s.conflict = false
+		return nil
+	}
+
+	st, err := statFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	s.disk = st
+
+	return err
+}
+
+// checkDisk - checks if the collection file was changed by another process
+func (s *JsonFileData) checkDisk() error {
+
+	defer s.flushLock.Unlock()
+	s.flushLock.Lock()
+
+	return s.reconcile()
+}
+
+// reconcile - handles a collection file changed by another process, must be called under the flush lock.
+// In the reload mode the collection is replaced with content of the file, in the refuse mode ErrConflict is returned
+// until the conflict is resolved. A removed file is not a change, it is written again by the next sync
+func (s *JsonFileData) reconcile() error {
+
+	if s.external == "" {
+		return nil
+	}
+
+	if s.conflict {
+		return ErrConflict
+	}
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == s.disk.size && info.ModTime().Equal(s.disk.modTime) {
+		return nil
+	}
+
+	st, err := statFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(st.sum, s.disk.sum) {
+		s.disk = st
+		return nil
+	}
+
+	if s.external == ExternalRefuse {
+		s.conflict = true
+		return ErrConflict
+	}
+
+	return s.reload(st)
+}
+
+// reload - replaces the collection with content of its file, must be called under the flush lock. A shared reader
+// doesn't record changes, it reads them from the log written by a writer
+func (s *JsonFileData) reload(st fileState) error {
+
+	if err := s.adopt(st); err != nil {
+		return err
+	}
+
+	order, items, err := scanCollection(s.path, s.currentKeys())
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.loading = s.readOnly
+	err = s.replaceItems(order, items)
+	s.loading = false
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	s.disk = st
+	s.conflict = false
+
+	if !s.readOnly {
+		return nil
+	}
+
+	if err = s.loadLog(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.lock.Unlock()
+
+	return nil
+}
+
+// adopt - records the checksum of a collection file written by another process that doesn't record checksums,
+// so the file is read as it is. Files written by a writer of a shared collection already have their checksums
+func (s *JsonFileData) adopt(st fileState) error {
+
+	if s.readOnly {
+		return nil
+	}
+
+	for _, fs := range readSums(s.path) {
+		if fs.Size == st.size && fs.SHA256 == hex.EncodeToString(st.sum) {
+			return nil
+		}
+	}
+
+	return writeSums(s.path, st.size, st.sum)
+}
+
+// pollFiles - checks files of all loaded collections every pollInterval until stop is closed
+func pollFiles(stop <-chan struct{}, fn func(name string)) {
+
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			fn("")
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package localstore
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// watchFiles - starts watching the directory, fn is called with a name of a collection whose file was written or replaced
+// until stop is closed. If inotify is not available files of all loaded collections are checked every pollInterval
+func watchFiles(path string, stop <-chan struct{}, fn func(name string)) {
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		go pollFiles(stop, fn)
+		return
+	}
+
+	if _, err = syscall.InotifyAddWatch(fd, path, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		go pollFiles(stop, fn)
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-stop
+		f.Close()
+	}()
+
+	go readEvents(f, fn)
+}
+
+// readEvents - reads inotify events until the file is closed
+func readEvents(f *os.File, fn func(name string)) {
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			size := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := strings.TrimRight(string(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+size]), "\x00")
+			off += syscall.SizeofInotifyEvent + size
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				fn("")
+				continue
+			}
+
+			if strings.HasSuffix(name, ".json") {
+				fn(strings.TrimSuffix(name, ".json"))
+			}
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package localstore
+
+// watchFiles - starts checking files of all loaded collections every pollInterval until stop is closed
+func watchFiles(path string, stop <-chan struct{}, fn func(name string)) {
+	go pollFiles(stop, fn)
+}
@@ -0,0 +1,187 @@
+package localstore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Modes of encrypted fields
+const (
+	// FieldRandom - a value is encrypted with a random nonce, equal values give different ciphertexts
+	FieldRandom = "random"
+	// FieldDeterministic - equal values give equal ciphertexts, so a field can be compared for equality
+	FieldDeterministic = "deterministic"
+	// FieldRedact - a value is replaced with a keyed hash, it cannot be decrypted but can be compared for equality
+	FieldRedact = "redact"
+)
+
+// fieldPrefixes - prefixes of sealed values of every mode, a sealed value is: $mode$keyid$base64
+var fieldPrefixes = map[string]string{
+	FieldRandom:        "$enc$",
+	FieldDeterministic: "$det$",
+	FieldRedact:        "$red$",
+}
+
+var (
+	errInvalidField = errors.New("invalid encrypted field")
+	errFieldNoKey   = errors.New("encrypted fields require a key")
+)
+
+// FieldSpec - a field of documents that is encrypted or redacted before a document is stored
+type FieldSpec struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+}
+
+// Fields - returns encrypted fields of a collection
+func (s *JsonFileData) Fields() []FieldSpec {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return append([]FieldSpec{}, s.fields...)
+}
+
+// SetFields - sets encrypted fields of a collection, it doesn't change documents that are already stored
+func (s *JsonFileData) SetFields(fields []FieldSpec) error {
+
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if _, ok := fieldPrefixes[f.Mode]; !ok || f.Name == "" || f.Name == "_id" || seen[f.Name] {
+			return fmt.Errorf("%w: %s", errInvalidField, f.Name)
+		}
+		seen[f.Name] = true
+	}
+
+	if len(fields) != 0 && s.Keys() == nil {
+		return errFieldNoKey
+	}
+
+	s.lock.Lock()
+	s.fields = append([]FieldSpec{}, fields...)
+	s.lock.Unlock()
+
+	return s.alter(func(e *CatalogEntry) { e.Fields = append([]FieldSpec{}, fields...) })
+}
+
+// Keys - returns keys used to encrypt a collection, nil if a collection is not encrypted
+func (s *JsonFileData) Keys() KeyProvider {
+	return s.currentKeys()
+}
+
+// SealValue - encrypts or redacts a json value of a field with the current key. The name of the field
+// is authenticated, so a sealed value can't be moved to another field
+func SealValue(keys KeyProvider, spec FieldSpec, value []byte) (string, error) {
+
+	if keys == nil {
+		return "", errFieldNoKey
+	}
+
+	id, key, err := keys.Current()
+	if err != nil {
+		return "", err
+	}
+
+	mac := fieldMAC(key, spec.Name, value)
+	prefix := fieldPrefixes[spec.Mode] + id + "$"
+
+	if spec.Mode == FieldRedact {
+		return prefix + base64.RawURLEncoding.EncodeToString(mac), nil
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if spec.Mode == FieldDeterministic {
+		copy(nonce, mac)
+	} else if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, value, []byte(spec.Name))
+
+	return prefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// OpenValue - decrypts a sealed value of a field. Returns false if the value is not sealed,
+// a redacted value is returned as it is stored because it cannot be decrypted
+func OpenValue(keys KeyProvider, spec FieldSpec, sealed string) ([]byte, bool, error) {
+
+	mode := ""
+	for m, p := range fieldPrefixes {
+		if strings.HasPrefix(sealed, p) {
+			mode = m
+		}
+	}
+
+	if mode == "" {
+		return nil, false, nil
+	}
+
+	if mode == FieldRedact {
+		value, err := json.Marshal(sealed)
+		return value, true, err
+	}
+
+	parts := strings.SplitN(sealed[len(fieldPrefixes[mode]):], "$", 2)
+	if len(parts) != 2 {
+		return nil, true, ErrDecrypt
+	}
+
+	if keys == nil {
+		return nil, true, errNoKey
+	}
+
+	key, err := keys.Key(parts[0])
+	if err != nil {
+		return nil, true, err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, true, ErrDecrypt
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, true, ErrDecrypt
+	}
+
+	value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(spec.Name))
+	if err != nil {
+		return nil, true, ErrDecrypt
+	}
+
+	return value, true, nil
+}
+
+// Sealed - checks if a value of a field is already sealed with a given mode
+func Sealed(spec FieldSpec, value string) bool {
+	return strings.HasPrefix(value, fieldPrefixes[spec.Mode])
+}
+
+// fieldMAC - a keyed hash of a value of a field, it is a nonce of deterministic encryption and a redacted value
+func fieldMAC(key []byte, field string, value []byte) []byte {
+
+	mk := sha256.Sum256(append([]byte("localstore field mac:"), key...))
+
+	h := hmac.New(sha256.New, mk[:])
+	h.Write([]byte(field))
+	h.Write([]byte{0})
+	h.Write(value)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,219 @@
+package localstore
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/przebro/localstore/internal/document"
+)
+
+var (
+	errIndexExists    = errors.New("index already exists")
+	errIndexNotExists = errors.New("index does not exists")
+)
+
+// index - maps values of a field to keys of items holding these values, only scalar values are indexed
+type index struct {
+	field   string
+	entries map[string]map[string]struct{}
+}
+
+// IndexKey - returns a representation of a scalar value used as a key of an index entry,
+// numbers are represented by their value so 1 and 1.0 have the same key. An int is represented
+// by an integer key that holds all numbers with the same integer part
+func IndexKey(v interface{}) (string, bool) {
+
+	switch val := v.(type) {
+	case nil:
+		return "z", true
+	case bool:
+		return "b" + strconv.FormatBool(val), true
+	case string:
+		return "s" + val, true
+	case int:
+		return "i" + strconv.Itoa(val), true
+	}
+
+	if f, ok := document.Number(v); ok {
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+
+	return "", false
+}
+
+// keysOf - returns keys of index entries of an item, a number has a key of its value and a key of its integer part
+func (ix *index) keysOf(item json.RawMessage) []string {
+
+	doc, err := document.DecodeObject(item)
+	if err != nil {
+		return nil
+	}
+
+	v, exists := document.Field(doc, ix.field)
+	if !exists {
+		return nil
+	}
+
+	ikey, ok := IndexKey(v)
+	if !ok {
+		return nil
+	}
+
+	if f, ok := document.Number(v); ok {
+		return []string{ikey, "i" + strconv.Itoa(int(f))}
+	}
+
+	return []string{ikey}
+}
+
+func (ix *index) add(key string, item json.RawMessage) {
+
+	for _, ikey := range ix.keysOf(item) {
+
+		keys, exists := ix.entries[ikey]
+		if !exists {
+			keys = map[string]struct{}{}
+			ix.entries[ikey] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (ix *index) remove(key string, item json.RawMessage) {
+
+	for _, ikey := range ix.keysOf(item) {
+
+		if keys, exists := ix.entries[ikey]; exists {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(ix.entries, ikey)
+			}
+		}
+	}
+}
+
+// CreateIndex - creates an index on a field, nested fields are separated by a dot
+func (s *JsonFileData) CreateIndex(field string) error {
+
+	s.lock.Lock()
+
+	if _, exists := s.indexes[field]; exists {
+		s.lock.Unlock()
+		return errIndexExists
+	}
+
+	ix := &index{field: field, entries: map[string]map[string]struct{}{}}
+	err := s.items.each(func(k string, v json.RawMessage) bool {
+		ix.add(k, v)
+		return true
+	})
+
+	if err != nil {
+		s.lock.Unlock()
+		return err
+	}
+
+	s.indexes[field] = ix
+	s.lock.Unlock()
+
+	return s.alterIndexes()
+}
+
+// DropIndex - removes an index
+func (s *JsonFileData) DropIndex(field string) error {
+
+	s.lock.Lock()
+
+	if _, exists := s.indexes[field]; !exists {
+		s.lock.Unlock()
+		return errIndexNotExists
+	}
+
+	delete(s.indexes, field)
+	s.lock.Unlock()
+
+	return s.alterIndexes()
+}
+
+// alterIndexes - records indexed fields in the catalog
+func (s *JsonFileData) alterIndexes() error {
+
+	fields := s.Indexes()
+
+	return s.alter(func(e *CatalogEntry) { e.Indexes = fields })
+}
+
+// Indexes - returns indexed fields
+func (s *JsonFileData) Indexes() []string {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	fields := []string{}
+	for f := range s.indexes {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// Lookup - returns keys of items with a field equal to a value, returns false if the field is not indexed
+func (s *JsonFileData) Lookup(field string, value interface{}) ([]string, bool) {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	ix, exists := s.indexes[field]
+	if !exists {
+		return nil, false
+	}
+
+	ikey, ok := IndexKey(value)
+	if !ok {
+		return nil, false
+	}
+
+	keys := make([]string, 0, len(ix.entries[ikey]))
+	now := time.Now()
+	for k := range ix.entries[ikey] {
+		if !s.isExpired(k, now) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys, true
+}
+
+// Range - calls fn for every item until fn returns false, if keys is not nil only items with given keys are visited.
+// Expired items are skipped. An error is returned if the body of a paged item can't be read
+func (s *JsonFileData) Range(keys []string, fn func(key string, item json.RawMessage) bool) error {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	now := time.Now()
+
+	if keys == nil {
+		return s.items.each(func(k string, v json.RawMessage) bool {
+			return s.isExpired(k, now) || fn(k, v)
+		})
+	}
+
+	for _, k := range keys {
+		v, exists, err := s.items.get(k)
+		if err != nil {
+			return err
+		}
+		if exists && !s.isExpired(k, now) {
+			if !fn(k, v) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package localstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// formatVersion - a version of the format of collection files. Version 1 is a bare json object with items,
+// since version 2 items are wrapped in an envelope with a header: {"_localstore":{"version":2},"items":{...}}
+const formatVersion = 2
+
+const (
+	headerKey = "_localstore"
+	itemsKey  = "items"
+)
+
+var (
+	errInvalidFormat = errors.New("invalid format of a collection file")
+	errNewerFormat   = errors.New("collection file was written by a newer version")
+)
+
+// header - a header of a collection file. Shards are generations of shard files of a sharded collection by their
+// indexes, the collection file is written after shard files, so it refers only to shards of a complete sync
+type header struct {
+	Version int      `json:"version"`
+	Format  string   `json:"format,omitempty"`
+	Shards  []uint64 `json:"shards,omitempty"`
+}
+
+// writeFile - atomically replaces the content of a file
+func writeFile(path string, data []byte) error {
+
+	return writeStream(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeStream - atomically replaces the content of a file with data written by fn. Data is written to a temporary file
+// which is flushed to the storage and renamed, so a reader never sees a partially written file.
+func writeStream(path string, fn func(w io.Writer) error) error {
+
+	tpath := path + ".tmp"
+
+	f, err := os.OpenFile(tpath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(f); err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tpath)
+		return err
+	}
+
+	if err = os.Rename(tpath, path); err != nil {
+		return err
+	}
+
+	syncDir(filepath.Dir(path))
+
+	return nil
+}
+
+// syncDir - flushes a directory entry, errors are ignored because not every platform supports it
+func syncDir(path string) {
+
+	if d, err := os.Open(path); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// jsonCodec - stores items of a collection as a single json object
+type jsonCodec struct{}
+
+// Format - implements Codec
+func (jsonCodec) Format() string {
+	return FormatJSON
+}
+
+// Decode - implements Codec, a bare json object without a header is read as the version 1
+func (jsonCodec) Decode(r io.Reader, fn func(key string, item json.RawMessage)) (header, error) {
+
+	dec := json.NewDecoder(r)
+
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return header{}, errInvalidFormat
+	}
+
+	h := header{Version: 1}
+	key := ""
+
+	if dec.More() {
+
+		t, err := dec.Token()
+		if err != nil {
+			return header{}, err
+		}
+
+		if key, _ = t.(string); key == headerKey {
+
+			if h, err = readHeader(dec); err != nil {
+				return header{}, err
+			}
+
+			if t, err = dec.Token(); err != nil || t != itemsKey {
+				return header{}, errInvalidFormat
+			}
+
+			if t, err = dec.Token(); err != nil || t != json.Delim('{') {
+				return header{}, errInvalidFormat
+			}
+
+			key = ""
+
+		} else if key == "" {
+			return header{}, errInvalidFormat
+		}
+	}
+
+	for key != "" || dec.More() {
+
+		if key == "" {
+
+			t, err := dec.Token()
+			if err != nil {
+				return header{}, err
+			}
+
+			if key, _ = t.(string); key == "" {
+				return header{}, errInvalidFormat
+			}
+		}
+
+		item := json.RawMessage{}
+		if err := dec.Decode(&item); err != nil {
+			return header{}, err
+		}
+
+		fn(key, item)
+		key = ""
+	}
+
+	if t, err := dec.Token(); err != nil || t != json.Delim('}') {
+		return header{}, errInvalidFormat
+	}
+
+	if h.Version > 1 {
+		if t, err := dec.Token(); err != nil || t != json.Delim('}') {
+			return header{}, errInvalidFormat
+		}
+	}
+
+	return h, nil
+}
+
+// readHeader - reads a header of a collection file, a collection written by a newer version is refused
+func readHeader(dec *json.Decoder) (header, error) {
+
+	h := header{}
+	if err := dec.Decode(&h); err != nil {
+		return header{}, err
+	}
+
+	return h, h.check()
+}
+
+// check - validates a version of a header, a collection written by a newer version is refused
+func (h header) check() error {
+
+	if h.Version > formatVersion {
+		return errNewerFormat
+	}
+
+	if h.Version < 2 {
+		return errInvalidFormat
+	}
+
+	return nil
+}
+
+// Encode - implements Codec
+func (jsonCodec) Encode(w io.Writer, shards []uint64, keys []string, item func(i int) (json.RawMessage, error)) error {
+
+	bw := bufio.NewWriter(w)
+
+	hdr, err := json.Marshal(header{Version: formatVersion, Shards: shards})
+	if err != nil {
+		return err
+	}
+
+	bw.WriteString(`{"` + headerKey + `":`)
+	bw.Write(hdr)
+	bw.WriteString(`,"` + itemsKey + `":{`)
+
+	for i, k := range keys {
+
+		if i != 0 {
+			bw.WriteByte(',')
+		}
+
+		key, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		v, err := item(i)
+		if err != nil {
+			return err
+		}
+
+		bw.Write(key)
+		bw.WriteByte(':')
+		bw.Write(v)
+	}
+
+	bw.WriteString("}}")
+
+	return bw.Flush()
+}
@@ -0,0 +1,483 @@
+package localstore
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// defaultBudget - a number of bytes of items of paged collections kept in memory when a budget is not set
+	defaultBudget int64 = 64 << 20
+	// compactSize - a segment is rewritten when it holds more bytes of replaced items than this and than of live items
+	compactSize int64 = 1 << 20
+)
+
+// itemStore - holds items of a collection, it is used under the lock of a collection
+type itemStore interface {
+	// get - returns an item and true if it exists, an error is returned if a body of an existing item can't be read
+	get(key string) (json.RawMessage, bool, error)
+	put(key string, item json.RawMessage)
+	del(key string)
+	len() int
+	// each - calls fn for every item until fn returns false, items can be removed by fn.
+	// Stops with an error if a body of an item can't be read
+	each(fn func(key string, item json.RawMessage) bool) error
+	// view - captures items with given keys, they can be read without the lock until release is called
+	view(keys []string) (read func(i int) (json.RawMessage, error), release func())
+	close()
+}
+
+// memItems - keeps all items in memory
+type memItems map[string]json.RawMessage
+
+func (m memItems) get(key string) (json.RawMessage, bool, error) {
+	item, exists := m[key]
+	return item, exists, nil
+}
+
+func (m memItems) put(key string, item json.RawMessage) {
+	m[key] = item
+}
+
+func (m memItems) del(key string) {
+	delete(m, key)
+}
+
+func (m memItems) len() int {
+	return len(m)
+}
+
+func (m memItems) each(fn func(key string, item json.RawMessage) bool) error {
+	for k, v := range m {
+		if !fn(k, v) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m memItems) view(keys []string) (func(i int) (json.RawMessage, error), func()) {
+
+	items := make([]json.RawMessage, len(keys))
+	for i, k := range keys {
+		items[i] = m[k]
+	}
+
+	return func(i int) (json.RawMessage, error) { return items[i], nil }, func() {}
+}
+
+func (m memItems) close() {}
+
+// segmentSuffix - a suffix of segment files, <name>.<random>.seg.tmp, a segment file is temporary like files of
+// interrupted writes
+const segmentSuffix = ".seg.tmp"
+
+var (
+	// segments - paths of segment files open in this process, other segment files are left by a crashed process
+	segments     = map[string]bool{}
+	segmentsLock = sync.Mutex{}
+)
+
+// segment - an append-only temporary file with bodies of items of a paged collection, the file is removed
+// when it is released by the collection and by all views
+type segment struct {
+	f    *os.File
+	end  int64
+	refs int32
+}
+
+// newSegment - creates a segment file of a collection next to the collection file, a collection without a path
+// or of a shared reader that doesn't write to the directory of a writer keeps its segment in the temporary directory
+func newSegment(path string, readOnly bool) (*segment, error) {
+
+	dir, pattern := filepath.Dir(path), strings.TrimSuffix(filepath.Base(path), ".json")+".*"+segmentSuffix
+	if path == "" || readOnly {
+		dir, pattern = "", "localstore-*"+segmentSuffix
+	}
+
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentsLock.Lock()
+	segments[f.Name()] = true
+	segmentsLock.Unlock()
+
+	return &segment{f: f, refs: 1}, nil
+}
+
+// openSegment - returns true if a segment file is used by this process
+func openSegment(path string) bool {
+
+	defer segmentsLock.Unlock()
+	segmentsLock.Lock()
+
+	return segments[path]
+}
+
+// removeSegments - removes segment files of a directory left by a crashed process
+func removeSegments(dir string) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+
+		path := filepath.Join(dir, e.Name())
+		if !e.IsDir() && strings.HasSuffix(e.Name(), segmentSuffix) && !openSegment(path) {
+			os.Remove(path)
+		}
+	}
+}
+
+func (g *segment) acquire() {
+	atomic.AddInt32(&g.refs, 1)
+}
+
+func (g *segment) release() {
+
+	if atomic.AddInt32(&g.refs, -1) == 0 {
+		g.f.Close()
+		os.Remove(g.f.Name())
+
+		segmentsLock.Lock()
+		delete(segments, g.f.Name())
+		segmentsLock.Unlock()
+	}
+}
+
+// append - writes data at the end of a segment, must be called under the lock of a collection
+func (g *segment) append(data []byte) (int64, error) {
+
+	off := g.end
+	if _, err := g.f.WriteAt(data, off); err != nil {
+		return 0, err
+	}
+	g.end += int64(len(data))
+
+	return off, nil
+}
+
+// location - a position of an item in a segment, an item that couldn't be written to a segment is kept in memory
+type location struct {
+	off  int64
+	size int32
+	item json.RawMessage
+}
+
+// pagedItems - keeps only keys and locations of items in memory, bodies of items are read from a segment and
+// recently used items are kept in a cache shared by all paged collections of a manager. Bodies of items of an encrypted
+// collection are sealed with a key that is never stored, so a segment left by a crashed process can't be read.
+// An item that can't be read from a segment is reported as missing, a collection file is never written without it
+// because a sync fails
+type pagedItems struct {
+	seg      *segment
+	path     string
+	readOnly bool
+	index    map[string]location
+	keys     KeyProvider
+	seal     bool
+	cache    *pageCache
+	live     int64
+	garbage  int64
+}
+
+func newPagedItems(cache *pageCache, seal bool, path string, readOnly bool) (*pagedItems, error) {
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	keys, err := NewStaticKeys(key)
+	if err != nil {
+		return nil, err
+	}
+
+	seg, err := newSegment(path, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pagedItems{seg: seg, path: path, readOnly: readOnly, index: map[string]location{}, keys: keys, seal: seal, cache: cache}, nil
+}
+
+func (p *pagedItems) get(key string) (json.RawMessage, bool, error) {
+
+	loc, exists := p.index[key]
+	if !exists {
+		return nil, false, nil
+	}
+
+	if item, ok := p.cache.get(p, key); ok {
+		return item, true, nil
+	}
+
+	item, err := read(p.seg, loc, p.keys)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if loc.item == nil {
+		p.cache.put(p, key, item)
+	}
+
+	return item, true, nil
+}
+
+func (p *pagedItems) put(key string, item json.RawMessage) {
+
+	p.drop(key)
+
+	var keys KeyProvider
+	if p.seal {
+		keys = p.keys
+	}
+
+	loc := location{item: item}
+	if data, err := sealData(keys, item); err == nil {
+		if off, err := p.seg.append(data); err == nil {
+			loc = location{off: off, size: int32(len(data))}
+			p.cache.put(p, key, item)
+		}
+	}
+
+	p.index[key] = loc
+	p.live += int64(loc.size)
+	p.compact()
+}
+
+func (p *pagedItems) del(key string) {
+	p.drop(key)
+	p.compact()
+}
+
+// drop - removes an item from the index and the cache, its body becomes garbage
+func (p *pagedItems) drop(key string) {
+
+	if loc, exists := p.index[key]; exists {
+		p.live -= int64(loc.size)
+		p.garbage += int64(loc.size)
+		delete(p.index, key)
+		p.cache.drop(p, key)
+	}
+}
+
+func (p *pagedItems) len() int {
+	return len(p.index)
+}
+
+// each - implements itemStore, items are read without being cached, so a scan doesn't push out hot items
+func (p *pagedItems) each(fn func(key string, item json.RawMessage) bool) error {
+
+	keys := make([]string, 0, len(p.index))
+	for k := range p.index {
+		keys = append(keys, k)
+	}
+
+	for _, k := range keys {
+
+		loc, exists := p.index[k]
+		if !exists {
+			continue
+		}
+
+		item, cached := p.cache.get(p, k)
+		if !cached {
+			var err error
+			if item, err = read(p.seg, loc, p.keys); err != nil {
+				return err
+			}
+		}
+
+		if !fn(k, item) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (p *pagedItems) view(keys []string) (func(i int) (json.RawMessage, error), func()) {
+
+	locs := make([]location, len(keys))
+	for i, k := range keys {
+		locs[i] = p.index[k]
+	}
+
+	seg, ks := p.seg, p.keys
+	seg.acquire()
+
+	return func(i int) (json.RawMessage, error) { return read(seg, locs[i], ks) }, seg.release
+}
+
+func (p *pagedItems) close() {
+	p.cache.dropAll(p)
+	p.seg.release()
+}
+
+// compact - moves live items to a new segment when the current segment holds mostly replaced items
+func (p *pagedItems) compact() {
+
+	if p.garbage < compactSize || p.garbage < p.live {
+		return
+	}
+
+	seg, err := newSegment(p.path, p.readOnly)
+	if err != nil {
+		return
+	}
+
+	index := make(map[string]location, len(p.index))
+	for k, loc := range p.index {
+
+		if loc.item == nil {
+
+			data := make([]byte, loc.size)
+			if _, err = p.seg.f.ReadAt(data, loc.off); err == nil {
+				loc.off, err = seg.append(data)
+			}
+
+			if err != nil {
+				seg.release()
+				return
+			}
+		}
+
+		index[k] = loc
+	}
+
+	p.seg.release()
+	p.seg, p.index, p.garbage = seg, index, 0
+}
+
+// read - reads a body of an item from a segment
+func read(seg *segment, loc location, keys KeyProvider) (json.RawMessage, error) {
+
+	if loc.item != nil {
+		return loc.item, nil
+	}
+
+	data := make([]byte, loc.size)
+	if _, err := seg.f.ReadAt(data, loc.off); err != nil {
+		return nil, err
+	}
+
+	return openData(keys, data)
+}
+
+// pageCache - keeps recently used items of paged collections up to a budget of bytes
+type pageCache struct {
+	lock    sync.Mutex
+	budget  int64
+	used    int64
+	lru     *list.List
+	entries map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	owner *pagedItems
+	key   string
+}
+
+type cached struct {
+	id   cacheKey
+	item json.RawMessage
+}
+
+func newPageCache(budget int64) *pageCache {
+	return &pageCache{budget: budget, lru: list.New(), entries: map[cacheKey]*list.Element{}}
+}
+
+func (c *pageCache) get(owner *pagedItems, key string) (json.RawMessage, bool) {
+
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	e, exists := c.entries[cacheKey{owner, key}]
+	if !exists {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*cached).item, true
+}
+
+func (c *pageCache) put(owner *pagedItems, key string, item json.RawMessage) {
+
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	id := cacheKey{owner, key}
+	if e, exists := c.entries[id]; exists {
+		c.remove(e)
+	}
+
+	if int64(len(item)) > c.budget {
+		return
+	}
+
+	c.entries[id] = c.lru.PushFront(&cached{id: id, item: item})
+	c.used += int64(len(item))
+	c.shrink()
+}
+
+func (c *pageCache) drop(owner *pagedItems, key string) {
+
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	if e, exists := c.entries[cacheKey{owner, key}]; exists {
+		c.remove(e)
+	}
+}
+
+// dropAll - removes all items of a collection
+func (c *pageCache) dropAll(owner *pagedItems) {
+
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cached).id.owner == owner {
+			c.remove(e)
+		}
+		e = next
+	}
+}
+
+// setBudget - changes a budget, least recently used items are removed until the cache fits in it
+func (c *pageCache) setBudget(budget int64) {
+
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	c.budget = budget
+	c.shrink()
+}
+
+// shrink - removes least recently used items until the cache fits in its budget, must be called under the lock
+func (c *pageCache) shrink() {
+	for c.used > c.budget && c.lru.Len() != 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove - removes an entry, must be called under the lock
+func (c *pageCache) remove(e *list.Element) {
+
+	v := c.lru.Remove(e).(*cached)
+	delete(c.entries, v.id)
+	c.used -= int64(len(v.item))
+}
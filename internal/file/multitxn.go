@@ -0,0 +1,364 @@
+package localstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	recordPrefix = "_txn_"
+	recordSuffix = ".json"
+)
+
+var (
+	errTxnShared = errors.New("transaction is a part of a multi-collection transaction")
+	errNoTxn     = errors.New("collection is not a part of the transaction")
+	txnSequence  uint64
+)
+
+// MultiTxn - a transaction spanning several collections of the same manager
+type MultiTxn struct {
+	manager *jsonFileManager
+	names   []string
+	txns    map[string]*Txn
+	closed  bool
+}
+
+// commitRecord - final state of all keys changed by a transaction, a nil item means that the key was removed.
+// Seqs holds the sequence number of the last change of every collection after the transaction was applied,
+// a key changed after it is not replayed
+type commitRecord struct {
+	ID          string                                `json:"id"`
+	Collections map[string]map[string]json.RawMessage `json:"collections"`
+	Seqs        map[string]uint64                     `json:"seqs,omitempty"`
+}
+
+// Begin - starts a transaction over collections with given names, collections that are not loaded yet are loaded
+func (cm *jsonFileManager) Begin(names []string, tm int, updatesync bool) (*MultiTxn, error) {
+
+	if err := cm.writable(); err != nil {
+		return nil, err
+	}
+
+	mt := &MultiTxn{manager: cm, names: []string{}, txns: map[string]*Txn{}}
+
+	for _, name := range names {
+
+		if _, exists := mt.txns[name]; exists {
+			continue
+		}
+
+		data, err := cm.GetData(name, tm, updatesync)
+		if err != nil {
+			return nil, err
+		}
+
+		txn := data.Begin()
+		txn.multi = mt
+		mt.txns[name] = txn
+		mt.names = append(mt.names, name)
+	}
+
+	//collections are always locked in the same order to avoid deadlocks between concurrent transactions
+	sort.Strings(mt.names)
+
+	return mt, nil
+}
+
+// Txn - returns a part of the transaction that belongs to a collection with a given name
+func (mt *MultiTxn) Txn(name string) (*Txn, error) {
+
+	txn, exists := mt.txns[name]
+	if !exists {
+		return nil, errNoTxn
+	}
+
+	return txn, nil
+}
+
+// Commit - validates and applies changes to all collections. Before collections are changed, a commit record
+// is written to the disk, if the process stops before all collections are written, the record is replayed on the next start.
+func (mt *MultiTxn) Commit() error {
+
+	if mt.closed {
+		return errTxnClosed
+	}
+	mt.closed = true
+
+	for _, name := range mt.names {
+		mt.txns[name].closed = true
+		mt.txns[name].data.lock.Lock()
+	}
+
+	unlock := func() {
+		for i := len(mt.names) - 1; i >= 0; i-- {
+			mt.txns[mt.names[i]].data.lock.Unlock()
+		}
+	}
+
+	for _, name := range mt.names {
+		if err := mt.txns[name].validate(); err != nil {
+			unlock()
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	record := commitRecord{
+		ID:          fmt.Sprintf("%x%x", time.Now().UnixNano(), atomic.AddUint64(&txnSequence, 1)),
+		Collections: map[string]map[string]json.RawMessage{},
+	}
+
+	for _, name := range mt.names {
+		record.Collections[name] = mt.txns[name].staged
+	}
+
+	//a record of a commit in progress is skipped by Recover of the same manager
+	mt.manager.hold(record.ID)
+	defer mt.manager.release(record.ID)
+
+	rpath, err := mt.manager.writeRecord(record)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	record.Seqs = map[string]uint64{}
+	for _, name := range mt.names {
+		mt.txns[name].apply()
+		record.Seqs[name] = mt.txns[name].data.change
+	}
+
+	//the record is written again with sequence numbers of applied changes, without them the whole record is replayed
+	_, rerr := mt.manager.writeRecord(record)
+
+	unlock()
+
+	for _, name := range mt.names {
+		if err := mt.txns[name].data.flush(); err != nil {
+			return err
+		}
+	}
+
+	//a record that could not be rewritten must not outlive the commit, a replay would overwrite later changes
+	if err := os.Remove(rpath); err != nil {
+		return err
+	}
+
+	return rerr
+}
+
+// hold - marks a commit record as written by a live commit
+func (cm *jsonFileManager) hold(id string) {
+
+	defer cm.recordLock.Unlock()
+	cm.recordLock.Lock()
+
+	if cm.records == nil {
+		cm.records = map[string]bool{}
+	}
+	cm.records[id] = true
+}
+
+// release - removes a mark set by hold
+func (cm *jsonFileManager) release(id string) {
+
+	defer cm.recordLock.Unlock()
+	cm.recordLock.Lock()
+
+	delete(cm.records, id)
+}
+
+// held - checks if a commit record belongs to a live commit
+func (cm *jsonFileManager) held(name string) bool {
+
+	defer cm.recordLock.Unlock()
+	cm.recordLock.Lock()
+
+	return cm.records[strings.TrimSuffix(strings.TrimPrefix(name, recordPrefix), recordSuffix)]
+}
+
+// Rollback - discards changes in all collections
+func (mt *MultiTxn) Rollback() error {
+
+	if mt.closed {
+		return errTxnClosed
+	}
+	mt.closed = true
+
+	for _, txn := range mt.txns {
+		txn.closed = true
+	}
+
+	return nil
+}
+
+// writeRecord - writes a commit record, a record with the same id is replaced
+func (cm *jsonFileManager) writeRecord(record commitRecord) (string, error) {
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	if data, err = sealData(cm.keys, data); err != nil {
+		return "", err
+	}
+
+	rpath := filepath.Join(cm.path, recordPrefix+record.ID+recordSuffix)
+
+	return rpath, writeFile(rpath, data)
+}
+
+// Recover - replays commit records of transactions that were interrupted before all collections had been written,
+// a shared reader leaves them to a writer. Records are replayed once per manager, records of live commits are skipped
+func (cm *jsonFileManager) Recover() error {
+
+	if cm.readOnly {
+		return nil
+	}
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	if cm.recovered {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(cm.path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+
+		if e.IsDir() || !strings.HasPrefix(e.Name(), recordPrefix) || !strings.HasSuffix(e.Name(), recordSuffix) {
+			continue
+		}
+
+		if cm.held(e.Name()) {
+			continue
+		}
+
+		rpath := filepath.Join(cm.path, e.Name())
+		if err := cm.replay(rpath); err != nil {
+			return fmt.Errorf("unable to recover transaction %s: %w", e.Name(), err)
+		}
+	}
+
+	cm.recovered = true
+
+	return nil
+}
+
+// replay - applies a commit record to collection files, it can be safely repeated because the record holds final values.
+// Keys changed after the transaction was applied are skipped, so later changes are not overwritten
+func (cm *jsonFileManager) replay(rpath string) error {
+
+	data, err := ioutil.ReadFile(rpath)
+	if err != nil {
+		return err
+	}
+
+	if data, err = openData(cm.keys, data); err != nil {
+		return err
+	}
+
+	record := commitRecord{}
+	if err = json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	for name, changes := range record.Collections {
+
+		seq, known := record.Seqs[name]
+
+		if s, loaded := cm.m[name]; loaded {
+			changes = s.unchanged(changes, seq, known)
+			s.lock.Lock()
+			s.apply(changes)
+			s.lock.Unlock()
+
+			if err = s.flush(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, err := cm.loadFile(name)
+		defer s.items.close()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err = s.loadLog(); err != nil {
+			return err
+		}
+
+		changes = s.unchanged(changes, seq, known)
+		s.lock.Lock()
+		s.apply(changes)
+		s.lock.Unlock()
+
+		if err = s.flush(); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(rpath)
+}
+
+// apply - applies changes recorded in a commit record, must be called under the lock
+func (s *JsonFileData) apply(changes map[string]json.RawMessage) {
+
+	for k, v := range changes {
+		if isRemoved(v) {
+			s.remove(k)
+			continue
+		}
+		s.set(k, v)
+	}
+}
+
+// unchanged - returns changes of a commit record without keys changed after a given sequence number,
+// if the sequence number is not known all changes are returned
+func (s *JsonFileData) unchanged(changes map[string]json.RawMessage, seq uint64, known bool) map[string]json.RawMessage {
+
+	if !known {
+		return changes
+	}
+
+	logged, _ := s.readLog()
+
+	s.lock.RLock()
+	moved := map[string]bool{}
+	for _, source := range [][]Change{logged, s.ring, s.pending} {
+		for _, c := range source {
+			if c.Seq > seq {
+				moved[c.Key] = true
+			}
+		}
+	}
+	s.lock.RUnlock()
+
+	result := map[string]json.RawMessage{}
+	for k, v := range changes {
+		if !moved[k] {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+// isRemoved - checks if a change recorded in a commit record is a removal
+func isRemoved(item json.RawMessage) bool {
+	return item == nil || string(item) == "null"
+}
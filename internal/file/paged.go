@@ -0,0 +1,135 @@
+package localstore
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// SetMemory - sets a number of bytes of items of paged collections kept in memory by the manager, least recently
+// used items are dropped from memory when the budget is exceeded. Zero restores the default budget
+func (cm *jsonFileManager) SetMemory(budget int64) {
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	cm.setMemory(budget)
+}
+
+// setMemory - sets a memory budget of the manager, must be called under the manager lock
+func (cm *jsonFileManager) setMemory(budget int64) {
+
+	cm.budget = memoryBudget(budget)
+	if cm.cache != nil {
+		cm.cache.setBudget(cm.budget)
+	}
+}
+
+// memoryBudget - returns a budget that is used for a given budget, zero means the default budget
+func memoryBudget(budget int64) int64 {
+
+	if budget <= 0 {
+		return defaultBudget
+	}
+
+	return budget
+}
+
+// pageCache - returns a cache shared by paged collections of the manager, must be called under the manager lock
+func (cm *jsonFileManager) pageCache() *pageCache {
+
+	if cm.cache == nil {
+
+		cm.cache = newPageCache(memoryBudget(cm.budget))
+	}
+
+	return cm.cache
+}
+
+// page - makes a collection that is not loaded yet keep bodies of items in a segment file, so items are moved
+// to the segment while the collection file is read and the collection is never held in memory as a whole
+func (s *JsonFileData) page() error {
+
+	p, err := newPagedItems(s.cache, s.keys != nil, s.path, s.readOnly)
+	if err != nil {
+		return err
+	}
+
+	s.items = p
+
+	return nil
+}
+
+// loadFile - loads a collection that is not open to rewrite it, a paged collection is loaded into a segment file.
+// Must be called under the manager lock, items of the returned collection must be closed even if loading fails
+func (cm *jsonFileManager) loadFile(name string) (*JsonFileData, error) {
+
+	s := initialize(filepath.Join(cm.path, name+".json"), 0, false)
+	s.keys = cm.keys
+	s.cache = cm.pageCache()
+
+	e, _ := cm.Catalog(name)
+	s.shards = e.Shards
+
+	if e.Paged {
+		if err := s.page(); err != nil {
+			return s, err
+		}
+	}
+
+	return s, s.load()
+}
+
+// SetPaged - switches a collection between keeping all items in memory and keeping only keys in memory with bodies
+// of items in a temporary segment file, a paged collection keeps recently used items in a cache of the manager.
+// A collection that doesn't belong to a manager gets its own cache with the default budget
+func (s *JsonFileData) SetPaged(paged bool) error {
+
+	s.lock.Lock()
+
+	if _, current := s.items.(*pagedItems); current == paged {
+		s.lock.Unlock()
+		return s.alter(func(e *CatalogEntry) { e.Paged = paged })
+	}
+
+	if s.cache == nil {
+		s.cache = newPageCache(defaultBudget)
+	}
+
+	var items itemStore = memItems{}
+	if paged {
+		p, err := newPagedItems(s.cache, s.keys != nil, s.path, s.readOnly)
+		if err != nil {
+			s.lock.Unlock()
+			return err
+		}
+		items = p
+	}
+
+	err := s.items.each(func(key string, item json.RawMessage) bool {
+		items.put(key, item)
+		return true
+	})
+
+	if err != nil {
+		items.close()
+		s.lock.Unlock()
+		return err
+	}
+
+	s.items.close()
+	s.items = items
+	s.lock.Unlock()
+
+	return s.alter(func(e *CatalogEntry) { e.Paged = paged })
+}
+
+// Paged - returns true if bodies of items of a collection are kept on disk
+func (s *JsonFileData) Paged() bool {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	_, paged := s.items.(*pagedItems)
+
+	return paged
+}
@@ -0,0 +1,58 @@
+package localstore
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrReadOnly - returned when a collection opened by a shared reader is changed
+var ErrReadOnly = errors.New("collection is opened by a shared reader and can't be changed")
+
+var (
+	readers     = map[string]FileManager{}
+	readersLock = sync.Mutex{}
+)
+
+// GetSharedReader - gets a manager that reads collections of a directory owned by a writer in another process.
+// Collections are never written, a collection is reloaded as a whole when the writer replaces its file,
+// so a reader always sees a state of a collection written by a single sync of the writer
+func GetSharedReader(path string) FileManager {
+
+	defer readersLock.Unlock()
+	readersLock.Lock()
+
+	if m, exists := readers[path]; exists {
+		return m
+	}
+
+	m := &jsonFileManager{path: path, m: map[string]*JsonFileData{}, lock: sync.Mutex{}, readOnly: true}
+	m.SetExternal(ExternalReload)
+	readers[path] = m
+
+	return m
+}
+
+// ReadOnly - returns true if the collection is opened by a shared reader
+func (s *JsonFileData) ReadOnly() bool {
+	return s.readOnly
+}
+
+// writable - returns ErrReadOnly if the manager is a shared reader
+func (cm *jsonFileManager) writable() error {
+
+	if cm.readOnly {
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+// writable - returns ErrReadOnly if the collection is opened by a shared reader
+func (s *JsonFileData) writable() error {
+
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	return nil
+}
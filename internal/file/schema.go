@@ -0,0 +1,156 @@
+package localstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/przebro/localstore/internal/schema"
+)
+
+// schemaSuffix - a suffix of a file with a schema of a collection
+const schemaSuffix = ".schema.json"
+
+// SetSchema - sets a JSON Schema that every written item must match and stores it next to the collection file,
+// an empty schema removes it. Items already stored in the collection are not checked.
+func (s *JsonFileData) SetSchema(data []byte) error {
+
+	if len(data) == 0 {
+
+		if err := os.Remove(s.schemaPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		s.lock.Lock()
+		s.schema, s.schemaData = nil, nil
+		s.lock.Unlock()
+
+		return s.alter(func(e *CatalogEntry) { e.Schema = "" })
+	}
+
+	sch, err := schema.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	if err = writeFile(s.schemaPath(), data); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.schema, s.schemaData = sch, append([]byte{}, data...)
+	s.lock.Unlock()
+
+	return s.alter(func(e *CatalogEntry) { e.Schema = filepath.Base(s.schemaPath()) })
+}
+
+// Schema - returns a schema of a collection or nil if it is not set
+func (s *JsonFileData) Schema() []byte {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.schemaData
+}
+
+// Validate - checks all items against the schema of a collection, returns errors for items that don't match it
+// in the order of insertion. If open is not nil, items are passed through it before they are checked
+func (s *JsonFileData) Validate(open func(item json.RawMessage) (json.RawMessage, error)) []*KeyError {
+
+	s.lock.RLock()
+	sch := s.schema
+	keys := s.ordered()
+	item, release := s.items.view(keys)
+	s.lock.RUnlock()
+
+	defer release()
+
+	errs := []*KeyError{}
+	if sch == nil {
+		return errs
+	}
+
+	for i, key := range keys {
+
+		v, err := item(i)
+		if err == nil && open != nil {
+			v, err = open(v)
+		}
+		if err == nil {
+			err = sch.Validate(v)
+		}
+		if err != nil {
+			errs = append(errs, &KeyError{Key: key, Err: err})
+		}
+	}
+
+	return errs
+}
+
+// check - checks if an item can be written: the collection is not opened by a shared reader and the item
+// conforms to the schema of the collection, must be called under the lock. The item is stamped with the default
+// time to live before it is validated, the stamped item is returned. Items of a collection with encrypted fields
+// are checked with SchemaCheck before their fields are sealed
+func (s *JsonFileData) check(item json.RawMessage) (json.RawMessage, error) {
+
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+
+	item = s.stamp(item)
+
+	if s.schema == nil || len(s.fields) != 0 {
+		return item, nil
+	}
+
+	return item, s.schema.Validate(item)
+}
+
+// SchemaCheck - returns a function that checks if an item conforms to the current schema of the collection,
+// the function doesn't take the lock so it can be called by a function that modifies an item
+func (s *JsonFileData) SchemaCheck() func(item json.RawMessage) error {
+
+	s.lock.RLock()
+	sch := s.schema
+	s.lock.RUnlock()
+
+	return func(item json.RawMessage) error {
+
+		if sch == nil {
+			return nil
+		}
+
+		return sch.Validate(item)
+	}
+}
+
+// schemaPath - returns a path of a file with a schema
+func (s *JsonFileData) schemaPath() string {
+	return strings.TrimSuffix(s.path, ".json") + schemaSuffix
+}
+
+// loadSchema - loads a schema stored next to the collection file
+func (s *JsonFileData) loadSchema() error {
+
+	data, err := ioutil.ReadFile(s.schemaPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sch, err := schema.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	defer s.lock.Unlock()
+	s.lock.Lock()
+
+	s.schema, s.schemaData = sch, data
+
+	return nil
+}
@@ -0,0 +1,647 @@
+package localstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// shardSuffix - a suffix of a shard file of a collection, shards are stored as <name>.<index>.<generation>.shard
+const shardSuffix = ".shard"
+
+// maxShards - the highest number of shards of a collection
+const maxShards = 1000
+
+var (
+	errInvalidShards = errors.New("invalid sharding of a collection")
+	errShardedCap    = errors.New("a sharded collection can't be capped")
+)
+
+// Sharding - splits items of a collection into shard files, by a hash of a key into Count shards or by ranges of keys
+// when Bounds are given. Bounds are sorted keys that start every shard but the first one. A zero Sharding means
+// that all items are stored in the collection file
+type Sharding struct {
+	Count  int      `json:"count,omitempty"`
+	Bounds []string `json:"bounds,omitempty"`
+}
+
+// ValidSharding - checks if a sharding is either by hash or by range
+func ValidSharding(sh Sharding) error {
+	return sh.validate()
+}
+
+// validate - checks if a sharding is either by hash or by range
+func (sh Sharding) validate() error {
+
+	switch {
+	case sh.Count == 0 && len(sh.Bounds) == 0:
+		return nil
+	case sh.Count != 0 && len(sh.Bounds) != 0:
+		return fmt.Errorf("%w: count and bounds are exclusive", errInvalidShards)
+	case sh.Count < 0 || sh.Count == 1 || sh.Count > maxShards || len(sh.Bounds) >= maxShards:
+		return fmt.Errorf("%w: 2 to %d shards are allowed", errInvalidShards, maxShards)
+	}
+
+	for i, b := range sh.Bounds {
+		if b == "" || (i != 0 && sh.Bounds[i-1] >= b) {
+			return fmt.Errorf("%w: bounds must be sorted unique keys", errInvalidShards)
+		}
+	}
+
+	return nil
+}
+
+// shards - returns a number of shards
+func (sh *Sharding) shards() int {
+
+	if sh == nil {
+		return 0
+	}
+
+	if len(sh.Bounds) != 0 {
+		return len(sh.Bounds) + 1
+	}
+
+	return sh.Count
+}
+
+// of - returns an index of a shard that holds a key
+func (sh *Sharding) of(key string) int {
+
+	if len(sh.Bounds) != 0 {
+		return sort.Search(len(sh.Bounds), func(i int) bool { return sh.Bounds[i] > key })
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(sh.Count))
+}
+
+// equal - checks if two shardings place keys in the same shards
+func (sh *Sharding) equal(o *Sharding) bool {
+
+	if sh.shards() != o.shards() {
+		return false
+	}
+
+	if sh == nil {
+		return true
+	}
+
+	if sh.Count != o.Count || len(sh.Bounds) != len(o.Bounds) {
+		return false
+	}
+
+	for i := range sh.Bounds {
+		if sh.Bounds[i] != o.Bounds[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetShards - splits a collection into shard files or with a zero Sharding stores it in a single file again,
+// the collection is written immediately. Only shards with changed items are written by a sync, under a new generation
+// of their files. The collection file holds no items, it records generations of shard files and is written after them,
+// so a sync is complete when the collection file is replaced and files of an interrupted sync are never read.
+// A sharded collection can't be capped, because the order of insertion is not kept between shards
+func (s *JsonFileData) SetShards(sh Sharding) error {
+
+	if err := sh.validate(); err != nil {
+		return err
+	}
+
+	var spec *Sharding
+	if sh.shards() != 0 {
+		spec = &Sharding{Count: sh.Count, Bounds: append([]string{}, sh.Bounds...)}
+	}
+
+	s.flushLock.Lock()
+	s.lock.Lock()
+
+	if spec != nil && (s.maxDocs > 0 || s.maxBytes > 0) {
+		s.lock.Unlock()
+		s.flushLock.Unlock()
+		return errShardedCap
+	}
+
+	changed := !spec.equal(s.shards)
+	if changed {
+		s.shards = spec
+		s.reshard = true
+		s.touchAll()
+	}
+
+	s.lock.Unlock()
+	s.flushLock.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	return s.alter(func(e *CatalogEntry) { e.Shards = spec })
+}
+
+// Shards - returns a sharding of a collection
+func (s *JsonFileData) Shards() Sharding {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	if s.shards == nil {
+		return Sharding{}
+	}
+
+	return Sharding{Count: s.shards.Count, Bounds: append([]string{}, s.shards.Bounds...)}
+}
+
+// touch - marks a shard of a key as changed, must be called under the lock
+func (s *JsonFileData) touch(key string) {
+
+	if s.shards != nil && !s.loading {
+		s.dirty[s.shards.of(key)] = true
+	}
+}
+
+// touchAll - marks all shards as changed, so the whole collection is written by the next sync. Must be called under the lock
+func (s *JsonFileData) touchAll() {
+
+	for i := 0; i < s.shards.shards(); i++ {
+		s.dirty[i] = true
+	}
+}
+
+// copySnapshot - captures a copy of a collection without changing its state, must be called under the lock.
+// Items of a sharded collection are captured by shards in parts of the snapshot, they are recorded under
+// the generation 1 and released with the snapshot
+func (s *JsonFileData) copySnapshot() snapshot {
+
+	if s.shards == nil {
+		return s.snapshot()
+	}
+
+	keys := map[int][]string{}
+	for _, k := range s.ordered() {
+		i := s.shards.of(k)
+		keys[i] = append(keys[i], k)
+	}
+
+	sn := s.snapshotOf([]string{})
+	sn.shards = make([]uint64, s.shards.shards())
+	sn.parts = map[int]snapshot{}
+
+	for i := range sn.shards {
+		sn.shards[i] = 1
+		sn.parts[i] = s.snapshotOf(keys[i])
+	}
+
+	release := sn.release
+	sn.release = func() {
+		for _, part := range sn.parts {
+			part.release()
+		}
+		release()
+	}
+
+	return sn
+}
+
+// writeCopy - writes a copy captured by copySnapshot to a collection file and its shard files
+func (sn snapshot) writeCopy(path string) error {
+
+	for i, part := range sn.parts {
+		if err := writeChecked(shardPath(path, i, sn.shards[i]), part.encode); err != nil {
+			return err
+		}
+	}
+
+	return writeChecked(path, sn.encode)
+}
+
+// fileSnapshots - captures a content of the collection file and of changed shards, must be called under the lock.
+// Changed shards are written under a new generation that is recorded in the collection file with generations
+// of other shards, a shard that has no file yet is written as well
+func (s *JsonFileData) fileSnapshots() (snapshot, map[int]snapshot) {
+
+	if s.shards == nil {
+		return s.snapshot(), nil
+	}
+
+	s.generation++
+
+	gens := make([]uint64, s.shards.shards())
+	copy(gens, s.gens)
+	for i, g := range gens {
+		if g == 0 {
+			s.dirty[i] = true
+		}
+	}
+
+	keys := map[int][]string{}
+	for _, k := range s.ordered() {
+		if i := s.shards.of(k); s.dirty[i] {
+			keys[i] = append(keys[i], k)
+		}
+	}
+
+	snaps := map[int]snapshot{}
+	for i := range s.dirty {
+		snaps[i] = s.snapshotOf(keys[i])
+		gens[i] = s.generation
+	}
+	s.dirty = map[int]bool{}
+
+	sn := s.snapshotOf([]string{})
+	sn.shards = gens
+
+	return sn, snaps
+}
+
+// writeShards - writes shard files with given generations in parallel and releases their snapshots. If any of them
+// is not written, written files are removed and all shards are marked as changed again
+func (s *JsonFileData) writeShards(snaps map[int]snapshot, gens []uint64) error {
+
+	errs := make(map[int]error, len(snaps))
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for i, sn := range snaps {
+
+		wg.Add(1)
+		go func(i int, sn snapshot) {
+			defer wg.Done()
+
+			err := writeChecked(shardPath(s.path, i, gens[i]), sn.encode)
+			sn.release()
+
+			lock.Lock()
+			errs[i] = err
+			lock.Unlock()
+		}(i, sn)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			s.discardShards(snaps, gens)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discardShards - removes shard files of a sync that was not completed and marks their shards as changed again
+func (s *JsonFileData) discardShards(snaps map[int]snapshot, gens []uint64) {
+
+	s.lock.Lock()
+	for i := range snaps {
+		s.dirty[i] = true
+	}
+	s.lock.Unlock()
+
+	for i := range snaps {
+		removeShard(shardPath(s.path, i, gens[i]))
+	}
+}
+
+// removeStale - records generations of shard files the collection file refers to and removes shard files replaced
+// by the sync, after a collection is resharded all other shard files are removed. Must be called under the flush lock
+// after the collection file is written
+func (s *JsonFileData) removeStale(gens []uint64) {
+
+	s.lock.Lock()
+	old := s.gens
+	s.gens = gens
+	reshard := s.reshard
+	s.reshard = false
+	s.lock.Unlock()
+
+	stale := []string{}
+
+	if reshard {
+		current := map[string]bool{}
+		for i, g := range gens {
+			current[shardPath(s.path, i, g)] = true
+		}
+
+		files, _ := allShardFiles(s.path)
+		for _, fpath := range files {
+			if !current[fpath] {
+				stale = append(stale, fpath)
+			}
+		}
+	} else {
+		for i, g := range old {
+			if i < len(gens) && gens[i] != g {
+				stale = append(stale, shardPath(s.path, i, g))
+			}
+		}
+	}
+
+	for _, fpath := range stale {
+		removeShard(fpath)
+	}
+
+	if len(stale) != 0 {
+		syncDir(filepath.Dir(s.path))
+	}
+}
+
+// removeShard - removes a shard file with its checksums
+func removeShard(path string) {
+	os.Remove(path)
+	os.Remove(path + sumSuffix)
+}
+
+// shardPath - returns a path of a shard file with a given generation, shard files written before generations
+// were introduced have no generation in their names
+func shardPath(path string, i int, gen uint64) string {
+
+	if gen == 0 {
+		return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(path, ".json"), i, shardSuffix)
+	}
+
+	return fmt.Sprintf("%s.%03d.%d%s", strings.TrimSuffix(path, ".json"), i, gen, shardSuffix)
+}
+
+// shardFiles - returns paths of shard files of a collection file by their indexes, gens are generations recorded
+// in the collection file. Without generations, shard files written before generations were introduced are returned
+func shardFiles(path string, gens []uint64) (map[int]string, error) {
+
+	files := map[int]string{}
+
+	if gens != nil {
+		for i, g := range gens {
+			files[i] = shardPath(path, i, g)
+		}
+		return files, nil
+	}
+
+	all, err := allShardFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fpath := range all {
+		if i, gen, _ := parseShard(path, fpath); gen == 0 {
+			files[i] = fpath
+		}
+	}
+
+	return files, nil
+}
+
+// allShardFiles - returns paths of all shard files of a collection file, of every generation
+func allShardFiles(path string) ([]string, error) {
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+
+	for _, e := range entries {
+
+		fpath := filepath.Join(filepath.Dir(path), e.Name())
+		if _, _, ok := parseShard(path, fpath); ok && !e.IsDir() {
+			files = append(files, fpath)
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// parseShard - returns an index and a generation of a shard file of a collection file
+func parseShard(path, fpath string) (int, uint64, bool) {
+
+	prefix := strings.TrimSuffix(filepath.Base(path), ".json") + "."
+	name := filepath.Base(fpath)
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, shardSuffix) {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, prefix), shardSuffix), ".")
+	if len(parts) > 2 {
+		return 0, 0, false
+	}
+
+	i, err := strconv.Atoi(parts[0])
+	if err != nil || i < 0 {
+		return 0, 0, false
+	}
+
+	var gen uint64
+	if len(parts) == 2 {
+		if gen, err = strconv.ParseUint(parts[1], 10, 64); err != nil || gen == 0 {
+			return 0, 0, false
+		}
+	}
+
+	return i, gen, true
+}
+
+// committedShards - returns shard files a collection file refers to, the collection file is read only if
+// there are any shard files
+func committedShards(path string, keys KeyProvider) (map[int]string, error) {
+
+	if all, err := allShardFiles(path); err != nil || len(all) == 0 {
+		return map[int]string{}, err
+	}
+
+	_, _, h, err := scanFile(path, keys, func(key string, item json.RawMessage) {})
+	if err != nil {
+		return nil, err
+	}
+
+	return shardFiles(path, h.Shards)
+}
+
+// shardOwner - returns a name of a collection that owns a shard file
+func shardOwner(name string) string {
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// loaded - an item read from a file of a collection, file is an index of a shard or -1 for the collection file
+type loaded struct {
+	key  string
+	item json.RawMessage
+	file int
+}
+
+// loadShards - loads items from the collection file and then from shard files it refers to read in parallel,
+// must be called under the lock. Shard files of a sync that was not completed are not read. An item found in a file
+// of another shard is kept only if its own shard doesn't hold it and both shards are written by the next sync,
+// so a collection restored from a single file or resharded is written again
+func (s *JsonFileData) loadShards() (Codec, string, error) {
+
+	ch := make(chan loaded, 1024)
+
+	var codec Codec
+	var compression string
+	var gens []uint64
+	var files map[int]string
+	var err error
+
+	lock := sync.Mutex{}
+	fail := func(e error) {
+		lock.Lock()
+		if err == nil {
+			err = e
+		}
+		lock.Unlock()
+	}
+
+	go func() {
+		defer close(ch)
+
+		c, comp, h, e := scanFile(s.path, s.keys, func(key string, item json.RawMessage) {
+			ch <- loaded{key: key, item: item, file: -1}
+		})
+		if e != nil {
+			fail(e)
+			return
+		}
+
+		codec, compression, gens = c, comp, h.Shards
+		if files, e = shardFiles(s.path, gens); e != nil {
+			fail(e)
+			return
+		}
+
+		wg := sync.WaitGroup{}
+		for i, fpath := range files {
+			wg.Add(1)
+			go func(fpath string, i int) {
+				defer wg.Done()
+				_, _, _, e := scanFile(fpath, s.keys, func(key string, item json.RawMessage) {
+					ch <- loaded{key: key, item: item, file: i}
+				})
+				if e != nil {
+					fail(e)
+				}
+			}(fpath, i)
+		}
+
+		wg.Wait()
+	}()
+
+	count := s.shards.shards()
+	misplaced := map[string]bool{}
+
+	for l := range ch {
+
+		own := -1
+		if s.shards != nil {
+			own = s.shards.of(l.key)
+		}
+
+		if l.file == own {
+			delete(misplaced, l.key)
+			s.set(l.key, l.item)
+			continue
+		}
+
+		if l.file >= count {
+			s.reshard = true
+		}
+		if l.file >= 0 && l.file < count {
+			s.dirty[l.file] = true
+		}
+		if own >= 0 {
+			s.dirty[own] = true
+		}
+
+		if _, exists := s.seqs[l.key]; exists && !misplaced[l.key] {
+			continue
+		}
+
+		misplaced[l.key] = true
+		s.set(l.key, l.item)
+	}
+
+	for i := range files {
+		if i >= count {
+			s.reshard = true
+		}
+	}
+
+	if gens == nil && len(files) != 0 {
+		s.reshard = true
+		s.touchAll()
+	}
+
+	s.gens = gens
+	for _, g := range gens {
+		if g > s.generation {
+			s.generation = g
+		}
+	}
+
+	return codec, compression, err
+}
+
+// scanCollection - reads items of a collection file and of shard files it refers to, an item stored in more than
+// one file is taken from the last file that holds it
+func scanCollection(path string, keys KeyProvider) ([]string, map[string]json.RawMessage, error) {
+
+	order := []string{}
+	items := map[string]json.RawMessage{}
+	add := func(key string, item json.RawMessage) {
+		if _, exists := items[key]; !exists {
+			order = append(order, key)
+		}
+		items[key] = item
+	}
+
+	_, _, h, err := scanFile(path, keys, add)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := shardFiles(path, h.Shards)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexes := make([]int, 0, len(files))
+	for i := range files {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		if _, _, _, err = scanFile(files[i], keys, add); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return order, items, nil
+}
+
+// sharding - returns a sharding of a collection recorded in the catalog
+func (cm *jsonFileManager) sharding(name string) *Sharding {
+
+	if e, exists := cm.Catalog(name); exists {
+		return e.Shards
+	}
+
+	return nil
+}
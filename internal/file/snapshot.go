@@ -0,0 +1,442 @@
+package localstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotsDir - a directory with named snapshots, every snapshot is a directory with collection files and a description
+	snapshotsDir = "_snapshots"
+	snapshotMeta = "_snapshot.json"
+)
+
+var (
+	errSnapshotExists   = errors.New("snapshot already exists")
+	errSnapshotNotFound = errors.New("snapshot not found")
+	errInvalidSnapshot  = errors.New("invalid name of a snapshot")
+	errNotInSnapshot    = errors.New("collection is not a part of the snapshot")
+	errNoHistory        = errors.New("collection doesn't keep history, it can be restored only to a snapshot")
+	errHistoryExpired   = errors.New("history of the collection doesn't reach the requested time")
+)
+
+// SnapshotInfo - describes a named snapshot, Collections holds the sequence number of the latest change
+// of every collection in the snapshot
+type SnapshotInfo struct {
+	Name        string            `json:"name"`
+	Created     time.Time         `json:"created"`
+	Collections map[string]uint64 `json:"collections"`
+}
+
+// History - returns whether the log of changes keeps contents of written items
+func (s *JsonFileData) History() bool {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.history
+}
+
+// SetHistory - sets whether the log of changes keeps contents of written items, so a collection can be restored
+// to any time covered by the log
+func (s *JsonFileData) SetHistory(history bool) error {
+
+	s.lock.Lock()
+	s.history = history
+	s.lock.Unlock()
+
+	return s.alter(func(e *CatalogEntry) { e.History = history })
+}
+
+// Snapshot - creates a named snapshot of given collections, or of all collections if none are given. Loaded collections
+// are captured from memory at the same moment, files of other collections are linked because files are never changed in place
+func (cm *jsonFileManager) Snapshot(name string, collections []string) (SnapshotInfo, error) {
+
+	if err := cm.writable(); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if !validEntry(name) || strings.HasPrefix(name, "_") {
+		return SnapshotInfo{}, errInvalidSnapshot
+	}
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	dir := cm.snapshotPath(name)
+	if fileExists(dir) {
+		return SnapshotInfo{}, errSnapshotExists
+	}
+
+	snaps, _, err := cm.capture(false)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer release(snaps)
+
+	names := collections
+	if len(names) == 0 {
+
+		if names, err = cm.collections(); err != nil {
+			return SnapshotInfo{}, err
+		}
+
+		for c := range snaps {
+			if !fileExists(filepath.Join(cm.path, c+".json")) {
+				names = append(names, c)
+			}
+		}
+	}
+
+	tmp := dir + ".tmp"
+	os.RemoveAll(tmp)
+	if err = os.MkdirAll(tmp, 0755); err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	info := SnapshotInfo{Name: name, Created: time.Now().UTC(), Collections: map[string]uint64{}}
+
+	for _, c := range names {
+
+		target := filepath.Join(tmp, c+".json")
+
+		if sn, loaded := snaps[c]; loaded {
+
+			if err = sn.writeCopy(target); err != nil {
+				return SnapshotInfo{}, err
+			}
+
+			info.Collections[c] = sn.change
+			continue
+		}
+
+		fpath := filepath.Join(cm.path, c+".json")
+		if !fileExists(fpath) {
+			return SnapshotInfo{}, errCollectionNotExists
+		}
+
+		if err = linkFile(fpath, target); err != nil {
+			return SnapshotInfo{}, err
+		}
+
+		shards, err := committedShards(fpath, cm.keys)
+		if err != nil {
+			return SnapshotInfo{}, err
+		}
+
+		for _, spath := range shards {
+			if err = linkFile(spath, filepath.Join(tmp, filepath.Base(spath))); err != nil {
+				return SnapshotInfo{}, err
+			}
+		}
+
+		s := initialize(fpath, 0, false)
+		s.keys = cm.keys
+		if err = s.loadLog(); err != nil {
+			return SnapshotInfo{}, err
+		}
+
+		info.Collections[c] = s.change
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if err = writeFile(filepath.Join(tmp, snapshotMeta), data); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	if err = os.Rename(tmp, dir); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	syncDir(filepath.Dir(dir))
+
+	return info, nil
+}
+
+// Snapshots - returns all snapshots ordered by the time they were created
+func (cm *jsonFileManager) Snapshots() ([]SnapshotInfo, error) {
+
+	entries, err := ioutil.ReadDir(filepath.Join(cm.path, snapshotsDir))
+	if os.IsNotExist(err) {
+		return []SnapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []SnapshotInfo{}
+	for _, e := range entries {
+
+		if !e.IsDir() || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+
+		info, err := cm.snapshotInfo(e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+
+	return infos, nil
+}
+
+// DropSnapshot - removes a snapshot
+func (cm *jsonFileManager) DropSnapshot(name string) error {
+
+	if err := cm.writable(); err != nil {
+		return err
+	}
+
+	if !validEntry(name) {
+		return errInvalidSnapshot
+	}
+
+	dir := cm.snapshotPath(name)
+	if !fileExists(dir) {
+		return errSnapshotNotFound
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// RestoreSnapshot - restores given collections, or all collections of a snapshot if none are given, to the state
+// recorded in the snapshot. A restore is recorded in logs of collections as ordinary changes, so it can be undone
+// with another snapshot. A collection that no longer exists is created
+func (cm *jsonFileManager) RestoreSnapshot(name string, collections []string, tm int, updatesync bool) error {
+
+	if err := cm.writable(); err != nil {
+		return err
+	}
+
+	info, err := cm.snapshotInfo(name)
+	if err != nil {
+		return err
+	}
+
+	names := collections
+	if len(names) == 0 {
+		for c := range info.Collections {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+	}
+
+	for _, c := range names {
+
+		if _, exists := info.Collections[c]; !exists {
+			return errNotInSnapshot
+		}
+
+		order, items, err := cm.readSnapshot(name, c)
+		if err != nil {
+			return err
+		}
+
+		s, err := cm.GetData(c, tm, updatesync)
+		if err == errCollectionNotExists {
+			s, err = cm.Create(c, tm, updatesync, Options{})
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = s.replace(order, items); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreAt - restores a collection to its state at a given time. The state is rebuilt from the latest snapshot
+// taken before that time and changes kept in the log, the collection must keep history
+func (cm *jsonFileManager) RestoreAt(name string, t time.Time, tm int, updatesync bool) error {
+
+	if err := cm.writable(); err != nil {
+		return err
+	}
+
+	s, err := cm.GetData(name, tm, updatesync)
+	if err != nil {
+		return err
+	}
+
+	if !s.History() {
+		return errNoHistory
+	}
+
+	infos, err := cm.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	order := []string{}
+	items := map[string]json.RawMessage{}
+	since := uint64(0)
+
+	for _, info := range infos {
+
+		seq, exists := info.Collections[name]
+		if !exists || info.Created.After(t) {
+			continue
+		}
+
+		if order, items, err = cm.readSnapshot(info.Name, name); err != nil {
+			return err
+		}
+		since = seq
+	}
+
+	changes, _, err := s.ChangesAfter(since)
+	if err == errChangesExpired {
+		return errHistoryExpired
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+
+		if c.Time.After(t) {
+			break
+		}
+
+		if c.Op == ChangeDelete {
+			delete(items, c.Key)
+			continue
+		}
+
+		if c.Data == nil {
+			return errNoHistory
+		}
+
+		if _, exists := items[c.Key]; !exists {
+			order = append(order, c.Key)
+		}
+		items[c.Key] = c.Data
+	}
+
+	return s.replace(order, items)
+}
+
+// replace - replaces all items of a collection and writes the collection
+func (s *JsonFileData) replace(order []string, items map[string]json.RawMessage) error {
+
+	s.lock.Lock()
+	err := s.replaceItems(order, items)
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return s.flush()
+}
+
+// replaceItems - replaces all items of a collection, changes are recorded as if items were written one by one.
+// Must be called under the lock
+func (s *JsonFileData) replaceItems(order []string, items map[string]json.RawMessage) error {
+
+	err := s.items.each(func(key string, _ json.RawMessage) bool {
+		if _, keep := items[key]; !keep {
+			s.remove(key)
+		}
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range order {
+
+		item, exists := items[key]
+		if !exists {
+			continue
+		}
+
+		if old, ok, rerr := s.items.get(key); rerr != nil || !ok || !bytes.Equal(old, item) {
+			s.set(key, item)
+		}
+	}
+
+	return nil
+}
+
+// readSnapshot - reads items of a collection from a snapshot in the order they are stored
+func (cm *jsonFileManager) readSnapshot(name, collection string) ([]string, map[string]json.RawMessage, error) {
+
+	return scanCollection(filepath.Join(cm.snapshotPath(name), collection+".json"), cm.keys)
+}
+
+func (cm *jsonFileManager) snapshotInfo(name string) (SnapshotInfo, error) {
+
+	if !validEntry(name) {
+		return SnapshotInfo{}, errInvalidSnapshot
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(cm.snapshotPath(name), snapshotMeta))
+	if os.IsNotExist(err) {
+		return SnapshotInfo{}, errSnapshotNotFound
+	}
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	info := SnapshotInfo{}
+	err = json.Unmarshal(data, &info)
+
+	return info, err
+}
+
+func (cm *jsonFileManager) snapshotPath(name string) string {
+	return filepath.Join(cm.path, snapshotsDir, name)
+}
+
+// linkFile - links a collection file with its checksums
+func linkFile(src, dst string) error {
+
+	if fileExists(src + sumSuffix) {
+		if err := linkOne(src+sumSuffix, dst+sumSuffix); err != nil {
+			return err
+		}
+	}
+
+	return linkOne(src, dst)
+}
+
+// linkOne - links a file, the file is copied if links are not supported
+func linkOne(src, dst string) error {
+
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeStream(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
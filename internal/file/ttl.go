@@ -0,0 +1,144 @@
+package localstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// ExpiresField - a field of an item that holds its expiration time in RFC 3339 format
+const ExpiresField = "_expires"
+
+// purgeInterval - how often expired items are physically removed
+var purgeInterval = time.Second
+
+// expiry - returns an expiration time of an item
+func expiry(item json.RawMessage) (time.Time, bool) {
+
+	if !bytes.Contains(item, []byte(`"`+ExpiresField+`"`)) {
+		return time.Time{}, false
+	}
+
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(item, &doc); err != nil {
+		return time.Time{}, false
+	}
+
+	var at time.Time
+	if err := json.Unmarshal(doc[ExpiresField], &at); err != nil {
+		return time.Time{}, false
+	}
+
+	return at, true
+}
+
+// Expire - returns a copy of an item with an expiration time set
+func Expire(item json.RawMessage, at time.Time) (json.RawMessage, error) {
+
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(item, &doc); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(at.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	doc[ExpiresField] = data
+
+	return json.Marshal(doc)
+}
+
+// SetTTL - sets a default time to live of items stored without an expiration time, zero disables it
+func (s *JsonFileData) SetTTL(ttl time.Duration) error {
+
+	s.lock.Lock()
+	s.ttl = ttl
+	s.lock.Unlock()
+
+	return s.alter(func(e *CatalogEntry) { e.TTL = Duration(ttl) })
+}
+
+// TTL - returns a default time to live of items
+func (s *JsonFileData) TTL() time.Duration {
+
+	defer s.lock.RUnlock()
+	s.lock.RLock()
+
+	return s.ttl
+}
+
+// Purge - removes expired items, returns a number of removed items
+func (s *JsonFileData) Purge() int {
+
+	if s.readOnly {
+		return 0
+	}
+
+	s.lock.Lock()
+
+	num := 0
+	now := time.Now()
+	for k, at := range s.expires {
+		if !at.After(now) {
+			s.remove(k)
+			num++
+		}
+	}
+
+	s.lock.Unlock()
+
+	if num != 0 && s.updatesync.Load() {
+		s.Sync()
+	}
+
+	return num
+}
+
+// track - remembers an expiration time of an item, must be called under the lock
+func (s *JsonFileData) track(key string, item json.RawMessage) {
+
+	if at, ok := expiry(item); ok {
+		s.expires[key] = at
+		return
+	}
+
+	delete(s.expires, key)
+}
+
+// isExpired - checks if an item has expired, must be called under the lock
+func (s *JsonFileData) isExpired(key string, now time.Time) bool {
+
+	at, ok := s.expires[key]
+
+	return ok && !at.After(now)
+}
+
+// expired - returns a number of expired items that are not purged yet, must be called under the lock
+func (s *JsonFileData) expired(now time.Time) int {
+
+	num := 0
+	for _, at := range s.expires {
+		if !at.After(now) {
+			num++
+		}
+	}
+
+	return num
+}
+
+// stamp - stamps an item without an expiration time with the default time to live of the collection,
+// must be called under the lock
+func (s *JsonFileData) stamp(item json.RawMessage) json.RawMessage {
+
+	if s.ttl > 0 {
+		if _, ok := expiry(item); !ok {
+			if stamped, err := Expire(item, time.Now().Add(s.ttl)); err == nil {
+				return stamped
+			}
+		}
+	}
+
+	return item
+}
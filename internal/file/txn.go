@@ -0,0 +1,212 @@
+package localstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	errTxnClosed = errors.New("transaction already closed")
+)
+
+type txnOpKind int
+
+const (
+	opInsert txnOpKind = iota
+	opUpdate
+	opDelete
+)
+
+type txnOp struct {
+	kind txnOpKind
+	key  string
+	item json.RawMessage
+}
+
+// KeyError - reports an operation that failed for a given key
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// Txn - holds a set of changes that are applied to a collection as a single unit.
+// Changes are not visible outside of the transaction until Commit is called.
+type Txn struct {
+	data   *JsonFileData
+	ops    []txnOp
+	staged map[string]json.RawMessage
+	closed bool
+	multi  *MultiTxn
+}
+
+// Begin - starts a new transaction
+func (s *JsonFileData) Begin() *Txn {
+	return &Txn{data: s, ops: []txnOp{}, staged: map[string]json.RawMessage{}}
+}
+
+// Data - returns a collection of the transaction
+func (t *Txn) Data() *JsonFileData {
+	return t.data
+}
+
+// Get - gets an item, changes made within the transaction take precedence over the collection content
+func (t *Txn) Get(key string) (json.RawMessage, bool, error) {
+
+	if item, ok := t.staged[key]; ok {
+		return item, item != nil, nil
+	}
+
+	return t.data.Get(key)
+}
+
+// Insert - stages insertion of a new item, returns an error if the key already exists
+func (t *Txn) Insert(key string, item json.RawMessage) error {
+
+	if t.closed {
+		return errTxnClosed
+	}
+
+	if _, exists, _ := t.Get(key); exists {
+		return &KeyError{Key: key, Err: errKeyExists}
+	}
+
+	t.stage(txnOp{kind: opInsert, key: key, item: item})
+
+	return nil
+}
+
+// Update - stages an update of an item
+func (t *Txn) Update(key string, item json.RawMessage) error {
+
+	if t.closed {
+		return errTxnClosed
+	}
+
+	t.stage(txnOp{kind: opUpdate, key: key, item: item})
+
+	return nil
+}
+
+// Delete - stages removal of an item
+func (t *Txn) Delete(key string) error {
+
+	if t.closed {
+		return errTxnClosed
+	}
+
+	t.stage(txnOp{kind: opDelete, key: key})
+
+	return nil
+}
+
+// Commit - validates and applies all staged changes under the collection lock. If any of changes
+// cannot be applied, the collection stays untouched. With updatesync the collection is written once.
+func (t *Txn) Commit() error {
+
+	if t.multi != nil {
+		return errTxnShared
+	}
+
+	if t.closed {
+		return errTxnClosed
+	}
+	t.closed = true
+
+	s := t.data
+
+	s.lock.Lock()
+	err := t.validate()
+	if err == nil {
+		t.apply()
+	}
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if s.updatesync.Load() && len(t.ops) != 0 {
+		return s.flush()
+	}
+
+	return nil
+}
+
+// Rollback - discards all staged changes
+func (t *Txn) Rollback() error {
+
+	if t.multi != nil {
+		return errTxnShared
+	}
+
+	if t.closed {
+		return errTxnClosed
+	}
+
+	t.closed = true
+	t.ops = nil
+	t.staged = nil
+
+	return nil
+}
+
+func (t *Txn) stage(op txnOp) {
+	t.ops = append(t.ops, op)
+	t.staged[op.key] = op.item
+}
+
+// validate - checks staged changes against the current state of the collection, must be called under the lock
+func (t *Txn) validate() error {
+
+	if err := t.data.writable(); err != nil {
+		return err
+	}
+
+	view := map[string]bool{}
+
+	for i, op := range t.ops {
+
+		exists, ok := view[op.key]
+		if !ok {
+			_, exists, _ = t.data.item(op.key)
+		}
+
+		if op.kind == opInsert && exists {
+			return &KeyError{Key: op.key, Err: errKeyExists}
+		}
+
+		if op.kind != opDelete {
+			item, err := t.data.check(op.item)
+			if err != nil {
+				return &KeyError{Key: op.key, Err: err}
+			}
+			t.ops[i].item = item
+		}
+
+		t.staged[op.key] = t.ops[i].item
+		view[op.key] = op.kind != opDelete
+	}
+
+	return nil
+}
+
+// apply - applies staged changes, must be called under the lock
+func (t *Txn) apply() {
+
+	for _, op := range t.ops {
+		if op.kind == opDelete {
+			t.data.remove(op.key)
+			continue
+		}
+		t.data.set(op.key, op.item)
+	}
+}
@@ -0,0 +1,89 @@
+package localstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Upgrade - rewrites collections stored in an older format with the current format, returns names of rewritten collections.
+// Fails if any collection was written by a newer version.
+func (cm *jsonFileManager) Upgrade() ([]string, error) {
+
+	if err := cm.writable(); err != nil {
+		return nil, err
+	}
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	names, err := cm.collections()
+	if err != nil {
+		return nil, err
+	}
+
+	upgraded := []string{}
+
+	for _, name := range names {
+
+		fpath := filepath.Join(cm.path, name+".json")
+
+		_, _, h, err := scanFile(fpath, cm.keys, func(key string, item json.RawMessage) {})
+		if err != nil {
+			return upgraded, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if h.Version == formatVersion {
+			continue
+		}
+
+		s, loaded := cm.m[name]
+		if !loaded {
+
+			s, err = cm.loadFile(name)
+			defer s.items.close()
+			if err != nil {
+				return upgraded, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+
+		s.lock.Lock()
+		s.touchAll()
+		s.lock.Unlock()
+
+		if err = s.flush(); err != nil {
+			return upgraded, err
+		}
+
+		upgraded = append(upgraded, name)
+	}
+
+	return upgraded, nil
+}
+
+// collections - returns names of collections stored in the directory
+func (cm *jsonFileManager) collections() ([]string, error) {
+
+	entries, err := ioutil.ReadDir(cm.path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, e := range entries {
+
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, "_") || strings.Count(name, ".") != 1 {
+			continue
+		}
+
+		names = append(names, strings.TrimSuffix(name, ".json"))
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
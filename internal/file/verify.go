@@ -0,0 +1,819 @@
+package localstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of problems reported by Verify
+const (
+	ProblemCorrupt  = "corrupt"
+	ProblemOrphaned = "orphaned"
+	ProblemTemp     = "temp"
+)
+
+// damagedSuffix - a suffix of a damaged collection file that is kept after the collection is repaired
+const damagedSuffix = ".damaged"
+
+// ErrChecksum - returned when content of a collection file doesn't match its checksum
+var ErrChecksum = errors.New("checksum of a collection file doesn't match its content")
+
+// sumSuffix - a suffix of a file that holds checksums of a collection file or a shard file, <name>.json.sum
+const sumSuffix = ".sum"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// fileSum - a size and the sha256 checksum of a version of a collection file
+type fileSum struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Problem - a problem with a file in the directory of a store, File is a path relative to the directory
+type Problem struct {
+	File     string `json:"file"`
+	Kind     string `json:"kind"`
+	Error    string `json:"error,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+	Salvaged int    `json:"salvaged,omitempty"`
+}
+
+// Report - a result of a verification of a store
+type Report struct {
+	Collections int       `json:"collections"`
+	Problems    []Problem `json:"problems"`
+}
+
+// OK - returns true if no problems were found
+func (r Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) add(p *Problem) {
+	if p != nil {
+		r.Problems = append(r.Problems, *p)
+	}
+}
+
+// Verify - checks every file in the directory: checksums and content of collection files, logs, the catalog and snapshots.
+// Files that don't belong to any collection and temporary files left by interrupted writes are reported as well.
+// With repair, a damaged file of a loaded collection is rewritten from memory, all readable items are salvaged from
+// a damaged file of other collections, damaged logs are truncated and temporary files are removed
+func (cm *jsonFileManager) Verify(ctx context.Context, repair bool) (Report, error) {
+
+	if repair && cm.readOnly {
+		return Report{}, ErrReadOnly
+	}
+
+	defer cm.lock.Unlock()
+	cm.lock.Lock()
+
+	report := Report{Problems: []Problem{}}
+
+	entries, err := ioutil.ReadDir(cm.path)
+	if err != nil {
+		return report, err
+	}
+
+	for _, e := range entries {
+
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+
+		name := e.Name()
+
+		switch {
+		case e.IsDir() && name == snapshotsDir:
+			err = cm.verifySnapshots(&report, repair)
+		case e.IsDir() && strings.HasPrefix(name, ".restore"):
+			err = cm.removeTemp(&report, name, repair)
+		case e.IsDir(), strings.HasSuffix(name, damagedSuffix), strings.HasPrefix(name, recordPrefix):
+			continue
+		case strings.HasSuffix(name, ".tmp"):
+			err = cm.verifyTemp(&report, name, repair)
+		case name == catalogName:
+			err = cm.verifyCatalog(&report, repair)
+		case strings.HasSuffix(name, schemaSuffix):
+			report.add(cm.orphaned(name, strings.TrimSuffix(name, schemaSuffix)))
+		case strings.HasSuffix(name, shardSuffix):
+			report.add(cm.orphaned(name, shardOwner(name)))
+		case strings.HasSuffix(name, sumSuffix):
+			report.add(cm.orphaned(name, shardOwner(name)))
+		case strings.HasSuffix(name, logSuffix):
+			err = cm.verifyLog(&report, strings.TrimSuffix(name, logSuffix), repair)
+		case strings.HasSuffix(name, ".json") && strings.Count(name, ".") == 1 && !strings.HasPrefix(name, "_"):
+			report.Collections++
+			err = cm.verifyCollection(&report, strings.TrimSuffix(name, ".json"), repair)
+		}
+
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return report, nil
+}
+
+// verifyCollection - checks a collection file, the file of a loaded collection is checked while it can't be written
+func (cm *jsonFileManager) verifyCollection(report *Report, name string, repair bool) error {
+
+	fpath := filepath.Join(cm.path, name+".json")
+
+	if err := cm.verifyShards(report, name, repair); err != nil {
+		return err
+	}
+
+	s, loaded := cm.m[name]
+	if loaded {
+		s.flushLock.Lock()
+	}
+
+	_, _, _, err := scanFile(fpath, cm.keys, func(key string, item json.RawMessage) {})
+
+	if loaded {
+		s.flushLock.Unlock()
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	p := &Problem{File: name + ".json", Kind: ProblemCorrupt, Error: err.Error()}
+	defer report.add(p)
+
+	if !repair {
+		return nil
+	}
+
+	if loaded {
+		if err = s.flush(); err != nil {
+			return err
+		}
+		p.Repaired = true
+		return nil
+	}
+
+	codec, compression, order, items, err := salvage(fpath, cm.keys)
+	if err != nil {
+		return err
+	}
+
+	files, generation, err := latestShards(fpath)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range sortedIndexes(files) {
+
+		_, _, sorder, sitems, err := salvage(files[i], cm.keys)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range sorder {
+			if _, exists := items[key]; !exists {
+				order = append(order, key)
+			}
+			items[key] = sitems[key]
+		}
+	}
+
+	if err = os.Rename(fpath, fpath+damagedSuffix); err != nil {
+		return err
+	}
+
+	s = initialize(fpath, 0, false)
+	s.keys = cm.keys
+	s.codec = codec
+	s.compression = compression
+	s.generation = generation
+	s.shards = cm.sharding(name)
+	s.reshard = true
+	s.touchAll()
+
+	s.loading = true
+	for _, key := range order {
+		s.set(key, items[key])
+	}
+	s.loading = false
+
+	if err = s.loadLog(); err != nil {
+		return err
+	}
+
+	if err = s.flush(); err != nil {
+		return err
+	}
+
+	p.Repaired = true
+	p.Salvaged = len(order)
+
+	return nil
+}
+
+// latestShards - returns the shard file of the latest generation of every shard of a collection and the latest generation,
+// used when the collection file that records generations of its shard files is damaged
+func latestShards(path string) (map[int]string, uint64, error) {
+
+	all, err := allShardFiles(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	files := map[int]string{}
+	gens := map[int]uint64{}
+	latest := uint64(0)
+
+	for _, spath := range all {
+
+		i, gen, _ := parseShard(path, spath)
+		if g, exists := gens[i]; exists && g > gen {
+			continue
+		}
+
+		files[i] = spath
+		gens[i] = gen
+		if gen > latest {
+			latest = gen
+		}
+	}
+
+	return files, latest, nil
+}
+
+// verifyShards - checks shard files of a collection, a damaged shard of a loaded collection is written again from memory
+// and readable items of a damaged shard of other collections are written to a new shard file. Shard files that
+// the collection file doesn't refer to are left by an interrupted sync, they are reported as temporary files
+func (cm *jsonFileManager) verifyShards(report *Report, name string, repair bool) error {
+
+	fpath := filepath.Join(cm.path, name+".json")
+
+	s, loaded := cm.m[name]
+	if loaded {
+		s.flushLock.Lock()
+	}
+
+	all, err := allShardFiles(fpath)
+
+	current := map[string]bool{}
+	if err == nil && len(all) != 0 {
+
+		var files map[int]string
+		var cerr error
+		if loaded {
+			s.lock.RLock()
+			files, cerr = shardFiles(fpath, s.gens)
+			s.lock.RUnlock()
+		} else {
+			files, cerr = committedShards(fpath, cm.keys)
+		}
+
+		for _, spath := range files {
+			current[spath] = true
+		}
+
+		if cerr != nil {
+			for _, spath := range all {
+				current[spath] = true
+			}
+		}
+	}
+
+	stale := []string{}
+	damaged := []string{}
+	errs := map[string]error{}
+	for _, spath := range all {
+
+		if !current[spath] {
+			stale = append(stale, spath)
+			continue
+		}
+
+		if _, _, _, serr := scanFile(spath, cm.keys, func(key string, item json.RawMessage) {}); serr != nil {
+			damaged = append(damaged, spath)
+			errs[spath] = serr
+		}
+	}
+
+	if loaded {
+		s.flushLock.Unlock()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, spath := range stale {
+
+		p := &Problem{File: filepath.Base(spath), Kind: ProblemTemp}
+		if repair {
+			removeShard(spath)
+			p.Repaired = true
+		}
+
+		report.add(p)
+	}
+
+	for _, spath := range damaged {
+
+		p := &Problem{File: filepath.Base(spath), Kind: ProblemCorrupt, Error: errs[spath].Error()}
+
+		i, _, _ := parseShard(fpath, spath)
+		if err = cm.repairShard(p, s, spath, i, repair); err != nil {
+			return err
+		}
+
+		report.add(p)
+	}
+
+	return nil
+}
+
+// repairShard - writes a damaged shard file again, s is nil if a collection is not loaded
+func (cm *jsonFileManager) repairShard(p *Problem, s *JsonFileData, spath string, i int, repair bool) error {
+
+	if !repair {
+		return nil
+	}
+
+	if s != nil {
+
+		s.lock.Lock()
+		if i < s.shards.shards() {
+			s.dirty[i] = true
+		} else {
+			s.reshard = true
+		}
+		s.lock.Unlock()
+
+		if err := s.flush(); err != nil {
+			return err
+		}
+
+		p.Repaired = true
+		return nil
+	}
+
+	codec, compression, order, items, err := salvage(spath, cm.keys)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(spath, spath+damagedSuffix); err != nil {
+		return err
+	}
+
+	item, release := memItems(items).view(order)
+	defer release()
+
+	sn := snapshot{order: order, item: item, release: release, codec: codec, compression: compression, keys: cm.keys}
+	if err = writeChecked(spath, sn.encode); err != nil {
+		return err
+	}
+
+	p.Repaired = true
+	p.Salvaged = len(order)
+
+	return nil
+}
+
+// verifyLog - checks a log of changes, a damaged log is truncated to the last readable change
+func (cm *jsonFileManager) verifyLog(report *Report, name string, repair bool) error {
+
+	if p := cm.orphaned(name+logSuffix, name); p != nil {
+		report.add(p)
+		return nil
+	}
+
+	s, loaded := cm.m[name]
+	if !loaded {
+		s = initialize(filepath.Join(cm.path, name+".json"), 0, false)
+		s.keys = cm.keys
+	}
+
+	defer s.flushLock.Unlock()
+	s.flushLock.Lock()
+
+	_, damaged, err := s.scanLog()
+	if err == nil && !damaged {
+		return nil
+	}
+
+	p := &Problem{File: name + logSuffix, Kind: ProblemCorrupt}
+	defer report.add(p)
+
+	if err != nil {
+		p.Error = err.Error()
+		return nil
+	}
+
+	p.Error = "damaged line"
+
+	if !repair {
+		return nil
+	}
+
+	if err = s.truncateLog(); err != nil {
+		return err
+	}
+
+	p.Repaired = true
+
+	return nil
+}
+
+// orphaned - reports a file of a collection that doesn't exist
+func (cm *jsonFileManager) orphaned(file, name string) *Problem {
+
+	if _, loaded := cm.m[name]; loaded || fileExists(filepath.Join(cm.path, name+".json")) {
+		return nil
+	}
+
+	return &Problem{File: file, Kind: ProblemOrphaned, Error: "collection doesn't exist"}
+}
+
+// verifyTemp - reports a temporary file left by an interrupted write. A temporary file of a loaded collection or
+// of the catalog is checked again while it can't be written, so a write in progress is not reported.
+// A segment file is reported only if it isn't used by a paged collection of this process
+func (cm *jsonFileManager) verifyTemp(report *Report, name string, repair bool) error {
+
+	if strings.HasSuffix(name, segmentSuffix) && openSegment(filepath.Join(cm.path, name)) {
+		return nil
+	}
+
+	owner := strings.TrimSuffix(name, ".tmp")
+
+	switch {
+	case owner == catalogName:
+		defer cm.catalogLock.Unlock()
+		cm.catalogLock.Lock()
+	default:
+		owner = shardOwner(owner)
+
+		if s, loaded := cm.m[owner]; loaded {
+			defer s.flushLock.Unlock()
+			s.flushLock.Lock()
+		}
+	}
+
+	if !fileExists(filepath.Join(cm.path, name)) {
+		return nil
+	}
+
+	return cm.removeTemp(report, name, repair)
+}
+
+// removeTemp - reports a temporary file or directory and removes it with repair
+func (cm *jsonFileManager) removeTemp(report *Report, name string, repair bool) error {
+
+	p := &Problem{File: name, Kind: ProblemTemp}
+	defer report.add(p)
+
+	if !repair {
+		return nil
+	}
+
+	if err := os.RemoveAll(filepath.Join(cm.path, name)); err != nil {
+		return err
+	}
+
+	p.Repaired = true
+
+	return nil
+}
+
+// verifyCatalog - checks the catalog and reports entries of collections that don't exist, with repair such entries are removed
+func (cm *jsonFileManager) verifyCatalog(report *Report, repair bool) error {
+
+	defer cm.catalogLock.Unlock()
+	cm.catalogLock.Lock()
+
+	data, err := ioutil.ReadFile(filepath.Join(cm.path, catalogName))
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, &map[string]*CatalogEntry{}); err != nil {
+
+		p := &Problem{File: catalogName, Kind: ProblemCorrupt, Error: err.Error()}
+		defer report.add(p)
+
+		if repair && cm.catalog != nil {
+			if err = cm.saveCatalog(); err != nil {
+				return err
+			}
+			p.Repaired = true
+		}
+
+		return nil
+	}
+
+	if err = cm.loadCatalog(); err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range cm.catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	removed := false
+	for _, name := range names {
+
+		p := cm.orphaned(catalogName, name)
+		if p == nil {
+			continue
+		}
+
+		p.Error = fmt.Sprintf("entry of a collection %s that doesn't exist", name)
+		if repair {
+			delete(cm.catalog, name)
+			p.Repaired = true
+			removed = true
+		}
+		report.add(p)
+	}
+
+	if removed {
+		return cm.saveCatalog()
+	}
+
+	return nil
+}
+
+// verifySnapshots - checks collection files of all snapshots, damaged snapshots can't be repaired
+func (cm *jsonFileManager) verifySnapshots(report *Report, repair bool) error {
+
+	dir := filepath.Join(cm.path, snapshotsDir)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			if err = cm.removeTemp(report, filepath.Join(snapshotsDir, e.Name()), repair); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !e.IsDir() {
+			continue
+		}
+
+		if _, err = cm.snapshotInfo(e.Name()); err != nil {
+			report.add(&Problem{File: filepath.Join(snapshotsDir, e.Name(), snapshotMeta), Kind: ProblemCorrupt, Error: err.Error()})
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+
+			if f.Name() == snapshotMeta || (!strings.HasSuffix(f.Name(), ".json") && !strings.HasSuffix(f.Name(), shardSuffix)) {
+				continue
+			}
+
+			fpath := filepath.Join(dir, e.Name(), f.Name())
+			if _, _, _, err = scanFile(fpath, cm.keys, func(key string, item json.RawMessage) {}); err != nil {
+				report.add(&Problem{File: filepath.Join(snapshotsDir, e.Name(), f.Name()), Kind: ProblemCorrupt, Error: err.Error()})
+			}
+		}
+	}
+
+	return nil
+}
+
+// salvage - reads all readable items of a damaged collection file. Reading stops at the damaged part of a file,
+// except for json lines where only damaged lines are skipped
+func salvage(path string, keys KeyProvider) (Codec, string, []string, map[string]json.RawMessage, error) {
+
+	order := []string{}
+	items := map[string]json.RawMessage{}
+	add := func(key string, item json.RawMessage) {
+		if _, exists := items[key]; !exists {
+			order = append(order, key)
+		}
+		items[key] = item
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	defer f.Close()
+
+	cr, err := checkFile(f)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+
+	er, _, err := openStream(bufio.NewReader(cr), keys)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+
+	r, compression, err := decompress(er)
+	if err != nil {
+		return jsonCodec{}, CompressionNone, order, items, nil
+	}
+	defer r.Close()
+
+	codec, br, err := detect(bufio.NewReader(r))
+	if err != nil {
+		return jsonCodec{}, compression, order, items, nil
+	}
+
+	if codec.Format() != FormatJSONL {
+		codec.Decode(br, add)
+		return codec, compression, order, items, nil
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+
+		line := []json.RawMessage{}
+		key := ""
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || len(line) != 2 || json.Unmarshal(line[0], &key) != nil {
+			continue
+		}
+
+		add(key, line[1])
+	}
+
+	return codec, compression, order, items, nil
+}
+
+// checkedReader - reads a collection file and computes a checksum of read data
+type checkedReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	sums     [][]byte
+	mismatch bool
+}
+
+// checkFile - returns a reader of a collection file that verifies its checksum. The expected checksums are those
+// of versions with the size of the file, if none of recorded versions has that size, e.g. the file was truncated,
+// the file doesn't match. A file without checksums, e.g. written before checksums were introduced, is not verified
+func checkFile(f *os.File) (*checkedReader, error) {
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &checkedReader{hash: sha256.New()}
+	cr.r = io.TeeReader(f, cr.hash)
+
+	sums := readSums(f.Name())
+	for _, fs := range sums {
+		if sum, err := hex.DecodeString(fs.SHA256); err == nil && fs.Size == info.Size() {
+			cr.sums = append(cr.sums, sum)
+		}
+	}
+	cr.mismatch = len(sums) != 0 && len(cr.sums) == 0
+
+	return cr, nil
+}
+
+// Read - implements io.Reader
+func (cr *checkedReader) Read(p []byte) (int, error) {
+	return cr.r.Read(p)
+}
+
+// check - reads the rest of a file and returns ErrChecksum if the file doesn't match its checksum, otherwise err.
+// Damaged encrypted data is already reported by a failed decryption
+func (cr *checkedReader) check(err error) error {
+
+	if errors.Is(err, ErrDecrypt) {
+		return err
+	}
+
+	if cr.mismatch {
+		return ErrChecksum
+	}
+
+	if len(cr.sums) == 0 {
+		return err
+	}
+
+	if _, cerr := io.Copy(ioutil.Discard, cr.r); cerr != nil {
+		return cerr
+	}
+
+	sum := cr.hash.Sum(nil)
+	for _, expected := range cr.sums {
+		if bytes.Equal(sum, expected) {
+			return err
+		}
+	}
+
+	return ErrChecksum
+}
+
+// readSums - returns checksums of a collection file, the newest first
+func readSums(path string) []fileSum {
+
+	sums := []fileSum{}
+
+	data, err := ioutil.ReadFile(path + sumSuffix)
+	if err == nil {
+		json.Unmarshal(data, &sums)
+	}
+
+	return sums
+}
+
+// writeSums - records the checksum of a new version of a collection file with the checksum of the current version.
+// The checksums are written before the file is replaced, so whether the replacement succeeds or not,
+// the file on disk matches one of them
+func writeSums(path string, size int64, sum []byte) error {
+
+	sums := []fileSum{{Size: size, SHA256: hex.EncodeToString(sum)}}
+	if current := readSums(path); len(current) != 0 {
+		sums = append(sums, current[0])
+	}
+
+	data, err := json.Marshal(sums)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path+sumSuffix, data)
+}
+
+// countWriter - counts bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write - implements io.Writer
+func (cw *countWriter) Write(p []byte) (int, error) {
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
+
+// writeChecked - atomically replaces a collection file or a shard file and records its checksum next to it
+func writeChecked(path string, fn func(w io.Writer) error) error {
+
+	return writeStream(path, func(w io.Writer) error {
+
+		h := sha256.New()
+		cw := &countWriter{w: io.MultiWriter(w, h)}
+
+		if err := fn(cw); err != nil {
+			return err
+		}
+
+		return writeSums(path, cw.n, h.Sum(nil))
+	})
+}
+
+// sumLine - appends a checksum to a line of a log
+func sumLine(line []byte) []byte {
+	return append(line, fmt.Sprintf(" %08x", crc32.Checksum(line, crcTable))...)
+}
+
+// checkLine - strips a checksum from a line of a log and verifies it. Lines written before checksums were introduced
+// have no checksum, they end with a brace of a json object or with base64 padding
+func checkLine(line []byte) ([]byte, bool) {
+
+	n := len(line) - 9
+	if n < 0 || line[n] != ' ' {
+		return line, true
+	}
+
+	sum, err := strconv.ParseUint(string(line[n+1:]), 16, 32)
+	if err != nil {
+		return line, true
+	}
+
+	return line[:n], uint32(sum) == crc32.Checksum(line[:n], crcTable)
+}
@@ -0,0 +1,430 @@
+// Package schema validates json documents against a subset of JSON Schema draft 2020-12
+package schema
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/przebro/localstore/internal/document"
+)
+
+// Supported types
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeNull    = "null"
+)
+
+// Violation - a single failed constraint, Path is a JSON Pointer to the failing value
+type Violation struct {
+	Path    string
+	Message string
+}
+
+// ValidationError - holds all constraints a document failed
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+
+	v := e.Violations[0]
+	if len(e.Violations) == 1 {
+		return fmt.Sprintf("document does not match the schema: %s: %s", pointer(v.Path), v.Message)
+	}
+
+	return fmt.Sprintf("document does not match the schema: %s: %s and %d more violation(s)", pointer(v.Path), v.Message, len(e.Violations)-1)
+}
+
+// Schema - a compiled schema. Keywords: type, enum, const, required, properties, additionalProperties,
+// items, minItems, maxItems, uniqueItems, minLength, maxLength, pattern, minimum, maximum,
+// exclusiveMinimum and exclusiveMaximum. Other keywords are ignored.
+type Schema struct {
+	reject           bool
+	types            []string
+	enum             []interface{}
+	constant         interface{}
+	hasConst         bool
+	required         []string
+	properties       map[string]*Schema
+	additional       *Schema
+	items            *Schema
+	minItems         *int
+	maxItems         *int
+	uniqueItems      bool
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+}
+
+// Parse - compiles a schema
+func Parse(data []byte) (*Schema, error) {
+
+	v, err := document.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return compile(v, "")
+}
+
+// Validate - validates a marshaled document
+func (s *Schema) Validate(data []byte) error {
+
+	v, err := document.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	violations := s.validate(v, "", []Violation{})
+	if len(violations) != 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+func compile(v interface{}, path string) (*Schema, error) {
+
+	if b, ok := v.(bool); ok {
+		return &Schema{reject: !b}, nil
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, invalid(path, "schema must be an object or a boolean")
+	}
+
+	s := &Schema{}
+	var err error
+
+	if t, exists := obj["type"]; exists {
+		if s.types, err = names(t, path+"/type"); err != nil {
+			return nil, err
+		}
+		for _, name := range s.types {
+			if !isType(name) {
+				return nil, invalid(path+"/type", "unknown type "+name)
+			}
+		}
+	}
+
+	if e, exists := obj["enum"]; exists {
+		if s.enum, ok = e.([]interface{}); !ok {
+			return nil, invalid(path+"/enum", "must be an array")
+		}
+	}
+
+	s.constant, s.hasConst = obj["const"]
+
+	if r, exists := obj["required"]; exists {
+		if s.required, err = names(r, path+"/required"); err != nil {
+			return nil, err
+		}
+	}
+
+	if p, exists := obj["properties"]; exists {
+
+		props, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, invalid(path+"/properties", "must be an object")
+		}
+
+		s.properties = map[string]*Schema{}
+		for name, ps := range props {
+			if s.properties[name], err = compile(ps, path+"/properties/"+escape(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if a, exists := obj["additionalProperties"]; exists {
+		if s.additional, err = compile(a, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+
+	if i, exists := obj["items"]; exists {
+		if s.items, err = compile(i, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, dst := range map[string]**int{"minItems": &s.minItems, "maxItems": &s.maxItems, "minLength": &s.minLength, "maxLength": &s.maxLength} {
+		if n, exists := obj[name]; exists {
+			if *dst, err = count(n, path+"/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, dst := range map[string]**float64{"minimum": &s.minimum, "maximum": &s.maximum, "exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum} {
+		if n, exists := obj[name]; exists {
+			f, ok := document.Number(n)
+			if !ok {
+				return nil, invalid(path+"/"+name, "must be a number")
+			}
+			*dst = &f
+		}
+	}
+
+	if u, exists := obj["uniqueItems"]; exists {
+		if s.uniqueItems, ok = u.(bool); !ok {
+			return nil, invalid(path+"/uniqueItems", "must be a boolean")
+		}
+	}
+
+	if p, exists := obj["pattern"]; exists {
+
+		expr, ok := p.(string)
+		if !ok {
+			return nil, invalid(path+"/pattern", "must be a string")
+		}
+
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return nil, invalid(path+"/pattern", err.Error())
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Schema) validate(v interface{}, path string, violations []Violation) []Violation {
+
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.reject {
+		fail("value is not allowed")
+		return violations
+	}
+
+	if len(s.types) != 0 && !s.hasType(v) {
+		fail("must be of type %s", strings.Join(s.types, " or "))
+		return violations
+	}
+
+	if s.enum != nil && !contains(s.enum, v) {
+		fail("must be one of the enumerated values")
+	}
+
+	if s.hasConst && !document.Equal(s.constant, v) {
+		fail("must be equal to the constant value")
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		{
+			for _, name := range s.required {
+				if _, exists := val[name]; !exists {
+					fail("missing required property %s", name)
+				}
+			}
+
+			keys := make([]string, 0, len(val))
+			for name := range val {
+				keys = append(keys, name)
+			}
+			sort.Strings(keys)
+
+			for _, name := range keys {
+				if ps, exists := s.properties[name]; exists {
+					violations = ps.validate(val[name], path+"/"+escape(name), violations)
+				} else if s.additional != nil {
+					violations = s.additional.validate(val[name], path+"/"+escape(name), violations)
+				}
+			}
+		}
+	case []interface{}:
+		{
+			if s.minItems != nil && len(val) < *s.minItems {
+				fail("must have at least %d items", *s.minItems)
+			}
+
+			if s.maxItems != nil && len(val) > *s.maxItems {
+				fail("must have at most %d items", *s.maxItems)
+			}
+
+			if s.uniqueItems {
+				for i := 1; i < len(val); i++ {
+					if contains(val[:i], val[i]) {
+						fail("items must be unique")
+						break
+					}
+				}
+			}
+
+			if s.items != nil {
+				for i, item := range val {
+					violations = s.items.validate(item, fmt.Sprintf("%s/%d", path, i), violations)
+				}
+			}
+		}
+	case string:
+		{
+			n := utf8.RuneCountInString(val)
+			if s.minLength != nil && n < *s.minLength {
+				fail("must be at least %d characters long", *s.minLength)
+			}
+
+			if s.maxLength != nil && n > *s.maxLength {
+				fail("must be at most %d characters long", *s.maxLength)
+			}
+
+			if s.pattern != nil && !s.pattern.MatchString(val) {
+				fail("must match the pattern %s", s.pattern)
+			}
+		}
+	}
+
+	if f, ok := document.Number(v); ok {
+
+		if s.minimum != nil && f < *s.minimum {
+			fail("must be greater than or equal to %g", *s.minimum)
+		}
+
+		if s.maximum != nil && f > *s.maximum {
+			fail("must be less than or equal to %g", *s.maximum)
+		}
+
+		if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+			fail("must be greater than %g", *s.exclusiveMinimum)
+		}
+
+		if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+			fail("must be less than %g", *s.exclusiveMaximum)
+		}
+	}
+
+	return violations
+}
+
+func (s *Schema) hasType(v interface{}) bool {
+
+	for _, t := range s.types {
+		if typeOf(v, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func typeOf(v interface{}, t string) bool {
+
+	switch t {
+	case TypeObject:
+		_, ok := v.(map[string]interface{})
+		return ok
+	case TypeArray:
+		_, ok := v.([]interface{})
+		return ok
+	case TypeString:
+		_, ok := v.(string)
+		return ok
+	case TypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case TypeNull:
+		return v == nil
+	case TypeNumber:
+		_, ok := document.Number(v)
+		return ok
+	case TypeInteger:
+		f, ok := document.Number(v)
+		return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+	}
+
+	return false
+}
+
+func isType(name string) bool {
+
+	for _, t := range []string{TypeObject, TypeArray, TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeNull} {
+		if t == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []interface{}, v interface{}) bool {
+
+	for _, e := range values {
+		if document.Equal(e, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// names - reads a keyword that holds a string or an array of strings
+func names(v interface{}, path string) ([]string, error) {
+
+	if s, ok := v.(string); ok {
+		return []string{s}, nil
+	}
+
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, invalid(path, "must be a string or an array of strings")
+	}
+
+	result := make([]string, 0, len(arr))
+	for _, e := range arr {
+		s, ok := e.(string)
+		if !ok {
+			return nil, invalid(path, "must be a string or an array of strings")
+		}
+		result = append(result, s)
+	}
+
+	return result, nil
+}
+
+// count - reads a keyword that holds a non-negative integer
+func count(v interface{}, path string) (*int, error) {
+
+	f, ok := document.Number(v)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, invalid(path, "must be a non-negative integer")
+	}
+
+	n := int(f)
+
+	return &n, nil
+}
+
+func invalid(path, msg string) error {
+	return fmt.Errorf("invalid schema at %s: %s", pointer(path), msg)
+}
+
+// escape - escapes a property name as a JSON Pointer token
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// pointer - returns a printable form of a JSON Pointer, the root is represented by a slash
+func pointer(path string) string {
+
+	if path == "" {
+		return "/"
+	}
+
+	return path
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"context"
+	"io"
+)
+
+// Backupable - implemented by stores that can be backed up while they are used
+type Backupable interface {
+	Backup(ctx context.Context, w io.Writer) error
+	Restore(ctx context.Context, r io.Reader) error
+}
+
+// Backup - writes a consistent snapshot of all collections of the store to w as a tar archive with checksums
+func (s *localStore) Backup(ctx context.Context, w io.Writer) error {
+	return s.manager.Backup(ctx, w)
+}
+
+// Restore - validates a backup and installs it, the directory of the store must be empty
+func (s *localStore) Restore(ctx context.Context, r io.Reader) error {
+	return s.manager.Restore(ctx, r)
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+)
+
+func TestBackup(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&key=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+	movies.Create(context.Background(), tst.TestDocument{ID: "m2", Title: "second"})
+
+	schema := []byte(`{"required" : ["title"]}`)
+	events, _ := ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "events", CollectionOptions{Schema: schema})
+	events.Create(context.Background(), tst.TestDocument{ID: "e1", Title: "event"})
+	ds.Close(context.Background())
+
+	ds, _ = store.NewStore("local;/" + dir + "?key=" + testKey1)
+	defer ds.Close(context.Background())
+
+	movies, _ = ds.Collection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m3", Title: "not synced"})
+
+	buf := bytes.Buffer{}
+	if err = ds.(Backupable).Backup(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+
+	expected := []string{"events.json", "events.changes", "events.schema.json", "movies.json", "movies.changes", "_catalog.json", "MANIFEST.json"}
+	if len(names) != len(expected) {
+		t.Fatal("unexpected result:", names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Error("unexpected result:", names)
+		}
+	}
+
+	target, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(target)
+
+	restored, err := store.NewStore("local;/" + target + "?key=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close(context.Background())
+
+	if err = restored.(Backupable).Restore(context.Background(), bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	col, err := restored.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := col.Count(context.Background()); n != 3 {
+		t.Error("unexpected result:", n)
+	}
+
+	col, _ = restored.Collection(context.Background(), "events")
+	if _, err = col.Create(context.Background(), map[string]interface{}{"_id": "e2"}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if err = restored.(Backupable).Restore(context.Background(), bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestRestoreDamaged(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, _ := store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+
+	buf := bytes.Buffer{}
+	if err := ds.(Backupable).Backup(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if i := bytes.Index(data, []byte("first")); i > 0 {
+		data[i] = 'F'
+	}
+
+	target, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(target)
+
+	restored, _ := store.NewStore("local;/" + target)
+	defer restored.Close(context.Background())
+
+	if err := restored.(Backupable).Restore(context.Background(), bytes.NewReader(data)); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Error("unexpected result:", entries)
+	}
+}
+
+func TestBackupShards(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?shards=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 20; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("movie_%02d", i)})
+	}
+
+	buf := bytes.Buffer{}
+	if err = ds.(Backupable).Backup(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	shards := 0
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if strings.HasSuffix(hdr.Name, ".shard") {
+			shards++
+		}
+	}
+
+	if shards != 4 {
+		t.Error("unexpected result:", shards)
+	}
+
+	target, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(target)
+
+	restored, err := store.NewStore("local;/" + target + "?shards=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close(context.Background())
+
+	if err = restored.(Backupable).Restore(context.Background(), bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	col, err := restored.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := col.Count(context.Background()); n != 20 {
+		t.Error("unexpected result:", n)
+	}
+
+	report, err := restored.(Verifiable).Verify(context.Background())
+	if err != nil || len(report.Problems) != 0 {
+		t.Error("unexpected result:", report, err)
+	}
+}
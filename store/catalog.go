@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	local "github.com/przebro/localstore/collection"
+	file "github.com/przebro/localstore/internal/file"
+
+	"github.com/przebro/databazaar/collection"
+)
+
+// CollectionOptions - settings of a collection, nil fields are taken from the store when a collection is created
+// and are left unchanged when a collection is altered
+type CollectionOptions = file.Options
+
+// CollectionInfo - settings of a collection recorded in the catalog of the store
+type CollectionInfo = file.CatalogEntry
+
+// Cataloged - implemented by stores that keep settings of every collection
+type Cataloged interface {
+	CreateCollectionWithOptions(ctx context.Context, name string, opts CollectionOptions) (collection.DataCollection, error)
+	AlterCollection(ctx context.Context, name string, opts CollectionOptions) error
+	CollectionInfo(ctx context.Context, name string) (CollectionInfo, error)
+}
+
+var errNotInCatalog = errors.New("collection is not recorded in the catalog")
+
+// CreateCollectionWithOptions - creates a new collection with given settings
+func (s *localStore) CreateCollectionWithOptions(ctx context.Context, name string, opts CollectionOptions) (collection.DataCollection, error) {
+
+	if !validName(name) {
+		return nil, errInvalidName
+	}
+
+	fields := opts.Fields
+	opts.Fields = nil
+
+	fdata, err := s.manager.Create(name, s.synctime, s.updsync, s.defaults(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	col := local.Collection(fdata)
+	if err = encryptFields(ctx, col, fields); err != nil {
+		s.manager.Discard(name)
+		return nil, err
+	}
+
+	return col, nil
+}
+
+// AlterCollection - changes settings of an existing collection
+func (s *localStore) AlterCollection(ctx context.Context, name string, opts CollectionOptions) error {
+
+	fields := opts.Fields
+	opts.Fields = nil
+
+	if err := s.manager.Alter(name, s.synctime, s.updsync, opts); err != nil || fields == nil {
+		return err
+	}
+
+	fdata, err := s.manager.GetData(name, s.synctime, s.updsync)
+	if err != nil {
+		return err
+	}
+
+	return encryptFields(ctx, local.Collection(fdata), fields)
+}
+
+// encryptFields - declares encrypted fields given by options, documents that are already stored are rewritten
+func encryptFields(ctx context.Context, col collection.DataCollection, fields []file.FieldSpec) error {
+
+	if fields == nil {
+		return nil
+	}
+
+	return col.(*local.LocalCollection).EncryptFields(ctx, fields...)
+}
+
+// CollectionInfo - returns settings of a collection
+func (s *localStore) CollectionInfo(ctx context.Context, name string) (CollectionInfo, error) {
+
+	info, exists := s.manager.Catalog(name)
+	if !exists {
+		return CollectionInfo{}, errNotInCatalog
+	}
+
+	return info, nil
+}
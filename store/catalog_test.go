@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+)
+
+func TestCatalog(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	os.WriteFile(filepath.Join(dir, "movies.json"), []byte(`{"m1":{"_id":"m1"},"m2":{"_id":"m2"},"m3":{"_id":"m3"}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "_catalog.json"), []byte(`{"movies":{"updatesync":true,"indexes":["year"],"maxdocs":2,"format":"json","created":"2020-01-01T00:00:00Z"}}`), 0644)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	movies, err := ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	movies.Create(context.Background(), tst.TestDocument{ID: "m4"})
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "movies.json")); !strings.Contains(string(data), "m4") {
+		t.Error("unexpected result:", string(data))
+	}
+
+	ttl := time.Hour
+	events, err := ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "events", CollectionOptions{
+		TTL:    &ttl,
+		Schema: []byte(`{"required" : ["title"]}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = events.Create(context.Background(), map[string]interface{}{"_id": "e1"}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	events.Create(context.Background(), tst.TestDocument{ID: "e1", Title: "event"})
+
+	doc := map[string]interface{}{}
+	if events.Get(context.Background(), "e1", &doc); doc["_expires"] == nil {
+		t.Error("unexpected result:", doc)
+	}
+
+	if _, err = ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "events", CollectionOptions{}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err = ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "*events", CollectionOptions{}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	maxDocs := 5
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "movies", CollectionOptions{MaxDocs: &maxDocs, Indexes: []string{"title"}}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	info, err := ds.(Cataloged).CollectionInfo(context.Background(), "movies")
+	if err != nil || info.MaxDocs != 5 || len(info.Indexes) != 1 || info.Indexes[0] != "title" || info.UpdateSync == nil || !*info.UpdateSync {
+		t.Error("unexpected result:", info, err)
+	}
+
+	if _, err = ds.(Cataloged).CollectionInfo(context.Background(), "missing"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	ds.Close(context.Background())
+
+	catalog := map[string]map[string]interface{}{}
+	data, _ := os.ReadFile(filepath.Join(dir, "_catalog.json"))
+	if err = json.Unmarshal(data, &catalog); err != nil {
+		t.Fatal(err)
+	}
+
+	if catalog["events"]["ttl"] != "1h0m0s" || catalog["events"]["schema"] != "events.schema.json" || catalog["movies"]["maxdocs"] != 5.0 {
+		t.Error("unexpected result:", catalog)
+	}
+}
+
+func TestCatalogCreateFailure(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	ds.CreateCollection(context.Background(), "movies")
+
+	if _, err = ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "events", CollectionOptions{Schema: []byte(`{"type" : "unknown"}`)}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err = ds.(Cataloged).CollectionInfo(context.Background(), "events"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "_catalog.json")); strings.Contains(string(data), "events") {
+		t.Error("unexpected result:", string(data))
+	}
+
+	if _, err = ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "events", CollectionOptions{}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+	local "github.com/przebro/localstore/collection"
+)
+
+func TestCompression(t *testing.T) {
+
+	magics := map[string][]byte{
+		local.CompressionGzip: {0x1f, 0x8b},
+		local.CompressionZstd: {0x28, 0xb5, 0x2f, 0xfd},
+		local.CompressionNone: []byte(`{"_localstore"`),
+	}
+
+	for compression, magic := range magics {
+
+		dir, _ := os.MkdirTemp("", "localstore")
+		defer os.RemoveAll(dir)
+
+		ds, err := store.NewStore("local;/" + dir + "?compression=" + compression + "&updatesync=true")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		col, err := ds.CreateCollection(context.Background(), "books")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, id := range []string{"b1", "b2", "b3"} {
+			col.Create(context.Background(), tst.TestDocument{ID: id, Title: strings.Repeat("lorem ipsum ", 100)})
+		}
+		ds.Close(context.Background())
+
+		data, _ := os.ReadFile(filepath.Join(dir, "books.json"))
+		if !bytes.HasPrefix(data, magic) {
+			t.Error("unexpected result:", compression, data[:4])
+		}
+
+		if compression != local.CompressionNone && len(data) > 1000 {
+			t.Error("unexpected result:", compression, len(data))
+		}
+
+		ds, _ = store.NewStore("local;/" + dir)
+
+		col, err = ds.Collection(context.Background(), "books")
+		if err != nil {
+			t.Fatal(compression, err)
+		}
+
+		if n, _ := col.Count(context.Background()); n != 3 {
+			t.Error("unexpected result:", compression, n)
+		}
+
+		if c := col.(*local.LocalCollection).Compression(context.Background()); c != compression {
+			t.Error("unexpected result:", c)
+		}
+
+		ds.Close(context.Background())
+	}
+}
+
+func TestConvertCompression(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&format=cbor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	col, err := ds.CreateCollection(context.Background(), "books")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col.Create(context.Background(), tst.TestDocument{ID: "b1", Title: "first"})
+
+	compression := local.CompressionZstd
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "books", CollectionOptions{Compression: &compression}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "books.json")); !bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		t.Error("unexpected result:", data)
+	}
+
+	if info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "books"); info.Compression != local.CompressionZstd || info.Format != local.FormatCBOR {
+		t.Error("unexpected result:", info)
+	}
+
+	if err = col.(*local.LocalCollection).SetCompression(context.Background(), local.CompressionNone); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "books.json")); !bytes.HasPrefix(data, []byte{0xd9, 0xd9, 0xf7}) {
+		t.Error("unexpected result:", data)
+	}
+
+	unknown := "lz4"
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "books", CollectionOptions{Compression: &unknown}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err = store.NewStore("local;/" + dir + "?compression=lz4"); err == nil {
+		t.Error("unexpected result")
+	}
+}
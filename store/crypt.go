@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+
+	file "github.com/przebro/localstore/internal/file"
+)
+
+const (
+	optKey         = "key"
+	optOldKeys     = "oldkeys"
+	optKeyProvider = "keyprovider"
+)
+
+// KeyProvider - supplies keys used to encrypt collections, see RegisterKeyProvider
+type KeyProvider = file.KeyProvider
+
+// ErrDecrypt - returned when a collection cannot be decrypted, because a key is wrong or a file is damaged
+var ErrDecrypt = file.ErrDecrypt
+
+var (
+	errInvalidKey         = errors.New("invalid key, a key must be given as 32, 48 or 64 hex digits")
+	errKeyOptions         = errors.New("key and keyprovider options are mutually exclusive")
+	errUnknownKeyProvider = errors.New("unknown key provider")
+)
+
+var (
+	providers     = map[string]KeyProvider{}
+	providersLock sync.Mutex
+)
+
+// Encrypted - implemented by stores that encrypt collections
+type Encrypted interface {
+	RotateKeys(ctx context.Context) ([]string, error)
+}
+
+// RegisterKeyProvider - registers a key provider that is used by stores opened with the option keyprovider=name
+func RegisterKeyProvider(name string, kp KeyProvider) {
+
+	defer providersLock.Unlock()
+	providersLock.Lock()
+
+	providers[name] = kp
+}
+
+// NewStaticKeys - returns a key provider that encrypts collections with a current key and decrypts collections
+// encrypted with the current key or any of old keys
+func NewStaticKeys(current []byte, old ...[]byte) (KeyProvider, error) {
+	return file.NewStaticKeys(current, old...)
+}
+
+// RotateKeys - rewrites all collections and their logs with the current key of the store, collections written
+// with old keys must still be readable with keys given to the store. Returns names of rewritten collections
+func (s *localStore) RotateKeys(ctx context.Context) ([]string, error) {
+	return s.manager.Rotate()
+}
+
+// keyProvider - returns keys given by options of a connection, either as the key and the oldkeys options
+// with hex encoded keys or as a name of a registered key provider. Returns nil if a store is not encrypted
+func keyProvider(options map[string]string) (KeyProvider, error) {
+
+	if name := options[optKeyProvider]; name != "" {
+
+		if options[optKey] != "" {
+			return nil, errKeyOptions
+		}
+
+		defer providersLock.Unlock()
+		providersLock.Lock()
+
+		kp, exists := providers[name]
+		if !exists {
+			return nil, errUnknownKeyProvider
+		}
+
+		return kp, nil
+	}
+
+	if options[optKey] == "" {
+		return nil, nil
+	}
+
+	current, err := hex.DecodeString(options[optKey])
+	if err != nil {
+		return nil, errInvalidKey
+	}
+
+	old := [][]byte{}
+	for _, k := range strings.Split(options[optOldKeys], ",") {
+
+		if k == "" {
+			continue
+		}
+
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, errInvalidKey
+		}
+
+		old = append(old, key)
+	}
+
+	kp, err := file.NewStaticKeys(current, old...)
+	if err != nil {
+		return nil, errInvalidKey
+	}
+
+	return kp, nil
+}
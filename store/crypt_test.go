@@ -0,0 +1,236 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/selector"
+	"github.com/przebro/databazaar/store"
+	local "github.com/przebro/localstore/collection"
+)
+
+const (
+	testKey1 = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testKey2 = "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff"
+)
+
+func TestEncryption(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&compression=gzip&key=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col, err := ds.CreateCollection(context.Background(), "customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col.Create(context.Background(), tst.TestDocument{ID: "c1", Title: "top secret"})
+	col.Create(context.Background(), tst.TestDocument{ID: "c2", Title: strings.Repeat("x", 200000)})
+	ds.Close(context.Background())
+
+	for _, name := range []string{"customers.json", "customers.changes"} {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		if len(data) == 0 || bytes.Contains(data, []byte("top secret")) || bytes.Contains(data, []byte(`"c1"`)) {
+			t.Error("unexpected result:", name, len(data))
+		}
+	}
+
+	for _, conn := range []string{"", "?key=" + testKey2} {
+
+		ds, _ = store.NewStore("local;/" + dir + conn)
+
+		if _, err = ds.Collection(context.Background(), "customers"); !errors.Is(err, ErrDecrypt) {
+			t.Error("unexpected result:", err)
+		}
+
+		ds.Close(context.Background())
+	}
+
+	ds, _ = store.NewStore("local;/" + dir + "?key=" + testKey1)
+
+	col, err = ds.Collection(context.Background(), "customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err = col.Get(context.Background(), "c2", &doc); err != nil || len(doc.Title) != 200000 {
+		t.Error("unexpected result:", err)
+	}
+
+	ds.Close(context.Background())
+
+	fpath := filepath.Join(dir, "customers.json")
+	data, _ := os.ReadFile(fpath)
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(fpath, data, 0644)
+
+	ds, _ = store.NewStore("local;/" + dir + "?key=" + testKey1)
+	defer ds.Close(context.Background())
+
+	if _, err = ds.Collection(context.Background(), "customers"); !errors.Is(err, ErrDecrypt) {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err = store.NewStore("local;/" + dir + "?key=abc"); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&key=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"customers", "orders"} {
+		col, err := ds.CreateCollection(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		col.Create(context.Background(), tst.TestDocument{ID: "d1", Title: "first"})
+	}
+	ds.Close(context.Background())
+
+	ds, _ = store.NewStore("local;/" + dir + "?key=" + testKey2 + "&oldkeys=" + testKey1)
+
+	col, err := ds.Collection(context.Background(), "customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := col.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	rotated, err := ds.(Encrypted).RotateKeys(context.Background())
+	if err != nil || len(rotated) != 2 {
+		t.Error("unexpected result:", rotated, err)
+	}
+	ds.Close(context.Background())
+
+	kp, _ := NewStaticKeys([]byte{0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8, 0xf9, 0xfa, 0xfb, 0xfc, 0xfd, 0xfe, 0xff})
+	RegisterKeyProvider("rotated", kp)
+
+	ds, err = store.NewStore("local;/" + dir + "?keyprovider=rotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	for _, name := range []string{"customers", "orders"} {
+
+		col, err := ds.Collection(context.Background(), name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		if n, _ := col.Count(context.Background()); n != 1 {
+			t.Error("unexpected result:", n)
+		}
+
+		if seq := col.(*local.LocalCollection).LastChange(context.Background()); seq != 1 {
+			t.Error("unexpected result:", seq)
+		}
+	}
+
+	if _, err = store.NewStore("local;/" + dir + "?keyprovider=unknown"); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestOpenSettings(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?key=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, conn := range []string{"", "?key=" + testKey2, "?key=" + testKey1 + "&oldkeys=" + testKey2, "?key=" + testKey1 + "&watch=reload", "?key=" + testKey1 + "&memory=4096"} {
+		if _, err = store.NewStore("local;/" + dir + conn); !errors.Is(err, ErrSettings) {
+			t.Error("unexpected result:", conn, err)
+		}
+	}
+
+	other, err := store.NewStore("local;/" + dir + "?synctime=5&key=" + testKey1)
+	if err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	col, _ := ds.CreateCollection(context.Background(), "customers")
+	col.Create(context.Background(), tst.TestDocument{ID: "c1", Title: "secret"})
+
+	if col, err = other.Collection(context.Background(), "customers"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := col.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	ds.Close(context.Background())
+
+	ds, err = store.NewStore("local;/" + dir + "?key=" + testKey2 + "&oldkeys=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	if col, err = ds.Collection(context.Background(), "customers"); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestAlterFields(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&key=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	col, _ := ds.CreateCollection(context.Background(), "customers")
+	col.Create(context.Background(), tst.TestDocument{ID: "c1", Title: "top secret"})
+
+	fields := []local.FieldSpec{{Name: "title", Mode: local.FieldDeterministic}}
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "customers", CollectionOptions{Fields: fields}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "customers.json")); bytes.Contains(data, []byte("top secret")) {
+		t.Error("unexpected result:", string(data))
+	}
+
+	if n, _ := col.(*local.LocalCollection).CountWhere(context.Background(), selector.Eq("title", selector.String("top secret"))); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "customers")
+	if len(info.Fields) != 1 {
+		t.Error("unexpected result:", info.Fields)
+	}
+
+	if _, err = ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "invalid", CollectionOptions{Fields: []local.FieldSpec{{Name: "title"}}}); err == nil {
+		t.Error("unexpected result")
+	}
+}
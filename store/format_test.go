@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+	local "github.com/przebro/localstore/collection"
+)
+
+func TestFormat(t *testing.T) {
+
+	prefixes := map[string][]byte{
+		local.FormatJSON:    []byte(`{"_localstore":{"version":2},"items":{`),
+		local.FormatJSONL:   []byte(`{"_localstore":{"version":2,"format":"jsonl"}}` + "\n"),
+		local.FormatCBOR:    {0xd9, 0xd9, 0xf7},
+		local.FormatMsgpack: {0x82},
+	}
+
+	for format, prefix := range prefixes {
+
+		dir, _ := os.MkdirTemp("", "localstore")
+		defer os.RemoveAll(dir)
+
+		ds, err := store.NewStore("local;/" + dir + "?format=" + format + "&updatesync=true")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		col, err := ds.CreateCollection(context.Background(), "movies")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		col.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first", Score: 7.5, Year: 1999})
+		col.Create(context.Background(), tst.TestDocument{ID: "m2", Title: "second", Score: 8})
+		ds.Close(context.Background())
+
+		if data, _ := os.ReadFile(filepath.Join(dir, "movies.json")); !bytes.HasPrefix(data, prefix) {
+			t.Error("unexpected result:", format, data)
+		}
+
+		ds, _ = store.NewStore("local;/" + dir)
+
+		col, err = ds.Collection(context.Background(), "movies")
+		if err != nil {
+			t.Fatal(format, err)
+		}
+
+		if n, _ := col.Count(context.Background()); n != 2 {
+			t.Error("unexpected result:", format, n)
+		}
+
+		doc := tst.TestDocument{}
+		if err = col.Get(context.Background(), "m1", &doc); err != nil || doc.Title != "first" || doc.Score != 7.5 || doc.Year != 1999 {
+			t.Error("unexpected result:", format, doc, err)
+		}
+
+		info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "movies")
+		if info.Format != format {
+			t.Error("unexpected result:", info.Format)
+		}
+
+		ds.Close(context.Background())
+	}
+}
+
+func TestConvertFormat(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	format := local.FormatMsgpack
+	col, err := ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "movies", CollectionOptions{Format: &format})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "movies.json")); !bytes.HasPrefix(data, []byte{0x82}) {
+		t.Error("unexpected result:", data)
+	}
+
+	format = local.FormatJSONL
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "movies", CollectionOptions{Format: &format}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "movies.json")); !bytes.Contains(data, []byte(`["m1",{"_id":"m1"`)) {
+		t.Error("unexpected result:", string(data))
+	}
+
+	if err = col.(*local.LocalCollection).SetFormat(context.Background(), local.FormatCBOR); err != nil {
+		t.Fatal(err)
+	}
+
+	if col.(*local.LocalCollection).Format(context.Background()) != local.FormatCBOR {
+		t.Error("unexpected result")
+	}
+
+	if info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "movies"); info.Format != local.FormatCBOR {
+		t.Error("unexpected result:", info.Format)
+	}
+
+	unknown := "xml"
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "movies", CollectionOptions{Format: &unknown}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err = store.NewStore("local;/" + dir + "?format=xml"); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestFormatNumbers(t *testing.T) {
+
+	numbers := []string{"12345678901234567890123", "18446744073709551615", "0.1000000000000000055511151231257827", "-1.5e300", "2.5", "-42"}
+
+	for _, format := range []string{local.FormatJSON, local.FormatJSONL, local.FormatCBOR, local.FormatMsgpack} {
+
+		dir, _ := os.MkdirTemp("", "localstore")
+		defer os.RemoveAll(dir)
+
+		ds, err := store.NewStore("local;/" + dir + "?format=" + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		col, _ := ds.CreateCollection(context.Background(), "numbers")
+		for i, n := range numbers {
+			col.Create(context.Background(), map[string]interface{}{"_id": fmt.Sprint("n", i), "value": json.Number(n)})
+		}
+		ds.Close(context.Background())
+
+		ds, _ = store.NewStore("local;/" + dir)
+		col, err = ds.Collection(context.Background(), "numbers")
+		if err != nil {
+			t.Fatal(format, err)
+		}
+
+		for i, n := range numbers {
+
+			doc := map[string]json.RawMessage{}
+			col.Get(context.Background(), fmt.Sprint("n", i), &doc)
+
+			expected, _ := new(big.Rat).SetString(n)
+			if value, ok := new(big.Rat).SetString(string(doc["value"])); !ok || value.Cmp(expected) != 0 {
+				t.Error("unexpected result:", format, n, string(doc["value"]))
+			}
+		}
+
+		ds.Close(context.Background())
+	}
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+)
+
+const (
+	// optPaged - new collections keep only keys in memory and read bodies of items from disk
+	optPaged = "paged"
+	// optMemory - a number of bytes of items of paged collections kept in memory by the store
+	optMemory = "memory"
+)
+
+var (
+	errInvalidPaged  = errors.New("invalid paged value")
+	errInvalidMemory = errors.New("invalid memory value")
+)
+
+// pagedOptions - returns whether new collections are paged and a memory budget of paged collections, zero means the default budget
+func pagedOptions(options map[string]string) (bool, int64, error) {
+
+	paged := false
+	if v := options[optPaged]; v != "" {
+
+		var err error
+		if paged, err = strconv.ParseBool(v); err != nil {
+			return false, 0, errInvalidPaged
+		}
+	}
+
+	memory := int64(0)
+	if v := options[optMemory]; v != "" {
+
+		var err error
+		if memory, err = strconv.ParseInt(v, 0, 64); err != nil || memory <= 0 {
+			return false, 0, errInvalidMemory
+		}
+	}
+
+	return paged, memory, nil
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+)
+
+func TestPaged(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?paged=true&memory=512&key=" + testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 100; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%03d", i), Title: "title", Year: i})
+	}
+
+	movies.Update(context.Background(), tst.TestDocument{ID: "m010", Title: "updated"})
+	movies.Delete(context.Background(), "m020")
+
+	if info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "movies"); !info.Paged {
+		t.Error("unexpected result:", info)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m050", &doc); err != nil || doc.Year != 50 {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	if err = movies.Get(context.Background(), "m020", &doc); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 99 {
+		t.Error("unexpected result:", n)
+	}
+
+	ds.Close(context.Background())
+
+	ds, _ = store.NewStore("local;/" + dir + "?memory=512&key=" + testKey1)
+	defer ds.Close(context.Background())
+
+	movies, err = ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = movies.Get(context.Background(), "m010", &doc); err != nil || doc.Title != "updated" {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	for i := 0; i < 100; i += 10 {
+		if err = movies.Get(context.Background(), fmt.Sprintf("m%03d", 99-i), &doc); err != nil || doc.Year != 99-i {
+			t.Error("unexpected result:", doc, err)
+		}
+	}
+
+	paged := false
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "movies", CollectionOptions{Paged: &paged}); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "movies"); info.Paged {
+		t.Error("unexpected result:", info)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 99 {
+		t.Error("unexpected result:", n)
+	}
+
+	if err = movies.Get(context.Background(), "m099", &doc); err != nil || doc.Year != 99 {
+		t.Error("unexpected result:", doc, err)
+	}
+}
+
+func TestPagedSegments(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	orphan := filepath.Join(dir, "movies.123.seg.tmp")
+	os.WriteFile(orphan, []byte("segment"), 0644)
+
+	ds, err := store.NewStore("local;/" + dir + "?paged=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("unexpected result:", err)
+	}
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 10; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%03d", i), Title: "title"})
+	}
+
+	if segments, _ := filepath.Glob(filepath.Join(dir, "movies.*.seg.tmp")); len(segments) != 1 {
+		t.Error("unexpected result:", segments)
+	}
+
+	report, err := ds.(Verifiable).Repair(context.Background())
+	if err != nil || !report.OK() {
+		t.Error("unexpected result:", report, err)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m005", &doc); err != nil || doc.Title != "title" {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	ds.Close(context.Background())
+
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*.seg.tmp")); len(segments) != 0 {
+		t.Error("unexpected result:", segments)
+	}
+}
+
+func TestPagedOptions(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	if _, err := store.NewStore("local;/" + dir + "?paged=maybe"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err := store.NewStore("local;/" + dir + "?memory=-1"); err == nil {
+		t.Error("unexpected result")
+	}
+}
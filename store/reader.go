@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+
+	file "github.com/przebro/localstore/internal/file"
+)
+
+// optMode - opens a store as a writer that owns the directory or with the reader value as a shared reader
+const optMode = "mode"
+
+const (
+	modeWriter = "writer"
+	modeReader = "reader"
+)
+
+// ErrReadOnly - returned when a store opened as a shared reader is changed
+var ErrReadOnly = file.ErrReadOnly
+
+var (
+	errInvalidMode = errors.New("invalid mode value, it must be writer or reader")
+	errReaderWatch = errors.New("shared reader always reloads changed collections")
+)
+
+// readerMode - returns true if the store is opened as a shared reader. A shared reader never writes to the directory,
+// collections are reloaded when a writer in another process syncs them
+func readerMode(options map[string]string) (bool, error) {
+
+	switch options[optMode] {
+	case "", modeWriter:
+		return false, nil
+	case modeReader:
+		return true, nil
+	}
+
+	return false, errInvalidMode
+}
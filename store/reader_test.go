@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/selector"
+	"github.com/przebro/databazaar/store"
+	local "github.com/przebro/localstore/collection"
+)
+
+func TestSharedReader(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	writer, err := store.NewStore("local;/" + dir + "?updatesync=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close(context.Background())
+
+	wmovies, _ := writer.CreateCollection(context.Background(), "movies")
+	wmovies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+
+	reader, err := store.NewStore("local;/" + dir + "?mode=reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close(context.Background())
+
+	rmovies, err := reader.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := rmovies.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	wmovies.Create(context.Background(), tst.TestDocument{ID: "m2", Title: "second"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for n, _ := rmovies.Count(context.Background()); n != 2 && time.Now().Before(deadline); n, _ = rmovies.Count(context.Background()) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	doc := tst.TestDocument{}
+	if err = rmovies.Get(context.Background(), "m2", &doc); err != nil || doc.Title != "second" {
+		t.Error("unexpected result:", err, doc)
+	}
+
+	if _, err = rmovies.Create(context.Background(), tst.TestDocument{ID: "m3"}); !errors.Is(err, ErrReadOnly) {
+		t.Error("unexpected result:", err)
+	}
+
+	if err = rmovies.Delete(context.Background(), "m1"); !errors.Is(err, ErrReadOnly) {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err = rmovies.(*local.LocalCollection).DeleteMany(context.Background(), selector.Eq("_id", selector.String("m1"))); !errors.Is(err, ErrReadOnly) {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err = reader.CreateCollection(context.Background(), "events"); !errors.Is(err, ErrReadOnly) {
+		t.Error("unexpected result:", err)
+	}
+
+	wmovies.Delete(context.Background(), "m1")
+
+	rmovies, _ = reader.Collection(context.Background(), "movies")
+	if n, _ := rmovies.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	if n := rmovies.(interface{ LastChange(context.Context) uint64 }).LastChange(context.Background()); n != 3 {
+		t.Error("unexpected result:", n)
+	}
+
+	if _, err = store.NewStore("local;/" + dir + "?mode=reader&watch=refuse"); err == nil {
+		t.Error("unexpected result")
+	}
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+
+	file "github.com/przebro/localstore/internal/file"
+)
+
+// optShards - new collections are split into a given number of shard files by a hash of a key
+const optShards = "shards"
+
+// Sharding - splits a collection into shard files by a hash of a key or by ranges of keys
+type Sharding = file.Sharding
+
+var errInvalidShards = errors.New("invalid shards value")
+
+// shardCount - returns a number of shards of new collections, zero if they are not sharded
+func shardCount(options map[string]string) (int, error) {
+
+	v := options[optShards]
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || file.ValidSharding(Sharding{Count: n}) != nil {
+		return 0, errInvalidShards
+	}
+
+	return n, nil
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+)
+
+func TestShards(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&shards=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 40; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%02d", i), Year: i})
+	}
+
+	shards, _ := filepath.Glob(filepath.Join(dir, "movies.*.shard"))
+	if len(shards) != 4 {
+		t.Fatal("unexpected result:", shards)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "movies.json")); strings.Contains(string(data), "m01") {
+		t.Error("unexpected result:", string(data))
+	}
+
+	before := map[string]os.FileInfo{}
+	for _, path := range shards {
+		before[path], _ = os.Stat(path)
+	}
+
+	movies.Update(context.Background(), tst.TestDocument{ID: "m01", Title: "updated"})
+
+	written := 0
+	for _, path := range shards {
+		if st, _ := os.Stat(path); !os.SameFile(st, before[path]) {
+			written++
+		}
+	}
+
+	if written != 1 {
+		t.Error("unexpected result:", written)
+	}
+
+	ds.Close(context.Background())
+
+	ds, _ = store.NewStore("local;/" + dir)
+	defer ds.Close(context.Background())
+
+	movies, err = ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 40 {
+		t.Error("unexpected result:", n)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m01", &doc); err != nil || doc.Title != "updated" {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	maxDocs := 10
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "movies", CollectionOptions{MaxDocs: &maxDocs}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if err = ds.(Cataloged).AlterCollection(context.Background(), "movies", CollectionOptions{Shards: &Sharding{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if shards, _ = filepath.Glob(filepath.Join(dir, "movies.*.shard")); len(shards) != 0 {
+		t.Error("unexpected result:", shards)
+	}
+
+	if info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "movies"); info.Shards != nil {
+		t.Error("unexpected result:", info.Shards)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "movies.json")); !strings.Contains(string(data), "m39") {
+		t.Error("unexpected result:", string(data))
+	}
+}
+
+func TestShardsByRange(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	people, err := ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "people", CollectionOptions{
+		Shards: &Sharding{Bounds: []string{"h", "p"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"adam", "helen", "zoe"} {
+		people.Create(context.Background(), tst.TestDocument{ID: id})
+	}
+
+	for i, id := range []string{"adam", "helen", "zoe"} {
+		files, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("people.%03d.*.shard", i)))
+		if len(files) != 1 {
+			t.Fatal("unexpected result:", files)
+		}
+		if data, _ := os.ReadFile(files[0]); !strings.Contains(string(data), id) {
+			t.Error("unexpected result:", i, string(data))
+		}
+	}
+
+	if _, err = ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "invalid", CollectionOptions{
+		Shards: &Sharding{Bounds: []string{"p", "h"}},
+	}); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err = store.NewStore("local;/" + dir + "?shards=1"); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestShardsInterruptedSync(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&shards=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 10; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%02d", i), Year: i})
+	}
+
+	var index string
+	var data []byte
+	shards, _ := filepath.Glob(filepath.Join(dir, "movies.*.shard"))
+	for _, path := range shards {
+		if content, _ := os.ReadFile(path); strings.Contains(string(content), "m05") {
+			index, data = strings.SplitN(filepath.Base(path), ".", 3)[1], content
+		}
+	}
+
+	movies.Delete(context.Background(), "m05")
+	ds.Close(context.Background())
+
+	stray := filepath.Join(dir, "movies."+index+".999.shard")
+	os.WriteFile(stray, data, 0644)
+
+	ds, _ = store.NewStore("local;/" + dir)
+	defer ds.Close(context.Background())
+
+	movies, err = ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 9 {
+		t.Error("unexpected result:", n)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m05", &doc); err == nil {
+		t.Error("unexpected result:", doc)
+	}
+
+	report, err := ds.(Verifiable).Repair(context.Background())
+	if err != nil || len(report.Problems) != 1 || report.Problems[0].File != filepath.Base(stray) || report.Problems[0].Kind != ProblemTemp {
+		t.Error("unexpected result:", report, err)
+	}
+
+	if _, err = os.Stat(stray); !os.IsNotExist(err) {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestShardsManyLines(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	gens := make([]string, 1000)
+	for i := range gens {
+		gens[i] = "1000"
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("movies.%03d.1000.shard", i)), []byte(`{"_localstore":{"version":2,"format":"jsonl"}}`+"\n"), 0644)
+	}
+
+	header := `{"_localstore":{"version":2,"format":"jsonl","shards":[` + strings.Join(gens, ",") + `]}}` + "\n"
+	os.WriteFile(filepath.Join(dir, "movies.json"), []byte(header+`["m001",{"_id":"m001","title":"movie"}]`+"\n"), 0644)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	movies, err := ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m001", &doc); err != nil || doc.Title != "movie" {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	if info, _ := ds.(Cataloged).CollectionInfo(context.Background(), "movies"); info.Format != "jsonl" {
+		t.Error("unexpected result:", info)
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	file "github.com/przebro/localstore/internal/file"
+)
+
+// SnapshotInfo - describes a named snapshot of collections
+type SnapshotInfo = file.SnapshotInfo
+
+// Snapshotter - implemented by stores that can take named snapshots of collections and restore them
+type Snapshotter interface {
+	Snapshot(ctx context.Context, name string, collections ...string) (SnapshotInfo, error)
+	Snapshots(ctx context.Context) ([]SnapshotInfo, error)
+	DropSnapshot(ctx context.Context, name string) error
+	RestoreSnapshot(ctx context.Context, name string, collections ...string) error
+	RestoreAt(ctx context.Context, collection string, t time.Time) error
+}
+
+// Snapshot - takes a named snapshot of given collections or of the whole store if no collections are given
+func (s *localStore) Snapshot(ctx context.Context, name string, collections ...string) (SnapshotInfo, error) {
+	return s.manager.Snapshot(name, collections)
+}
+
+// Snapshots - returns snapshots of the store ordered by the time they were taken
+func (s *localStore) Snapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	return s.manager.Snapshots()
+}
+
+// DropSnapshot - removes a snapshot
+func (s *localStore) DropSnapshot(ctx context.Context, name string) error {
+	return s.manager.DropSnapshot(name)
+}
+
+// RestoreSnapshot - restores given collections or all collections of a snapshot to the state recorded in the snapshot
+func (s *localStore) RestoreSnapshot(ctx context.Context, name string, collections ...string) error {
+	return s.manager.RestoreSnapshot(name, collections, s.synctime, s.updsync)
+}
+
+// RestoreAt - restores a collection to its state at a given time, the collection must be created with History set
+func (s *localStore) RestoreAt(ctx context.Context, collection string, t time.Time) error {
+	return s.manager.RestoreAt(collection, t, s.synctime, s.updsync)
+}
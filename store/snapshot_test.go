@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+	movies.Create(context.Background(), tst.TestDocument{ID: "m2", Title: "second"})
+
+	snapshots := ds.(Snapshotter)
+	info, err := snapshots.Snapshot(context.Background(), "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Collections["movies"] != 2 {
+		t.Error("unexpected result:", info)
+	}
+
+	if _, err = snapshots.Snapshot(context.Background(), "before"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	movies.Update(context.Background(), tst.TestDocument{ID: "m1", Title: "changed"})
+	movies.Delete(context.Background(), "m2")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m3", Title: "third"})
+
+	if err = snapshots.RestoreSnapshot(context.Background(), "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m1", &doc); err != nil || doc.Title != "first" {
+		t.Error("unexpected result:", err, doc)
+	}
+
+	if err = movies.Get(context.Background(), "m2", &doc); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if err = movies.Get(context.Background(), "m3", &doc); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if err = snapshots.RestoreSnapshot(context.Background(), "before", "events"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	list, _ := snapshots.Snapshots(context.Background())
+	if len(list) != 1 || list[0].Name != "before" {
+		t.Error("unexpected result:", list)
+	}
+
+	if err = snapshots.DropSnapshot(context.Background(), "before"); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if list, _ = snapshots.Snapshots(context.Background()); len(list) != 0 {
+		t.Error("unexpected result:", list)
+	}
+}
+
+func TestSnapshotUnloaded(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, _ := store.NewStore("local;/" + dir + "?updatesync=true")
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+	ds.Close(context.Background())
+
+	ds, _ = store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	if _, err := ds.(Snapshotter).Snapshot(context.Background(), "cold", "movies"); err != nil {
+		t.Fatal(err)
+	}
+
+	movies, _ = ds.Collection(context.Background(), "movies")
+	movies.Delete(context.Background(), "m1")
+
+	if err := ds.(Snapshotter).RestoreSnapshot(context.Background(), "cold", "movies"); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err := movies.Get(context.Background(), "m1", &doc); err != nil || doc.Title != "first" {
+		t.Error("unexpected result:", err, doc)
+	}
+}
+
+func TestRestoreAt(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, _ := store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	history := true
+	movies, _ := ds.(Cataloged).CreateCollectionWithOptions(context.Background(), "movies", CollectionOptions{History: &history})
+	movies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+
+	ds.(Snapshotter).Snapshot(context.Background(), "base")
+
+	movies.Create(context.Background(), tst.TestDocument{ID: "m2", Title: "second"})
+	time.Sleep(10 * time.Millisecond)
+	point := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	movies.Update(context.Background(), tst.TestDocument{ID: "m1", Title: "bad batch"})
+	movies.Update(context.Background(), tst.TestDocument{ID: "m2", Title: "bad batch"})
+	movies.Create(context.Background(), tst.TestDocument{ID: "m3", Title: "bad batch"})
+
+	if err := ds.(Snapshotter).RestoreAt(context.Background(), "movies", point); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err := movies.Get(context.Background(), "m1", &doc); err != nil || doc.Title != "first" {
+		t.Error("unexpected result:", err, doc)
+	}
+
+	if err := movies.Get(context.Background(), "m2", &doc); err != nil || doc.Title != "second" {
+		t.Error("unexpected result:", err, doc)
+	}
+
+	if err := movies.Get(context.Background(), "m3", &doc); err == nil {
+		t.Error("unexpected result")
+	}
+
+	plain, _ := ds.CreateCollection(context.Background(), "plain")
+	plain.Create(context.Background(), tst.TestDocument{ID: "p1"})
+	if err := ds.(Snapshotter).RestoreAt(context.Background(), "plain", point); err == nil {
+		t.Error("unexpected result")
+	}
+}
@@ -18,14 +18,25 @@ import (
 
 var localstore = "local"
 
+var errInvalidName = errors.New("invalid collection name")
+
+//ErrSettings - returned when a directory used by an open store is opened with different keys, watch mode or memory budget
+var ErrSettings = file.ErrSettings
+
 const (
-	optSyncTime   = "synctime"
-	optUpdateSync = "updatesync"
+	optSyncTime    = "synctime"
+	optUpdateSync  = "updatesync"
+	optFormat      = "format"
+	optCompression = "compression"
 )
 
 type localStore struct {
 	updsync  bool
 	synctime int
+	format   string
+	compress string
+	paged    bool
+	shards   int
 	manager  file.FileManager
 }
 
@@ -72,19 +83,71 @@ func initLocalstore(opt o.ConnectionOptions) (store.DataStore, error) {
 			return nil, err
 		}
 	}
+	format := opt.Options[optFormat]
+	if format != "" {
+		if _, err := file.GetCodec(format); err != nil {
+			return nil, err
+		}
+	}
+
+	compression := opt.Options[optCompression]
+	if compression != "" {
+		if err := file.ValidCompression(compression); err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := keyProvider(opt.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	watch, err := watchMode(opt.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := readerMode(opt.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	paged, memory, err := pagedOptions(opt.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	shards, err := shardCount(opt.Options)
+	if err != nil {
+		return nil, err
+	}
+
 	m := file.GetFileManager(opt.Path)
+	if reader {
+		if watch == WatchRefuse {
+			return nil, errReaderWatch
+		}
+		m, watch = file.GetSharedReader(opt.Path), WatchReload
+	}
 
-	return &localStore{manager: m, updsync: updsync, synctime: synctime}, nil
+	if err := m.Open(file.Settings{Keys: keys, External: watch, Memory: memory}); err != nil {
+		return nil, err
+	}
+	if err := m.Recover(); err != nil {
+		return nil, err
+	}
+
+	return &localStore{manager: m, updsync: updsync, synctime: synctime, format: format, compress: compression, paged: paged, shards: shards}, nil
 }
 
 //CreateCollection - Creates a new collection
 func (s *localStore) CreateCollection(ctx context.Context, name string) (collection.DataCollection, error) {
 
-	if ok, _ := regexp.Match(`^[A-Za-z][\d\w]{0,31}$`, []byte(name)); !ok {
-		return nil, errors.New("invalid collection name")
+	if !validName(name) {
+		return nil, errInvalidName
 	}
 
-	fdata, err := s.manager.NewData(name, s.synctime, s.updsync)
+	fdata, err := s.manager.Create(name, s.synctime, s.updsync, s.defaults(file.Options{}))
 	if err != nil {
 		return nil, err
 	}
@@ -92,6 +155,34 @@ func (s *localStore) CreateCollection(ctx context.Context, name string) (collect
 	return local.Collection(fdata), nil
 }
 
+//defaults - fills settings of a collection that are not given with settings of the store
+func (s *localStore) defaults(opts file.Options) file.Options {
+
+	if opts.Format == nil && s.format != "" {
+		opts.Format = &s.format
+	}
+
+	if opts.Compression == nil && s.compress != "" {
+		opts.Compression = &s.compress
+	}
+
+	if opts.Paged == nil && s.paged {
+		opts.Paged = &s.paged
+	}
+
+	if opts.Shards == nil && s.shards != 0 {
+		opts.Shards = &Sharding{Count: s.shards}
+	}
+
+	return opts
+}
+
+//validName - checks if a name of a collection is valid
+func validName(name string) bool {
+	ok, _ := regexp.Match(`^[A-Za-z][\d\w]{0,31}$`, []byte(name))
+	return ok
+}
+
 //Collection - gets a collection with a given name or returns an error if collection not found
 func (s *localStore) Collection(ctx context.Context, name string) (collection.DataCollection, error) {
 
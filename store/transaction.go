@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+
+	local "github.com/przebro/localstore/collection"
+	file "github.com/przebro/localstore/internal/file"
+)
+
+// Transactional - implemented by stores that support transactions spanning multiple collections
+type Transactional interface {
+	Begin(ctx context.Context, names ...string) (*Transaction, error)
+}
+
+// Transaction - groups changes made to several collections, changes are committed all-or-nothing
+type Transaction struct {
+	mt    *file.MultiTxn
+	parts map[string]*local.Transaction
+}
+
+// Begin - starts a new transaction over collections with given names
+func (s *localStore) Begin(ctx context.Context, names ...string) (*Transaction, error) {
+
+	mt, err := s.manager.Begin(names, s.synctime, s.updsync)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transaction{mt: mt, parts: map[string]*local.Transaction{}}, nil
+}
+
+// Collection - returns a part of the transaction bound to a collection with a given name,
+// the returned transaction can't be committed or rolled back on its own
+func (t *Transaction) Collection(name string) (*local.Transaction, error) {
+
+	if part, exists := t.parts[name]; exists {
+		return part, nil
+	}
+
+	txn, err := t.mt.Txn(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.parts[name] = local.WrapTransaction(txn)
+
+	return t.parts[name], nil
+}
+
+// Commit - applies changes to all collections, after hooks of collections are called once changes are committed
+func (t *Transaction) Commit(ctx context.Context) error {
+
+	if err := t.mt.Commit(); err != nil {
+		return err
+	}
+
+	for _, part := range t.parts {
+		part.Committed()
+	}
+
+	return nil
+}
+
+// Rollback - discards changes made to all collections
+func (t *Transaction) Rollback(ctx context.Context) error {
+	return t.mt.Rollback()
+}
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+	local "github.com/przebro/localstore/collection"
+)
+
+func TestTransaction(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, _ := ds.CreateCollection(context.Background(), "jobs")
+	events, _ := ds.CreateCollection(context.Background(), "events")
+	events.Create(context.Background(), tst.TestDocument{ID: "event_01"})
+
+	tx, err := ds.(Transactional).Begin(context.Background(), "jobs", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jtx, _ := tx.Collection("jobs")
+	etx, _ := tx.Collection("events")
+
+	if _, err := tx.Collection("missing"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	jtx.Create(context.Background(), tst.TestDocument{ID: "job_01", Title: "job"})
+	etx.Create(context.Background(), tst.TestDocument{ID: "event_02", Title: "job created"})
+
+	if err := jtx.Commit(context.Background()); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if n, _ := jobs.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	if n, _ := events.Count(context.Background()); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	for _, name := range []string{"jobs.json", "events.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error("unexpected result:", err)
+		}
+	}
+
+	tx, _ = ds.(Transactional).Begin(context.Background(), "events", "jobs")
+	jtx, _ = tx.Collection("jobs")
+	etx, _ = tx.Collection("events")
+	jtx.Delete(context.Background(), "job_01")
+	etx.Create(context.Background(), tst.TestDocument{ID: "event_03"})
+	events.Create(context.Background(), tst.TestDocument{ID: "event_03"})
+
+	if err := tx.Commit(context.Background()); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if n, _ := jobs.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+}
+
+func TestTransactionRecovery(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	os.WriteFile(filepath.Join(dir, "jobs.json"), []byte(`{"job_01":{"_id":"job_01"},"job_02":{"_id":"job_02"}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "_txn_01.json"), []byte(`{"id":"01","collections":{
+		"jobs":{"job_01":null,"job_03":{"_id":"job_03"}},
+		"events":{"event_01":{"_id":"event_01"}}}}`), 0644)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := ds.Collection(context.Background(), "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err := jobs.Get(context.Background(), "job_01", &doc); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if n, _ := jobs.Count(context.Background()); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	events, err := ds.Collection(context.Background(), "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := events.Get(context.Background(), "event_01", &doc); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "_txn_01.json")); !os.IsNotExist(err) {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestTransactionRecoveryLaterChanges(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	os.WriteFile(filepath.Join(dir, "jobs.json"), []byte(`{"job_01":{"_id":"job_01","title":"later"},"job_02":{"_id":"job_02"}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "jobs.changes"), []byte(
+		`{"seq":1,"op":"update","key":"job_01","time":"2020-01-01T00:00:00Z"}`+"\n"+
+			`{"seq":2,"op":"update","key":"job_02","time":"2020-01-01T00:00:00Z"}`+"\n"+
+			`{"seq":3,"op":"update","key":"job_01","time":"2020-01-01T00:00:00Z"}`+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "_txn_01.json"), []byte(`{"id":"01","collections":{
+		"jobs":{"job_01":{"_id":"job_01","title":"committed"},"job_02":{"_id":"job_02","title":"committed"}}},
+		"seqs":{"jobs":2}}`), 0644)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	jobs, _ := ds.Collection(context.Background(), "jobs")
+
+	doc := tst.TestDocument{}
+	if jobs.Get(context.Background(), "job_01", &doc); doc.Title != "later" {
+		t.Error("unexpected result:", doc)
+	}
+
+	if jobs.Get(context.Background(), "job_02", &doc); doc.Title != "committed" {
+		t.Error("unexpected result:", doc)
+	}
+}
+
+func TestTransactionRecoveryOnce(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	jobs, _ := ds.CreateCollection(context.Background(), "jobs")
+	jobs.Create(context.Background(), tst.TestDocument{ID: "job_01", Title: "live"})
+
+	//a record written while the manager is open belongs to a commit of this process and is not replayed again
+	os.WriteFile(filepath.Join(dir, "_txn_01.json"), []byte(`{"id":"01","collections":{
+		"jobs":{"job_01":null}}}`), 0644)
+
+	other, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close(context.Background())
+
+	doc := tst.TestDocument{}
+	if err := jobs.Get(context.Background(), "job_01", &doc); err != nil || doc.Title != "live" {
+		t.Error("unexpected result:", err, doc)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "_txn_01.json")); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestTransactionHooks(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	jobs, _ := ds.CreateCollection(context.Background(), "jobs")
+	ds.CreateCollection(context.Background(), "events")
+
+	before, after := []string{}, []string{}
+	jobs.(*local.LocalCollection).Before(func(ctx context.Context, op *local.Operation) error {
+		before = append(before, op.ID)
+		return nil
+	})
+	jobs.(*local.LocalCollection).After(func(op local.Operation) {
+		after = append(after, op.ID)
+	})
+
+	tx, _ := ds.(Transactional).Begin(context.Background(), "jobs", "events")
+	jtx, _ := tx.Collection("jobs")
+	jtx.Create(context.Background(), tst.TestDocument{ID: "job_01"})
+	jtx, _ = tx.Collection("jobs")
+	jtx.Create(context.Background(), tst.TestDocument{ID: "job_02"})
+
+	if len(before) != 2 || len(after) != 0 {
+		t.Error("unexpected result:", before, after)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if len(after) != 2 || after[0] != "job_01" || after[1] != "job_02" {
+		t.Error("unexpected result:", after)
+	}
+}
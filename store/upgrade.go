@@ -0,0 +1,14 @@
+package store
+
+import "context"
+
+// Upgradable - implemented by stores that can rewrite collections stored in an older format
+type Upgradable interface {
+	Upgrade(ctx context.Context) ([]string, error)
+}
+
+// Upgrade - rewrites all collections in the store directory with the current format, returns names of rewritten collections.
+// Collections in an older format can be read without upgrading, they are rewritten on the next sync.
+func (s *localStore) Upgrade(ctx context.Context) ([]string, error) {
+	return s.manager.Upgrade()
+}
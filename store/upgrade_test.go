@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/przebro/databazaar/store"
+)
+
+func TestUpgrade(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	os.WriteFile(filepath.Join(dir, "jobs.json"), []byte(`{"job_01":{"_id":"job_01"},"job_02":{"_id":"job_02"}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "events.json"), []byte(`{}`), 0644)
+	os.WriteFile(filepath.Join(dir, "current.json"), []byte(`{"_localstore":{"version":2},"items":{"c1":{"_id":"c1"}}}`), 0644)
+
+	ds, err := store.NewStore("local;/" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	jobs, err := ds.Collection(context.Background(), "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := jobs.Count(context.Background()); n != 2 {
+		t.Error("unexpected result:", n)
+	}
+
+	current, err := ds.Collection(context.Background(), "current")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := current.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	upgraded, err := ds.(Upgradable).Upgrade(context.Background())
+	if err != nil || len(upgraded) != 2 || upgraded[0] != "events" || upgraded[1] != "jobs" {
+		t.Error("unexpected result:", upgraded, err)
+	}
+
+	for _, name := range []string{"jobs.json", "events.json"} {
+		if data, _ := os.ReadFile(filepath.Join(dir, name)); !strings.HasPrefix(string(data), `{"_localstore":{"version":2},"items":{`) {
+			t.Error("unexpected result:", string(data))
+		}
+	}
+
+	if upgraded, _ = ds.(Upgradable).Upgrade(context.Background()); len(upgraded) != 0 {
+		t.Error("unexpected result:", upgraded)
+	}
+
+	os.WriteFile(filepath.Join(dir, "future.json"), []byte(`{"_localstore":{"version":99},"items":{}}`), 0644)
+
+	if _, err = ds.Collection(context.Background(), "future"); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if _, err = ds.(Upgradable).Upgrade(context.Background()); err == nil {
+		t.Error("unexpected result")
+	}
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+
+	file "github.com/przebro/localstore/internal/file"
+)
+
+// Problem kinds reported by Verify
+const (
+	ProblemCorrupt  = file.ProblemCorrupt
+	ProblemOrphaned = file.ProblemOrphaned
+	ProblemTemp     = file.ProblemTemp
+)
+
+// Report - a result of a verification of a store
+type Report = file.Report
+
+// Problem - a problem with a file in the directory of a store
+type Problem = file.Problem
+
+// ErrChecksum - returned when a collection file doesn't match its checksum
+var ErrChecksum = file.ErrChecksum
+
+// Verifiable - implemented by stores that can check and repair their files
+type Verifiable interface {
+	Verify(ctx context.Context) (Report, error)
+	Repair(ctx context.Context) (Report, error)
+}
+
+// Verify - checks checksums and content of all files of the store and reports corrupt, orphaned and temporary files
+func (s *localStore) Verify(ctx context.Context) (Report, error) {
+	return s.manager.Verify(ctx, false)
+}
+
+// Repair - checks the store like Verify, salvages readable documents from damaged collection files, truncates damaged logs
+// and removes temporary files. A damaged collection file is kept with the .damaged suffix
+func (s *localStore) Repair(ctx context.Context) (Report, error) {
+	return s.manager.Verify(ctx, true)
+}
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+)
+
+func TestVerify(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 10; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%02d", i), Title: "movie"})
+	}
+	ds.Close(context.Background())
+
+	ds, _ = store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	report, err := ds.(Verifiable).Verify(context.Background())
+	if err != nil || !report.OK() || report.Collections != 1 {
+		t.Error("unexpected result:", report, err)
+	}
+
+	fpath := filepath.Join(dir, "movies.json")
+	data, _ := os.ReadFile(fpath)
+	pos := bytes.Index(data, []byte(`"m07"`))
+	data[pos+2] = '!'
+	os.WriteFile(fpath, data, 0644)
+
+	os.WriteFile(filepath.Join(dir, "movies.json.tmp"), []byte("{"), 0644)
+	os.WriteFile(filepath.Join(dir, "ghost.changes"), []byte{}, 0644)
+
+	if _, err = ds.Collection(context.Background(), "movies"); !errors.Is(err, ErrChecksum) {
+		t.Error("unexpected result:", err)
+	}
+
+	report, err = ds.(Verifiable).Verify(context.Background())
+	if err != nil || len(report.Problems) != 3 {
+		t.Fatal("unexpected result:", report, err)
+	}
+
+	kinds := map[string]string{}
+	for _, p := range report.Problems {
+		kinds[p.File] = p.Kind
+	}
+
+	if kinds["movies.json"] != ProblemCorrupt || kinds["movies.json.tmp"] != ProblemTemp || kinds["ghost.changes"] != ProblemOrphaned {
+		t.Error("unexpected result:", report)
+	}
+
+	report, err = ds.(Verifiable).Repair(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range report.Problems {
+		if p.File == "movies.json" && (!p.Repaired || p.Salvaged != 10) {
+			t.Error("unexpected result:", p)
+		}
+	}
+
+	if _, err = os.Stat(fpath + ".damaged"); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	movies, err = ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 10 {
+		t.Error("unexpected result:", n)
+	}
+
+	report, _ = ds.(Verifiable).Verify(context.Background())
+	if len(report.Problems) != 1 || report.Problems[0].File != "ghost.changes" {
+		t.Error("unexpected result:", report)
+	}
+}
+
+func TestRepairLines(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, _ := store.NewStore("local;/" + dir + "?updatesync=true&format=jsonl")
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 5; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%02d", i), Title: "movie"})
+	}
+	ds.Close(context.Background())
+
+	fpath := filepath.Join(dir, "movies.json")
+	data, _ := os.ReadFile(fpath)
+	data = bytes.Replace(data, []byte(`["m02",{`), []byte(`["m02",{{`), 1)
+	os.WriteFile(fpath, data, 0644)
+
+	lpath := filepath.Join(dir, "movies.changes")
+	log, _ := os.ReadFile(lpath)
+	pos := bytes.Index(log, []byte(`"m03"`))
+	log[pos+2] = '9'
+	os.WriteFile(lpath, log, 0644)
+
+	ds, _ = store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	report, err := ds.(Verifiable).Repair(context.Background())
+	if err != nil || len(report.Problems) != 2 {
+		t.Fatal("unexpected result:", report, err)
+	}
+
+	for _, p := range report.Problems {
+		if !p.Repaired || (p.File == "movies.json" && p.Salvaged != 4) {
+			t.Error("unexpected result:", p)
+		}
+	}
+
+	movies, err = ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 4 {
+		t.Error("unexpected result:", n)
+	}
+
+	if n := movies.(interface{ LastChange(context.Context) uint64 }).LastChange(context.Background()); n != 3 {
+		t.Error("unexpected result:", n)
+	}
+}
+
+func TestVerifyHandEdited(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m01", Title: "movie"})
+	ds.Close(context.Background())
+
+	fpath := filepath.Join(dir, "movies.json")
+	data, _ := os.ReadFile(fpath)
+	if !json.Valid(data) {
+		t.Error("unexpected result:", string(data))
+	}
+
+	if _, err = os.Stat(fpath + ".sum"); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	data = bytes.Replace(data, []byte(`"title":"movie"`), []byte(`"title":"edited movie"`), 1)
+	os.WriteFile(fpath, data, 0644)
+
+	ds, _ = store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	if _, err = ds.Collection(context.Background(), "movies"); !errors.Is(err, ErrChecksum) {
+		t.Error("unexpected result:", err)
+	}
+
+	os.Remove(fpath + ".sum")
+
+	movies, err = ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m01", &doc); err != nil || doc.Title != "edited movie" {
+		t.Error("unexpected result:", doc, err)
+	}
+
+	report, err := ds.(Verifiable).Verify(context.Background())
+	if err != nil || !report.OK() {
+		t.Error("unexpected result:", report, err)
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, _ := store.NewStore("local;/" + dir + "?updatesync=true&format=jsonl")
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 5; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%02d", i), Title: "movie"})
+	}
+	ds.Close(context.Background())
+
+	fpath := filepath.Join(dir, "movies.json")
+	data, _ := os.ReadFile(fpath)
+	os.WriteFile(fpath, data[:bytes.Index(data, []byte(`["m04"`))], 0644)
+
+	ds, _ = store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	if _, err := ds.Collection(context.Background(), "movies"); !errors.Is(err, ErrChecksum) {
+		t.Error("unexpected result:", err)
+	}
+
+	report, err := ds.(Verifiable).Verify(context.Background())
+	if err != nil || len(report.Problems) != 1 || report.Problems[0].Kind != ProblemCorrupt {
+		t.Error("unexpected result:", report, err)
+	}
+}
+
+func TestRepairShards(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, _ := store.NewStore("local;/" + dir + "?updatesync=true&shards=4")
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	for i := 0; i < 20; i++ {
+		movies.Create(context.Background(), tst.TestDocument{ID: fmt.Sprintf("m%02d", i), Title: "movie"})
+	}
+	ds.Close(context.Background())
+
+	fpath := filepath.Join(dir, "movies.json")
+	os.WriteFile(fpath, []byte(`{"_localstore":{"vers`), 0644)
+
+	ds, _ = store.NewStore("local;/" + dir + "?updatesync=true")
+	defer ds.Close(context.Background())
+
+	report, err := ds.(Verifiable).Repair(context.Background())
+	if err != nil || len(report.Problems) != 1 || !report.Problems[0].Repaired || report.Problems[0].Salvaged != 20 {
+		t.Fatal("unexpected result:", report, err)
+	}
+
+	if shards, _ := filepath.Glob(filepath.Join(dir, "movies.*.shard")); len(shards) != 4 {
+		t.Error("unexpected result:", shards)
+	}
+
+	movies, err = ds.Collection(context.Background(), "movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 20 {
+		t.Error("unexpected result:", n)
+	}
+
+	report, err = ds.(Verifiable).Verify(context.Background())
+	if err != nil || !report.OK() {
+		t.Error("unexpected result:", report, err)
+	}
+}
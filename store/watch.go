@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	file "github.com/przebro/localstore/internal/file"
+)
+
+// optWatch - watches collection files for changes made by another process, the value is reload or refuse
+const optWatch = "watch"
+
+// Modes of the watch option
+const (
+	WatchReload = file.ExternalReload
+	WatchRefuse = file.ExternalRefuse
+)
+
+// ErrConflict - returned when a collection file was changed by another process and the store refuses to overwrite it
+var ErrConflict = file.ErrConflict
+
+var errInvalidWatch = errors.New("invalid watch value, it must be reload or refuse")
+
+// Watched - implemented by stores that detect collection files changed by another process
+type Watched interface {
+	Conflicts(ctx context.Context) []string
+	Reload(ctx context.Context, name string) error
+	Overwrite(ctx context.Context, name string) error
+}
+
+// Conflicts - returns names of collections that are not written because their files were changed by another process
+func (s *localStore) Conflicts(ctx context.Context) []string {
+	return s.manager.Conflicts()
+}
+
+// Reload - resolves a conflict of a collection by reading its file, changes that are not synced are discarded
+func (s *localStore) Reload(ctx context.Context, name string) error {
+
+	fdata, err := s.manager.GetData(name, s.synctime, s.updsync)
+	if err != nil {
+		return err
+	}
+
+	return fdata.Reload()
+}
+
+// Overwrite - resolves a conflict of a collection by writing the collection over its changed file
+func (s *localStore) Overwrite(ctx context.Context, name string) error {
+
+	fdata, err := s.manager.GetData(name, s.synctime, s.updsync)
+	if err != nil {
+		return err
+	}
+
+	return fdata.Overwrite()
+}
+
+// watchMode - returns a mode given by the watch option, an empty mode if files are not watched
+func watchMode(options map[string]string) (string, error) {
+
+	switch mode := options[optWatch]; mode {
+	case "", WatchReload, WatchRefuse:
+		return mode, nil
+	}
+
+	return "", errInvalidWatch
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tst "github.com/przebro/databazaar/collection/testing"
+	"github.com/przebro/databazaar/store"
+)
+
+func writeExternal(t *testing.T, path, content string) {
+
+	t.Helper()
+
+	if err := os.WriteFile(path+".ext", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(path+".ext", path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchReload(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&watch=reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+
+	writeExternal(t, filepath.Join(dir, "movies.json"), `{"_localstore":{"version":2},"items":{"m2":{"_id":"m2","title":"edited"}}}`)
+
+	doc := tst.TestDocument{}
+	deadline := time.Now().Add(5 * time.Second)
+	for movies.Get(context.Background(), "m2", &doc) != nil && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if doc.Title != "edited" {
+		t.Fatal("unexpected result:", doc)
+	}
+
+	if err = movies.Get(context.Background(), "m1", &doc); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if conflicts := ds.(Watched).Conflicts(context.Background()); len(conflicts) != 0 {
+		t.Error("unexpected result:", conflicts)
+	}
+}
+
+func TestWatchRefuse(t *testing.T) {
+
+	dir, _ := os.MkdirTemp("", "localstore")
+	defer os.RemoveAll(dir)
+
+	ds, err := store.NewStore("local;/" + dir + "?updatesync=true&watch=refuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close(context.Background())
+
+	movies, _ := ds.CreateCollection(context.Background(), "movies")
+	movies.Create(context.Background(), tst.TestDocument{ID: "m1", Title: "first"})
+
+	fpath := filepath.Join(dir, "movies.json")
+	edited := `{"_localstore":{"version":2},"items":{"m2":{"_id":"m2","title":"edited"}}}`
+	writeExternal(t, fpath, edited)
+
+	movies.Create(context.Background(), tst.TestDocument{ID: "m3", Title: "third"})
+
+	if data, _ := os.ReadFile(fpath); string(data) != edited {
+		t.Error("unexpected result:", string(data))
+	}
+
+	watched := ds.(Watched)
+	if conflicts := watched.Conflicts(context.Background()); len(conflicts) != 1 || conflicts[0] != "movies" {
+		t.Fatal("unexpected result:", conflicts)
+	}
+
+	if err = watched.Overwrite(context.Background(), "movies"); err != nil {
+		t.Fatal(err)
+	}
+
+	if conflicts := watched.Conflicts(context.Background()); len(conflicts) != 0 {
+		t.Error("unexpected result:", conflicts)
+	}
+
+	writeExternal(t, fpath, edited)
+	movies.Delete(context.Background(), "m1")
+
+	if err = watched.Reload(context.Background(), "movies"); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := tst.TestDocument{}
+	if err = movies.Get(context.Background(), "m2", &doc); err != nil || doc.Title != "edited" {
+		t.Error("unexpected result:", err, doc)
+	}
+
+	if n, _ := movies.Count(context.Background()); n != 1 {
+		t.Error("unexpected result:", n)
+	}
+
+	if _, err = store.NewStore("local;/" + dir + "?watch=ignore"); err == nil {
+		t.Error("unexpected result")
+	}
+}